@@ -1,16 +1,21 @@
 package agent
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/m4xw311/compell/checkpoint"
 	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
 	"github.com/m4xw311/compell/llm"
+	"github.com/m4xw311/compell/secrets"
 	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/shadow"
 	"github.com/m4xw311/compell/tools"
 )
 
@@ -37,8 +42,51 @@ type Agent struct {
 	AvailableTools []tools.Tool
 	Mode           Mode
 	Verbosity      ToolVerbosity
+	// Input is where user prompts and approvals are read from. Defaults to os.Stdin.
+	Input io.Reader
+	// Interrupts delivers interrupt signals (e.g. Ctrl-C). The first interrupt
+	// cancels the in-flight turn, a second one ends the session. May be nil.
+	Interrupts <-chan os.Signal
+	// Checkpoints records the files changed in each turn so they can be
+	// undone. May be nil, in which case changes are not recorded.
+	Checkpoints *checkpoint.Store
+	// Redactor removes secrets from tool output before it is recorded in the
+	// session. May be nil, in which case output is recorded as is.
+	Redactor *secrets.Redactor
+	// Shadow commits the changes of each turn to the session's shadow ref.
+	// May be nil, in which case no shadow commits are made.
+	Shadow *shadow.Recorder
+	// Headless marks a run without a user, e.g. a task run for an MCP client.
+	// Tool calls and sampling requests that need approval are refused.
+	Headless bool
+	// Progress is told about the work of a turn, e.g. the tools called. May
+	// be nil.
+	Progress func(message string)
+
+	input    *inputReader
+	registry *tools.ToolRegistry
+	toolset  *config.Toolset // The toolset AvailableTools were looked up for
+
+	mu    sync.Mutex
+	guard *turnGuard // Limits of the running turn, which sampling requests count against
 }
 
+// interruptedMarker is recorded in the session when the user cancels a turn,
+// so that both the transcript and the model know the turn was cut short.
+const interruptedMarker = "[Interrupted by user]"
+
+// errExitRequested is returned by runTurn when the user asked to end the
+// session while a turn was being cancelled.
+var errExitRequested = errors.New("exit requested")
+
+// exitGracePeriod is how long runTurn waits for a cancelled turn to stop after
+// the user asked to exit. A tool that ignores cancellation is left behind.
+var exitGracePeriod = 2 * time.Second
+
+// errTurnTimeout is the cancellation cause used when a turn exceeds the
+// configured wall-clock limit, distinguishing it from a user interrupt.
+var errTurnTimeout = errors.New("turn time limit exceeded")
+
 func New(cfg *config.Config, sess *session.Session, toolset string, mode Mode, client llm.LLMClient, verbosity ToolVerbosity) (*Agent, error) {
 	ts, err := cfg.GetToolset(toolset)
 	if err != nil {
@@ -46,38 +94,90 @@ func New(cfg *config.Config, sess *session.Session, toolset string, mode Mode, c
 	}
 
 	registry := tools.NewToolRegistry(cfg)
+	delegate := &delegateTaskTool{}
+	registry.Register(delegate)
+	sampler := &sampler{}
+	registry.SetMCPSampler(sampler.createMessage)
 	activeTools, err := registry.GetActiveTools(ts)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get active tools")
 	}
 
-	return &Agent{
+	checkpoints, err := checkpoint.Open(sess.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open checkpoint store")
+	}
+
+	redactor, err := secrets.NewRedactor(&cfg.Redaction)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set up redaction")
+	}
+	// Credentials configured for MCP servers are redacted like compell's own.
+	redactor.AddSecrets(tools.MCPServerSecrets(cfg))
+
+	var shadowRecorder *shadow.Recorder
+	if cfg.ShadowCommits {
+		shadowRecorder, err = shadow.Open(sess.Name, &cfg.FilesystemAccess)
+		if err != nil {
+			fmt.Printf("Warning: shadow commits are disabled: %v\n", err)
+		}
+	}
+
+	a := &Agent{
 		Config:         cfg,
 		Session:        sess,
 		LLMClient:      client,
 		AvailableTools: activeTools,
 		Mode:           mode,
 		Verbosity:      verbosity,
-	}, nil
+		Checkpoints:    checkpoints,
+		Redactor:       redactor,
+		Shadow:         shadowRecorder,
+		registry:       registry,
+		toolset:        ts,
+	}
+	delegate.parent = a
+	sampler.agent = a
+	return a, nil
 }
 
 func (a *Agent) Run(ctx context.Context, initialPrompt string) error {
+	// Background processes started by the agent do not outlive the session.
+	defer a.Close()
+
 	// If there's an initial prompt from the command line, use it first.
 	if initialPrompt != "" {
-		if err := a.processTurn(ctx, initialPrompt); err != nil {
+		if err := a.runTurn(ctx, a.expandAttachments(ctx, initialPrompt)); err != nil {
+			if errors.Is(err, errExitRequested) {
+				return a.Session.Save()
+			}
 			return err
 		}
 	}
 
-	scanner := bufio.NewScanner(os.Stdin)
+	interruptedAtPrompt := false
 	for {
 		fmt.Print("You: ")
-		if !scanner.Scan() {
-			// EOF or read error ends the session
+		line, err := a.readLine(ctx, a.Interrupts)
+		if errors.Is(err, errInterrupted) {
+			if interruptedAtPrompt {
+				fmt.Println()
+				break
+			}
+			interruptedAtPrompt = true
+			fmt.Println("\n(Press Ctrl-C again to exit)")
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			// EOF ends the session
 			break
 		}
+		if err != nil {
+			return err
+		}
+		interruptedAtPrompt = false
 
-		userInput := strings.TrimSpace(scanner.Text())
+		userInput := strings.TrimSpace(line)
 		if userInput == "" {
 			continue
 		}
@@ -87,26 +187,137 @@ func (a *Agent) Run(ctx context.Context, initialPrompt string) error {
 			break
 		}
 
-		if err := a.processTurn(ctx, userInput); err != nil {
+		if prompt, ok := a.promptCommand(ctx, userInput); ok {
+			if prompt == "" {
+				continue
+			}
+			userInput = prompt
+		} else if a.handleCommand(userInput) {
+			continue
+		}
+		userInput = a.expandAttachments(ctx, userInput)
+
+		if err := a.runTurn(ctx, userInput); err != nil {
+			if errors.Is(err, errExitRequested) {
+				break
+			}
 			fmt.Printf("Error: %v\n", err)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	return a.Session.Save()
+}
+
+// runTurn processes a single user prompt. The first interrupt received while
+// the turn is in flight cancels it and returns control to the prompt; a second
+// one also asks the caller to end the session once the turn has wound down, or
+// after exitGracePeriod if it does not.
+func (a *Agent) runTurn(ctx context.Context, userInput string) error {
+	a.refreshTools()
+	turnCtx, cancel := context.WithCancel(session.WithSession(ctx, a.Session))
+	defer cancel()
+
+	if a.Checkpoints != nil {
+		a.Checkpoints.Begin(userInput)
+		turnCtx = checkpoint.WithStore(turnCtx, a.Checkpoints)
+		defer a.commitCheckpoint()
+	}
+	if a.Shadow != nil {
+		if err := a.Shadow.Begin(ctx); err != nil {
+			fmt.Printf("Warning: failed to snapshot the working tree: %v\n", err)
+		} else {
+			defer a.commitShadow(ctx, userInput)
+		}
 	}
 
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- a.processTurn(turnCtx, userInput)
+	}()
+
+	interrupted, exitRequested := false, false
+	var grace <-chan time.Time
+	for {
+		select {
+		case err := <-done:
+			if !interrupted {
+				return err
+			}
+			a.recordInterruption()
+			if exitRequested {
+				return errExitRequested
+			}
+			return nil
+		case <-grace:
+			fmt.Println("Warning: the current turn did not stop in time; exiting anyway.")
+			a.recordInterruption()
+			return errExitRequested
+		case <-a.Interrupts:
+			if interrupted {
+				if !exitRequested {
+					exitRequested = true
+					grace = time.After(exitGracePeriod)
+					fmt.Println("\nExiting once the current turn has stopped...")
+				}
+				continue
+			}
+			interrupted = true
+			fmt.Println("\nInterrupted. Cancelling the current turn (press Ctrl-C again to exit)...")
+			cancel()
+		}
+	}
+}
+
+// recordInterruption marks the session as interrupted by the user and saves it.
+func (a *Agent) recordInterruption() {
+	a.Session.AddMessage(session.Message{
+		Role:       "user",
+		Content:    interruptedMarker,
+		StopReason: string(StopInterrupted),
+	})
+	if err := a.Session.Save(); err != nil {
+		fmt.Printf("Warning: failed to save session: %v\n", err)
+	}
+}
+
+// setGuard sets the limits of the running turn, or nil between turns.
+func (a *Agent) setGuard(guard *turnGuard) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.guard = guard
+}
+
+// currentGuard returns the limits of the running turn, or nil between turns.
+func (a *Agent) currentGuard() *turnGuard {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.guard
 }
 
 func (a *Agent) processTurn(ctx context.Context, userInput string) error {
 	userMsg := session.Message{Role: "user", Content: userInput}
 	a.Session.AddMessage(userMsg)
 
+	guard := newTurnGuard(a.Config.Limits)
+	a.setGuard(guard)
+	defer a.setGuard(nil)
+	if limit := a.Config.Limits.MaxDuration; limit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, limit, errTurnTimeout)
+		defer cancel()
+	}
+
 	// Main loop: LLM -> Tool -> LLM ...
 	for {
+		if stop := guard.beforeLLMCall(); stop != nil {
+			return a.stopTurn(stop)
+		}
+
 		assistantResponse, err := a.LLMClient.Chat(ctx, a.Session.Messages, a.AvailableTools)
 		if err != nil {
+			if context.Cause(ctx) == errTurnTimeout {
+				return a.stopTurn(guard.durationStop())
+			}
 			return errors.Wrapf(err, "LLM chat failed")
 		}
 
@@ -128,27 +339,61 @@ func (a *Agent) processTurn(ctx context.Context, userInput string) error {
 
 		// --- Tool Execution Phase ---
 
+		stop := guard.afterLLMCall(assistantResponse)
 		var toolResultMessages []session.Message
 
 		for _, toolCall := range assistantResponse.ToolCalls {
-			toolResult, err := a.executeToolCall(ctx, toolCall)
-			if err != nil {
-				// If there was an error during tool execution (e.g., tool not found),
-				// format it as a message to be sent back to the LLM.
-				toolResult = fmt.Sprintf("Error executing tool %s: %v", toolCall.Name, err)
+			if stop == nil && ctx.Err() == nil {
+				stop = guard.beforeToolCall()
+			}
+
+			// Every tool call needs a result for the history to stay valid,
+			// even the ones skipped because the turn is ending.
+			var toolResult session.ToolResult
+			switch {
+			case context.Cause(ctx) == errTurnTimeout:
+				toolResult = session.ErrorResult(fmt.Sprintf("Tool call skipped: %s.", guard.durationStop().Detail))
+			case ctx.Err() != nil:
+				toolResult = session.ErrorResult("Tool execution was interrupted by the user.")
+			case stop != nil:
+				toolResult = session.ErrorResult(fmt.Sprintf("Tool call skipped: %s.", stop.Detail))
+			default:
+				plan := session.RenderTodos(a.Session.Todos)
+				a.reportProgress(fmt.Sprintf("Calling tool `%s`", toolCall.Name))
+				toolResult, err = a.executeToolCall(ctx, toolCall)
+				if err != nil {
+					// If there was an error during tool execution (e.g., tool not found),
+					// format it as a message to be sent back to the LLM.
+					toolResult = session.ErrorResult(fmt.Sprintf("Error executing tool %s: %v", toolCall.Name, err))
+					stop = guard.afterToolFailure(toolCall, err)
+				} else if toolResult.IsError && !toolResult.Metadata.Denied {
+					stop = guard.afterToolFailure(toolCall, errors.New("%s", toolResult.Text()))
+				}
+				for i, part := range toolResult.Content {
+					if part.Type == session.PartText {
+						toolResult.Content[i].Text = a.Redactor.Redact(part.Text)
+					}
+				}
+				// Show the plan to the user whenever the model updates it.
+				if updated := session.RenderTodos(a.Session.Todos); updated != plan {
+					fmt.Printf("Plan:\n%s\n", updated)
+				}
 			}
 
 			if a.Verbosity == ToolVerbosityAll {
-				fmt.Printf("Tool `%s` output: %s\n", toolCall.Name, toolResult)
+				fmt.Printf("Tool `%s` output: %s\n", toolCall.Name, toolResult.Text())
+			} else if a.Verbosity == ToolVerbosityInfo && toolResult.IsError {
+				fmt.Printf("Tool `%s` failed.\n", toolCall.Name)
 			}
 
 			// Create a message with the tool's output.
 			toolMsg := session.Message{
 				Role:    "tool",
-				Content: toolResult,
+				Content: toolResult.Text(),
 				ToolCalls: []session.ToolCall{
 					{ToolCallID: toolCall.ToolCallID, Name: toolCall.Name},
 				},
+				Result: &toolResult,
 			}
 			toolResultMessages = append(toolResultMessages, toolMsg)
 		}
@@ -157,13 +402,62 @@ func (a *Agent) processTurn(ctx context.Context, userInput string) error {
 		for _, msg := range toolResultMessages {
 			a.Session.AddMessage(msg)
 		}
+
+		if context.Cause(ctx) == errTurnTimeout {
+			stop = guard.durationStop()
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stop != nil {
+			return a.stopTurn(stop)
+		}
 		// Continue the loop to send the tool results back to the LLM.
 	}
 
 	return nil
 }
 
-func (a *Agent) executeToolCall(ctx context.Context, toolCall session.ToolCall) (string, error) {
+// commitCheckpoint finishes the checkpoint of the turn that just ended.
+func (a *Agent) commitCheckpoint() {
+	cp, err := a.Checkpoints.Commit()
+	if err != nil {
+		fmt.Printf("Warning: failed to save checkpoint: %v\n", err)
+		return
+	}
+	if cp != nil {
+		fmt.Printf("Saved checkpoint %s (%d path(s) changed). Use /undo to revert.\n", cp.ID, len(cp.Entries))
+	}
+}
+
+// commitShadow records the changes of the turn that just ended on the shadow ref.
+func (a *Agent) commitShadow(ctx context.Context, prompt string) {
+	// The turn may have been cancelled, but its changes are still recorded.
+	c, err := a.Shadow.End(context.WithoutCancel(ctx), prompt)
+	if err != nil {
+		fmt.Printf("Warning: failed to make shadow commit: %v\n", err)
+		return
+	}
+	if c != nil {
+		fmt.Printf("Recorded shadow commit %.8s on %s (%d path(s) changed). Use /shadow to list.\n", c.Hash, a.Shadow.Ref(), c.Files)
+	}
+}
+
+// stopTurn ends a turn that hit one of the configured limits, telling the user
+// why and recording the reason in the session.
+func (a *Agent) stopTurn(stop *turnStop) error {
+	fmt.Printf("Compell stopped: %s.\n", stop.Detail)
+	a.Session.AddMessage(session.Message{
+		Role:       "assistant",
+		Content:    stop.String(),
+		StopReason: string(stop.Reason),
+	})
+	if err := a.Session.Save(); err != nil {
+		fmt.Printf("Warning: failed to save session: %v\n", err)
+	}
+	return nil
+}
+
+func (a *Agent) executeToolCall(ctx context.Context, toolCall session.ToolCall) (session.ToolResult, error) {
 	var targetTool tools.Tool
 	for _, t := range a.AvailableTools {
 		if t.Name() == toolCall.Name {
@@ -173,25 +467,56 @@ func (a *Agent) executeToolCall(ctx context.Context, toolCall session.ToolCall)
 	}
 
 	if targetTool == nil {
-		return "", errors.New("tool '%s' not found in the available toolset", toolCall.Name)
+		return session.ToolResult{}, errors.New("tool '%s' not found in the available toolset", toolCall.Name)
 	}
 
-	if a.Verbosity == ToolVerbosityAll {
-		fmt.Printf("Compell wants to call tool `%s` with args: %v\n", toolCall.Name, toolCall.Args)
-	} else if a.Verbosity == ToolVerbosityInfo {
-		fmt.Printf("Compell wants to call tool `%s`\n", toolCall.Name)
+	// Apply the approval policy, asking the user when needed.
+	action, err := a.approvalFor(toolCall)
+	if err != nil {
+		return session.ToolResult{}, err
 	}
-
-	// In prompt mode, ask for user confirmation.
-	if a.Mode == ModePrompt {
-		fmt.Print("Do you want to allow this? (y/n): ")
-		reader := bufio.NewReader(os.Stdin)
-		answer, _ := reader.ReadString('\n')
-		if strings.TrimSpace(strings.ToLower(answer)) != "y" {
-			return "User denied tool execution.", nil
+	switch action {
+	case config.PolicyDeny:
+		fmt.Printf("Tool `%s` denied by approval policy.\n", toolCall.Name)
+		return session.ErrorResult("Tool execution denied by policy."), nil
+	case config.PolicyAsk:
+		if a.Headless {
+			fmt.Printf("Tool `%s` needs approval, which cannot be given in a headless run.\n", toolCall.Name)
+			return session.ErrorResult("Tool execution needs the user's approval, which cannot be given in a headless run."), nil
+		}
+		// The approval prompt always shows the full call, whatever the verbosity.
+		decision, err := a.askApproval(ctx, toolCall, targetTool)
+		if err != nil {
+			return session.ToolResult{}, err
+		}
+		if decision.Feedback != "" {
+			return deniedResult(fmt.Sprintf("User rejected the tool call with feedback: %s", decision.Feedback)), nil
+		}
+		if !decision.Approved {
+			return deniedResult("User denied tool execution."), nil
+		}
+	default:
+		if a.Verbosity == ToolVerbosityAll {
+			fmt.Printf("Compell wants to call tool `%s` with args: %v\n", toolCall.Name, toolCall.Args)
+		} else if a.Verbosity == ToolVerbosityInfo {
+			fmt.Printf("Compell wants to call tool `%s`\n", toolCall.Name)
 		}
 	}
 
 	// Execute the tool.
-	return targetTool.Execute(ctx, toolCall.Args)
+	start := time.Now()
+	result, err := targetTool.Execute(ctx, toolCall.Args)
+	if err == nil && result.Metadata.Duration == 0 {
+		result.Metadata.Duration = time.Since(start)
+	}
+	return result, err
+}
+
+// deniedResult returns the result of a call the user refused to run. Refusals
+// are not failures of the model, so they do not count towards
+// max_repeated_failures.
+func deniedResult(text string) session.ToolResult {
+	result := session.ErrorResult(text)
+	result.Metadata.Denied = true
+	return result
 }
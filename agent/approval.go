@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+)
+
+// patternArgs are the arguments used to build an "always allow this pattern"
+// rule, in order of preference.
+var patternArgs = []string{"command", "path"}
+
+// approvalFor decides whether a tool call may run. Deny and allow rules from
+// the configuration are final. Calls that would otherwise need confirmation
+// are allowed if the user approved a matching rule earlier in the session.
+func (a *Agent) approvalFor(toolCall session.ToolCall) (config.PolicyAction, error) {
+	action, matched, err := tools.EvaluatePolicy(a.Config.ApprovalRules, toolCall.Name, toolCall.Args)
+	if err != nil {
+		return "", err
+	}
+	if !matched {
+		action = config.PolicyAllow
+		if a.Mode == ModePrompt {
+			action = config.PolicyAsk
+		}
+	}
+	if action != config.PolicyAsk {
+		return action, nil
+	}
+
+	sessionAction, matched, err := tools.EvaluatePolicy(a.Session.ApprovalRules, toolCall.Name, toolCall.Args)
+	if err != nil {
+		return "", err
+	}
+	if matched {
+		return sessionAction, nil
+	}
+	return config.PolicyAsk, nil
+}
+
+// approvalDecision is the user's answer to an approval prompt.
+type approvalDecision struct {
+	Approved bool
+	// Feedback is optional text the user gave when rejecting the call. It is
+	// returned to the model as the tool result.
+	Feedback string
+}
+
+// askApproval shows the user exactly what a tool call will do and asks for
+// approval. Besides a one-off yes or no, the user may reject the call with
+// feedback for the model, or allow the tool, or calls matching this call's
+// command or path, for the rest of the session. Such choices are saved in the
+// session.
+func (a *Agent) askApproval(ctx context.Context, toolCall session.ToolCall, tool tools.Tool) (approvalDecision, error) {
+	fmt.Printf("Compell wants to call tool `%s`:\n%s\n", toolCall.Name, describeToolCall(toolCall, tool))
+
+	patternRule, hasPattern := sessionPatternRule(toolCall)
+
+	options := fmt.Sprintf("[y]es, [n]o, [r]eject with feedback, [a]lways allow `%s`", toolCall.Name)
+	if hasPattern {
+		for name, pattern := range patternRule.Args {
+			options += fmt.Sprintf(", always allow this [p]attern (%s = %s)", name, pattern)
+		}
+	}
+
+	for {
+		fmt.Printf("Do you want to allow this? %s: ", options)
+		answer, err := a.readLine(ctx, nil)
+		if err != nil {
+			return approvalDecision{}, err
+		}
+
+		switch strings.TrimSpace(strings.ToLower(answer)) {
+		case "y", "yes":
+			return approvalDecision{Approved: true}, nil
+		case "n", "no", "":
+			return approvalDecision{}, nil
+		case "r", "reject":
+			fmt.Print("Feedback for Compell: ")
+			feedback, err := a.readLine(ctx, nil)
+			if err != nil {
+				return approvalDecision{}, err
+			}
+			return approvalDecision{Feedback: strings.TrimSpace(feedback)}, nil
+		case "a", "always":
+			a.rememberApproval(config.PolicyRule{Tool: toolCall.Name, Action: config.PolicyAllow})
+			return approvalDecision{Approved: true}, nil
+		case "p", "pattern":
+			if hasPattern {
+				a.rememberApproval(patternRule)
+				return approvalDecision{Approved: true}, nil
+			}
+		}
+		fmt.Println("Please answer with one of the listed options.")
+	}
+}
+
+// describeToolCall renders a tool call for the approval prompt, using the
+// tool's own preview (e.g. a diff) when it has one, followed by the arguments
+// the preview does not show, and falling back to the full arguments
+// otherwise.
+func describeToolCall(toolCall session.ToolCall, tool tools.Tool) string {
+	description := formatArgs("Arguments", toolCall.Args)
+
+	previewer, ok := tool.(tools.Previewer)
+	if !ok {
+		return description
+	}
+	preview, err := previewer.Preview(toolCall.Args)
+	if err != nil {
+		return fmt.Sprintf("%s\n(Preview unavailable: %v)", description, err)
+	}
+	if useColor() {
+		preview = colorizeDiff(preview)
+	}
+	others := make(map[string]interface{})
+	for name, value := range toolCall.Args {
+		if !contains(previewer.PreviewedArgs(), name) {
+			others[name] = value
+		}
+	}
+	if len(others) > 0 {
+		preview += "\n" + formatArgs("Other arguments", others)
+	}
+	return preview
+}
+
+// formatArgs renders tool call arguments as indented JSON after label.
+func formatArgs(label string, args map[string]interface{}) string {
+	data, err := json.MarshalIndent(args, "", "  ")
+	if err != nil {
+		data = []byte(fmt.Sprintf("%v", args))
+	}
+	return label + ": " + string(data)
+}
+
+// useColor reports whether stdout is a terminal that can show ANSI colors.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorizeDiff adds ANSI colors to the lines of a unified diff.
+func colorizeDiff(diff string) string {
+	const (
+		red   = "\x1b[31m"
+		green = "\x1b[32m"
+		cyan  = "\x1b[36m"
+		bold  = "\x1b[1m"
+		reset = "\x1b[0m"
+	)
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = bold + line + reset
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = cyan + line + reset
+		case strings.HasPrefix(line, "+"):
+			lines[i] = green + line + reset
+		case strings.HasPrefix(line, "-"):
+			lines[i] = red + line + reset
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// rememberApproval records an "always allow" rule in the session.
+func (a *Agent) rememberApproval(rule config.PolicyRule) {
+	a.Session.ApprovalRules = append(a.Session.ApprovalRules, rule)
+	if err := a.Session.Save(); err != nil {
+		fmt.Printf("Warning: failed to save session: %v\n", err)
+	}
+}
+
+// sessionPatternRule builds a rule allowing calls to the same tool with the
+// same command or path as toolCall. Command lines are matched per simple
+// command, so a command line with several commands has no such pattern.
+func sessionPatternRule(toolCall session.ToolCall) (config.PolicyRule, bool) {
+	for _, name := range patternArgs {
+		value, ok := toolCall.Args[name].(string)
+		if !ok || value == "" {
+			continue
+		}
+		switch name {
+		case "command":
+			commands, err := tools.SimpleCommands(value)
+			if err != nil || len(commands) != 1 {
+				return config.PolicyRule{}, false
+			}
+			value = commands[0]
+		case "path":
+			value = filepath.Clean(value)
+		}
+		return config.PolicyRule{
+			Tool:   toolCall.Name,
+			Args:   map[string]string{name: regexp.QuoteMeta(value)},
+			Action: config.PolicyAllow,
+		}, true
+	}
+	return config.PolicyRule{}, false
+}
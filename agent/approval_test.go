@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+)
+
+func TestApprovalFor(t *testing.T) {
+	a := &Agent{
+		Config: &config.Config{ApprovalRules: []config.PolicyRule{
+			{Tool: "read_*", Action: config.PolicyAllow},
+			{Tool: "execute_command", Args: map[string]string{"command": "rm .*"}, Action: config.PolicyDeny},
+		}},
+		Session: &session.Session{ApprovalRules: []config.PolicyRule{
+			{Tool: "execute_command", Action: config.PolicyAllow},
+		}},
+		Mode: ModePrompt,
+	}
+
+	tests := []struct {
+		call session.ToolCall
+		want config.PolicyAction
+	}{
+		{session.ToolCall{Name: "read_file"}, config.PolicyAllow},
+		{session.ToolCall{Name: "write_file"}, config.PolicyAsk},
+		// Session approvals never override a configured deny.
+		{session.ToolCall{Name: "execute_command", Args: map[string]interface{}{"command": "rm -rf /"}}, config.PolicyDeny},
+		{session.ToolCall{Name: "execute_command", Args: map[string]interface{}{"command": "ls"}}, config.PolicyAllow},
+	}
+	for _, tt := range tests {
+		got, err := a.approvalFor(tt.call)
+		if err != nil {
+			t.Fatalf("approvalFor(%+v): %v", tt.call, err)
+		}
+		if got != tt.want {
+			t.Errorf("approvalFor(%+v) = %q, want %q", tt.call, got, tt.want)
+		}
+	}
+
+	a.Mode = ModeAuto
+	if got, _ := a.approvalFor(session.ToolCall{Name: "write_file"}); got != config.PolicyAllow {
+		t.Errorf("auto mode default = %q, want %q", got, config.PolicyAllow)
+	}
+}
+
+func TestAskApprovalRemembersPattern(t *testing.T) {
+	a := &Agent{
+		Config:  &config.Config{},
+		Session: newTestSession(t),
+		Mode:    ModePrompt,
+		Input:   strings.NewReader("p\n"),
+	}
+	call := session.ToolCall{Name: "execute_command", Args: map[string]interface{}{"command": "go test ./..."}}
+
+	decision, err := a.askApproval(context.Background(), call, &tools.ExecuteCommandTool{})
+	if err != nil || !decision.Approved {
+		t.Fatalf("askApproval = %+v, %v; want approval", decision, err)
+	}
+
+	if got, _ := a.approvalFor(call); got != config.PolicyAllow {
+		t.Errorf("same command after pattern approval = %q, want %q", got, config.PolicyAllow)
+	}
+	other := session.ToolCall{Name: "execute_command", Args: map[string]interface{}{"command": "go test ./... ; rm -rf /"}}
+	if got, _ := a.approvalFor(other); got != config.PolicyAsk {
+		t.Errorf("different command after pattern approval = %q, want %q", got, config.PolicyAsk)
+	}
+}
+
+func TestAskApprovalRejectWithFeedback(t *testing.T) {
+	a := &Agent{
+		Config:  &config.Config{},
+		Session: newTestSession(t),
+		Mode:    ModePrompt,
+		Input:   strings.NewReader("r\nuse go vet instead\n"),
+	}
+	call := session.ToolCall{Name: "execute_command", Args: map[string]interface{}{"command": "go build ./..."}}
+
+	decision, err := a.askApproval(context.Background(), call, &tools.ExecuteCommandTool{})
+	if err != nil {
+		t.Fatalf("askApproval: %v", err)
+	}
+	if decision.Approved || decision.Feedback != "use go vet instead" {
+		t.Errorf("decision = %+v, want rejection with feedback", decision)
+	}
+}
+
+func TestDescribeToolCall(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	call := session.ToolCall{Name: "execute_command", Args: map[string]interface{}{
+		"command":         "go test ./...",
+		"working_dir":     "sub",
+		"timeout_seconds": 600.0,
+		"env":             "X=1",
+	}}
+	got := describeToolCall(call, &tools.ExecuteCommandTool{})
+	// The preview shows every argument it covers, and the others follow it.
+	for _, want := range []string{"(time limit 10m0s)", "(in sub)", "$ go test ./...", "Other arguments: {\n  \"env\": \"X=1\"\n}"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("description does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "working_dir") {
+		t.Errorf("previewed arguments repeated:\n%s", got)
+	}
+
+	call = session.ToolCall{Name: "start_process", Args: map[string]interface{}{"command": "npm run dev", "timeout_seconds": 5.0}}
+	if got := describeToolCall(call, &tools.StartProcessTool{}); !strings.Contains(got, "Other arguments") || strings.Contains(got, "time limit") {
+		t.Errorf("start_process description:\n%s", got)
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m4xw311/compell/session"
+)
+
+// handleCommand runs a slash command typed at the prompt. It returns false if
+// the input is not a known command and should be sent to the model instead.
+func (a *Agent) handleCommand(input string) bool {
+	fields := strings.Fields(input)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return false
+	}
+
+	switch fields[0] {
+	case "/checkpoints":
+		a.listCheckpoints()
+	case "/undo":
+		a.undo()
+	case "/restore":
+		if len(fields) != 2 {
+			fmt.Println("Usage: /restore <checkpoint id>")
+			return true
+		}
+		a.restore(fields[1])
+	case "/shadow":
+		a.shadowCommand(fields[1:])
+	case "/prompts":
+		a.listPrompts()
+	case "/resources":
+		a.listResources()
+	case "/mcp":
+		a.mcpCommand(fields[1:])
+	default:
+		return false
+	}
+	return true
+}
+
+func (a *Agent) listCheckpoints() {
+	if a.Checkpoints == nil {
+		fmt.Println("Checkpoints are not enabled.")
+		return
+	}
+	checkpoints := a.Checkpoints.List()
+	if len(checkpoints) == 0 {
+		fmt.Println("No checkpoints yet.")
+		return
+	}
+	for _, cp := range checkpoints {
+		fmt.Println(cp.Summary())
+	}
+}
+
+func (a *Agent) undo() {
+	if a.Checkpoints == nil {
+		fmt.Println("Checkpoints are not enabled.")
+		return
+	}
+	cp, restored, err := a.Checkpoints.Undo()
+	if err != nil {
+		fmt.Printf("Error: failed to undo: %v\n", err)
+		return
+	}
+	if cp == nil {
+		fmt.Println("Nothing to undo.")
+		return
+	}
+	a.reportRestore(cp.ID, restored)
+}
+
+func (a *Agent) restore(id string) {
+	if a.Checkpoints == nil {
+		fmt.Println("Checkpoints are not enabled.")
+		return
+	}
+	restored, err := a.Checkpoints.Restore(id)
+	if err != nil {
+		fmt.Printf("Error: failed to restore checkpoint %s: %v\n", id, err)
+		return
+	}
+	a.reportRestore(id, restored)
+}
+
+// reportRestore tells the user which paths were restored and records the
+// restore in the session, so the model does not assume its changes are still
+// in place.
+func (a *Agent) reportRestore(id string, restored []string) {
+	fmt.Printf("Restored %d path(s) to their state before checkpoint %s:\n", len(restored), id)
+	for _, path := range restored {
+		fmt.Printf("  %s\n", path)
+	}
+	a.Session.AddMessage(session.Message{
+		Role:    "user",
+		Content: fmt.Sprintf("[The user reverted the file changes made since checkpoint %s: %s]", id, strings.Join(restored, ", ")),
+	})
+	if err := a.Session.Save(); err != nil {
+		fmt.Printf("Warning: failed to save session: %v\n", err)
+	}
+}
+
+// shadowCommand lists, diffs or cherry-picks the commits on the session's
+// shadow ref.
+func (a *Agent) shadowCommand(args []string) {
+	if a.Shadow == nil {
+		fmt.Println("Shadow commits are not enabled. Set shadow_commits: true in the configuration.")
+		return
+	}
+	ctx := context.Background()
+	switch {
+	case len(args) == 0 || len(args) == 1 && args[0] == "list":
+		commits, err := a.Shadow.List(ctx)
+		if err != nil {
+			fmt.Printf("Error: failed to list shadow commits: %v\n", err)
+			return
+		}
+		if len(commits) == 0 {
+			fmt.Printf("No shadow commits on %s yet.\n", a.Shadow.Ref())
+			return
+		}
+		for i, c := range commits {
+			fmt.Printf("%d  %.8s  %s  %d path(s)  %q\n", i+1, c.Hash, c.Date.Format("2006-01-02 15:04:05"), c.Files, c.Subject)
+		}
+	case len(args) == 2 && args[0] == "diff":
+		diff, err := a.Shadow.Diff(ctx, args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if useColor() {
+			diff = colorizeDiff(diff)
+		}
+		fmt.Println(diff)
+	case len(args) == 2 && args[0] == "pick":
+		out, err := a.Shadow.CherryPick(ctx, args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Println(out)
+	default:
+		fmt.Println("Usage: /shadow [list] | /shadow diff <n|commit> | /shadow pick <n|commit>")
+	}
+}
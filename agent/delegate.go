@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+)
+
+// delegateTaskToolName is the name of the tool that runs a task in a sub-agent.
+const delegateTaskToolName = "delegate_task"
+
+// delegateTaskTool runs a task in a child agent with its own session, a
+// toolset from the delegation config and its own limits, and returns only the
+// child's final report. This keeps the work of large tasks out of the parent's
+// context.
+type delegateTaskTool struct {
+	parent *Agent
+}
+
+func (t *delegateTaskTool) Name() string { return delegateTaskToolName }
+func (t *delegateTaskTool) Description() string {
+	toolsets := "none configured"
+	if t.parent != nil && len(t.parent.Config.Delegation.Toolsets) > 0 {
+		toolsets = strings.Join(t.parent.Config.Delegation.Toolsets, ", ")
+	}
+	return "Delegates a self-contained task to a sub-agent and returns its final report. The sub-agent starts " +
+		"with an empty context and cannot see this conversation, so describe the task, the relevant files and " +
+		"the expected report completely. Use it for large tasks whose intermediate steps are not needed here. " +
+		fmt.Sprintf("Args: task (string), [toolset (string, one of: %s; defaults to the first)].", toolsets)
+}
+
+func (t *delegateTaskTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	task, ok := args["task"].(string)
+	if !ok || strings.TrimSpace(task) == "" {
+		return session.ToolResult{}, errors.New("missing or invalid 'task' argument")
+	}
+	a := t.parent
+	allowed := a.Config.Delegation.Toolsets
+	if len(allowed) == 0 {
+		return session.ToolResult{}, errors.New("no toolsets are configured for delegated tasks")
+	}
+	toolset := allowed[0]
+	if name, ok := args["toolset"].(string); ok && name != "" {
+		toolset = name
+	}
+	if !contains(allowed, toolset) {
+		return session.ToolResult{}, errors.New("toolset '%s' is not allowed for delegated tasks, use one of: %s", toolset, strings.Join(allowed, ", "))
+	}
+
+	child, err := a.newChild(toolset)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	fmt.Printf("Delegating task to sub-agent %s (toolset '%s')...\n", child.Session.Name, toolset)
+	err = child.processTurn(session.WithSession(ctx, child.Session), task)
+	if saveErr := child.Session.Save(); saveErr != nil {
+		fmt.Printf("Warning: failed to save session %s: %v\n", child.Session.Name, saveErr)
+	}
+	if err != nil {
+		return session.ToolResult{}, errors.Wrapf(err, "sub-agent %s failed", child.Session.Name)
+	}
+	fmt.Printf("Sub-agent %s finished.\n", child.Session.Name)
+
+	report := "(The sub-agent gave no report.)"
+	if last := child.Session.Messages[len(child.Session.Messages)-1]; last.Role == "assistant" && last.Content != "" {
+		report = last.Content
+	}
+	return session.TextResult(fmt.Sprintf("Report of sub-agent %s:\n%s", child.Session.Name, report)), nil
+}
+
+// newChild creates the agent for a delegated task. Its session is named after
+// the parent session and linked from it.
+func (a *Agent) newChild(toolset string) (*Agent, error) {
+	ts, err := a.Config.GetToolset(toolset)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get toolset")
+	}
+	activeTools, err := a.registry.GetActiveTools(ts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get active tools")
+	}
+	// Sub-agents do not delegate further.
+	var childTools []tools.Tool
+	for _, t := range activeTools {
+		if t.Name() != delegateTaskToolName {
+			childTools = append(childTools, t)
+		}
+	}
+
+	name := fmt.Sprintf("%s.task-%d", a.Session.Name, len(a.Session.Delegations)+1)
+	sess, err := session.New(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create session for sub-agent")
+	}
+	sess.Parent = a.Session.Name
+	sess.Mode, sess.Toolset, sess.ToolVerbosity = string(a.Mode), toolset, string(a.Verbosity)
+	sess.ApprovalRules = append(sess.ApprovalRules, a.Session.ApprovalRules...)
+	a.Session.Delegations = append(a.Session.Delegations, name)
+
+	cfg := *a.Config
+	cfg.Limits = a.Config.Delegation.Limits
+	child := &Agent{
+		Config:         &cfg,
+		Session:        sess,
+		LLMClient:      a.LLMClient,
+		AvailableTools: childTools,
+		Mode:           a.Mode,
+		Verbosity:      a.Verbosity,
+		Redactor:       a.Redactor,
+		Headless:       a.Headless,
+		Progress:       a.Progress,
+		registry:       a.registry,
+	}
+	// Approvals are read from the parent's input; interrupts are handled by
+	// the parent, which cancels the context of the running tool call. Headless
+	// runs have no input, and stdin may carry a protocol instead.
+	if !a.Headless {
+		child.input = a.inputReader()
+	}
+	return child, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+)
+
+// funcLLMClient answers each Chat call with the result of a function.
+type funcLLMClient func(messages []session.Message, availableTools []tools.Tool) *session.Message
+
+func (f funcLLMClient) Chat(ctx context.Context, messages []session.Message, availableTools []tools.Tool) (*session.Message, error) {
+	return f(messages, availableTools), nil
+}
+
+func TestDelegateTask(t *testing.T) {
+	sess := newTestSession(t)
+	cfg := &config.Config{
+		Toolsets:   []config.Toolset{{Name: "read_only", Tools: []string{"read_dir", "delegate_task"}}},
+		Limits:     config.Limits{MaxLLMCalls: 5},
+		Delegation: config.Delegation{Toolsets: []string{"read_only"}, Limits: config.Limits{MaxLLMCalls: 2}},
+	}
+	client := funcLLMClient(func(messages []session.Message, availableTools []tools.Tool) *session.Message {
+		switch {
+		case messages[0].Content == "audit the packages":
+			// The sub-agent sees only its task and a toolset without delegate_task.
+			if len(messages) != 1 || len(availableTools) != 1 || availableTools[0].Name() != "read_dir" {
+				t.Errorf("sub-agent got %d message(s) and tools %v", len(messages), availableTools)
+			}
+			return &session.Message{Role: "assistant", Content: "All packages wrap their errors."}
+		case messages[len(messages)-1].Role == "tool":
+			return &session.Message{Role: "assistant", Content: "Done."}
+		default:
+			return &session.Message{Role: "assistant", ToolCalls: []session.ToolCall{{
+				ToolCallID: "call_1",
+				Name:       "delegate_task",
+				Args:       map[string]interface{}{"task": "audit the packages"},
+			}}}
+		}
+	})
+
+	delegate := &delegateTaskTool{}
+	registry := tools.NewToolRegistry(cfg)
+	registry.Register(delegate)
+	a := &Agent{
+		Config:         cfg,
+		Session:        sess,
+		LLMClient:      client,
+		AvailableTools: []tools.Tool{delegate},
+		Mode:           ModeAuto,
+		registry:       registry,
+	}
+	delegate.parent = a
+
+	if err := a.processTurn(context.Background(), "check error handling"); err != nil {
+		t.Fatalf("processTurn: %v", err)
+	}
+
+	var report string
+	for _, msg := range sess.Messages {
+		if msg.Role == "tool" {
+			report = msg.Content
+		}
+	}
+	if !strings.Contains(report, "All packages wrap their errors.") {
+		t.Errorf("tool result = %q, want the sub-agent's report", report)
+	}
+	if len(sess.Delegations) != 1 || sess.Delegations[0] != "test.task-1" {
+		t.Fatalf("delegations = %v", sess.Delegations)
+	}
+	child, err := session.Load(sess.Delegations[0])
+	if err != nil {
+		t.Fatalf("sub-agent transcript not saved: %v", err)
+	}
+	if child.Parent != "test" || len(child.Messages) != 2 {
+		t.Errorf("unexpected sub-agent session: %+v", child)
+	}
+
+	if _, err := delegate.Execute(context.Background(), map[string]interface{}{"task": "x", "toolset": "default"}); err == nil {
+		t.Error("expected an error for a toolset not allowed for delegation")
+	}
+}
+
+// readSpy fails the test if anything reads it.
+type readSpy struct{ t *testing.T }
+
+func (r readSpy) Read(p []byte) (int, error) {
+	r.t.Error("headless run read its input")
+	return 0, io.EOF
+}
+
+func TestDelegateTaskHeadless(t *testing.T) {
+	sess := newTestSession(t)
+	cfg := &config.Config{
+		Toolsets:   []config.Toolset{{Name: "read_only", Tools: []string{"read_dir"}}},
+		Delegation: config.Delegation{Toolsets: []string{"read_only"}},
+	}
+	client := funcLLMClient(func(messages []session.Message, availableTools []tools.Tool) *session.Message {
+		switch {
+		case messages[0].Content == "list the files":
+			return &session.Message{Role: "assistant", Content: "No files."}
+		case messages[len(messages)-1].Role == "tool":
+			return &session.Message{Role: "assistant", Content: "Done."}
+		default:
+			return &session.Message{Role: "assistant", ToolCalls: []session.ToolCall{{
+				ToolCallID: "call_1",
+				Name:       "delegate_task",
+				Args:       map[string]interface{}{"task": "list the files"},
+			}}}
+		}
+	})
+
+	delegate := &delegateTaskTool{}
+	registry := tools.NewToolRegistry(cfg)
+	registry.Register(delegate)
+	a := &Agent{
+		Config:         cfg,
+		Session:        sess,
+		LLMClient:      client,
+		AvailableTools: []tools.Tool{delegate},
+		Mode:           ModeAuto,
+		Input:          readSpy{t},
+		Headless:       true,
+		registry:       registry,
+	}
+	delegate.parent = a
+	defer a.Close()
+
+	report, err := a.RunTask(context.Background(), "delegate the listing")
+	if err != nil || report.Answer != "Done." {
+		t.Fatalf("RunTask = %+v, %v", report, err)
+	}
+	if result := sess.Messages[len(sess.Messages)-2]; !strings.Contains(result.Content, "No files.") {
+		t.Errorf("delegate_task result = %q", result.Content)
+	}
+	if a.input != nil {
+		t.Error("headless run created an input reader")
+	}
+	if _, err := a.readLine(context.Background(), nil); !errors.Is(err, errHeadless) {
+		t.Errorf("readLine = %v, want errHeadless", err)
+	}
+}
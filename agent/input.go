@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// errInterrupted is returned by readLine when an interrupt arrives while
+// waiting for user input.
+var errInterrupted = errors.New("interrupted")
+
+// inputReader reads lines from the user on a background goroutine so that
+// waiting for input can be combined with cancellation and interrupts. A single
+// reader is shared by the main prompt and tool approval prompts so that no
+// buffered input is lost between them.
+type inputReader struct {
+	lines chan string
+	err   error
+}
+
+func newInputReader(r io.Reader) *inputReader {
+	ir := &inputReader{lines: make(chan string)}
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			ir.lines <- scanner.Text()
+		}
+		ir.err = scanner.Err()
+		close(ir.lines)
+	}()
+	return ir
+}
+
+// errHeadless is returned by readLine in headless runs, which have no user to
+// read from.
+var errHeadless = errors.New("no user input in a headless run")
+
+// readLine waits for the next line of user input. It returns io.EOF when the
+// input is exhausted, ctx.Err() when ctx is done and errInterrupted when a
+// signal arrives on interrupts, which may be nil. In headless runs it fails
+// with errHeadless without touching the input, which may be the stdin of a
+// protocol such as MCP over stdio.
+func (a *Agent) readLine(ctx context.Context, interrupts <-chan os.Signal) (string, error) {
+	if a.Headless {
+		return "", errHeadless
+	}
+	input := a.inputReader()
+	select {
+	case line, ok := <-input.lines:
+		if !ok {
+			if input.err != nil {
+				return "", input.err
+			}
+			return "", io.EOF
+		}
+		return line, nil
+	case <-interrupts:
+		return "", errInterrupted
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// inputReader returns the agent's input reader, creating it on first use.
+func (a *Agent) inputReader() *inputReader {
+	if a.input == nil {
+		in := a.Input
+		if in == nil {
+			in = os.Stdin
+		}
+		a.input = newInputReader(in)
+	}
+	return a.input
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+)
+
+// StopReason identifies why a turn ended before the model gave a final answer.
+type StopReason string
+
+const (
+	StopInterrupted      StopReason = "interrupted"
+	StopMaxLLMCalls      StopReason = "max_llm_calls"
+	StopMaxToolCalls     StopReason = "max_tool_calls"
+	StopMaxDuration      StopReason = "max_duration"
+	StopMaxTokens        StopReason = "max_tokens"
+	StopMaxCost          StopReason = "max_cost"
+	StopRepeatedFailures StopReason = "repeated_failures"
+)
+
+// turnStop describes a turn that was ended by one of the configured limits.
+type turnStop struct {
+	Reason StopReason
+	Detail string
+}
+
+func (s *turnStop) String() string {
+	return fmt.Sprintf("Turn stopped (%s): %s", s.Reason, s.Detail)
+}
+
+// turnGuard tracks the work done in a single turn and reports when it exceeds
+// the configured limits. Its checks may run concurrently, as MCP servers'
+// sampling requests count against the turn too.
+type turnGuard struct {
+	mu           sync.Mutex
+	limits       config.Limits
+	started      time.Time
+	llmCalls     int
+	toolCalls    int
+	inputTokens  int
+	outputTokens int
+	failures     map[string]int
+}
+
+func newTurnGuard(limits config.Limits) *turnGuard {
+	return &turnGuard{
+		limits:   limits,
+		started:  time.Now(),
+		failures: make(map[string]int),
+	}
+}
+
+// beforeLLMCall checks the limits that must hold before the model is called again.
+func (g *turnGuard) beforeLLMCall() *turnStop {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limits.MaxLLMCalls > 0 && g.llmCalls >= g.limits.MaxLLMCalls {
+		return &turnStop{StopMaxLLMCalls, fmt.Sprintf("reached the limit of %d LLM calls for this turn", g.limits.MaxLLMCalls)}
+	}
+	if stop := g.checkDuration(); stop != nil {
+		return stop
+	}
+	g.llmCalls++
+	return nil
+}
+
+// afterLLMCall records the usage of a model response and checks the budgets.
+func (g *turnGuard) afterLLMCall(resp *session.Message) *turnStop {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if resp.Usage != nil {
+		g.inputTokens += resp.Usage.InputTokens
+		g.outputTokens += resp.Usage.OutputTokens
+	}
+	if total := g.inputTokens + g.outputTokens; g.limits.MaxTokens > 0 && total > g.limits.MaxTokens {
+		return &turnStop{StopMaxTokens, fmt.Sprintf("used %d tokens, exceeding the budget of %d for this turn", total, g.limits.MaxTokens)}
+	}
+	if cost := g.cost(); g.limits.MaxCost > 0 && cost > g.limits.MaxCost {
+		return &turnStop{StopMaxCost, fmt.Sprintf("spent an estimated %.4f, exceeding the budget of %.4f for this turn", cost, g.limits.MaxCost)}
+	}
+	return nil
+}
+
+// beforeToolCall checks the limits that must hold before a tool is executed.
+func (g *turnGuard) beforeToolCall() *turnStop {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.limits.MaxToolCalls > 0 && g.toolCalls >= g.limits.MaxToolCalls {
+		return &turnStop{StopMaxToolCalls, fmt.Sprintf("reached the limit of %d tool calls for this turn", g.limits.MaxToolCalls)}
+	}
+	if stop := g.checkDuration(); stop != nil {
+		return stop
+	}
+	g.toolCalls++
+	return nil
+}
+
+// afterToolFailure records a failed tool call and reports when the model has
+// made the same call with the same error too many times.
+func (g *turnGuard) afterToolFailure(call session.ToolCall, err error) *turnStop {
+	if g.limits.MaxRepeatedFailures <= 0 {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// encoding/json sorts map keys, so identical arguments give identical keys.
+	args, _ := json.Marshal(call.Args)
+	key := call.Name + "\x00" + string(args) + "\x00" + err.Error()
+	g.failures[key]++
+	if g.failures[key] >= g.limits.MaxRepeatedFailures {
+		return &turnStop{StopRepeatedFailures, fmt.Sprintf("tool `%s` failed %d times with the same arguments and error: %v", call.Name, g.failures[key], err)}
+	}
+	return nil
+}
+
+func (g *turnGuard) checkDuration() *turnStop {
+	if g.limits.MaxDuration > 0 && time.Since(g.started) >= g.limits.MaxDuration {
+		return g.durationStop()
+	}
+	return nil
+}
+
+func (g *turnGuard) durationStop() *turnStop {
+	return &turnStop{StopMaxDuration, fmt.Sprintf("exceeded the time limit of %s for this turn", g.limits.MaxDuration)}
+}
+
+func (g *turnGuard) cost() float64 {
+	return (float64(g.inputTokens)*g.limits.InputTokenCost + float64(g.outputTokens)*g.limits.OutputTokenCost) / 1e6
+}
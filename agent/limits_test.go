@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+)
+
+func TestTurnGuardBudgets(t *testing.T) {
+	g := newTurnGuard(config.Limits{MaxTokens: 1000})
+	if stop := g.afterLLMCall(&session.Message{Usage: &session.Usage{InputTokens: 600, OutputTokens: 300}}); stop != nil {
+		t.Fatalf("unexpected stop under budget: %v", stop)
+	}
+	stop := g.afterLLMCall(&session.Message{Usage: &session.Usage{InputTokens: 100, OutputTokens: 100}})
+	if stop == nil || stop.Reason != StopMaxTokens {
+		t.Errorf("stop = %v, want %s", stop, StopMaxTokens)
+	}
+
+	g = newTurnGuard(config.Limits{MaxCost: 1, InputTokenCost: 3, OutputTokenCost: 15})
+	stop = g.afterLLMCall(&session.Message{Usage: &session.Usage{InputTokens: 100000, OutputTokens: 50000}})
+	if stop == nil || stop.Reason != StopMaxCost {
+		t.Errorf("stop = %v, want %s", stop, StopMaxCost)
+	}
+}
+
+func TestTurnGuardMaxToolCalls(t *testing.T) {
+	g := newTurnGuard(config.Limits{MaxToolCalls: 2})
+	for i := 0; i < 2; i++ {
+		if stop := g.beforeToolCall(); stop != nil {
+			t.Fatalf("tool call %d: unexpected stop %v", i+1, stop)
+		}
+	}
+	if stop := g.beforeToolCall(); stop == nil || stop.Reason != StopMaxToolCalls {
+		t.Errorf("stop = %v, want %s", stop, StopMaxToolCalls)
+	}
+}
@@ -0,0 +1,326 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/tools/mcp"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// attachmentReference matches "@server:uri" references to MCP resources in a
+// prompt.
+var attachmentReference = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_.-]+):(\S+)`)
+
+// expandAttachments appends the contents of the MCP resources referenced as
+// @server:uri to input. References to unknown servers are left alone, so that
+// e.g. "@user:example" is not mistaken for one; resources that cannot be read
+// are reported and skipped.
+func (a *Agent) expandAttachments(ctx context.Context, input string) string {
+	if a.registry == nil {
+		return input
+	}
+	var b strings.Builder
+	b.WriteString(input)
+	seen := make(map[string]bool)
+	for _, m := range attachmentReference.FindAllStringSubmatch(input, -1) {
+		server, uri := m[1], strings.TrimRight(m[2], ".,;:!?)")
+		client, ok := a.registry.MCPClient(server)
+		if !ok || seen[server+":"+uri] {
+			continue
+		}
+		seen[server+":"+uri] = true
+		result, err := client.ReadResource(ctx, uri)
+		if err != nil {
+			fmt.Printf("Warning: could not attach @%s:%s: %v\n", server, uri, err)
+			continue
+		}
+		fmt.Printf("Attached @%s:%s.\n", server, uri)
+		fmt.Fprintf(&b, "\n\n[Attached resource @%s:%s]\n%s", server, uri, a.Redactor.Redact(result.Text()))
+	}
+	return b.String()
+}
+
+// promptCommand expands a "/server.prompt [args]" command into the text of
+// the MCP prompt. It returns false if input does not name a prompt of a
+// configured MCP server, and "" if the prompt could not be expanded, which has
+// been reported to the user.
+func (a *Agent) promptCommand(ctx context.Context, input string) (string, bool) {
+	if a.registry == nil || !strings.HasPrefix(input, "/") {
+		return "", false
+	}
+	command, rest, _ := strings.Cut(input[1:], " ")
+	server, name, ok := strings.Cut(command, ".")
+	if !ok {
+		return "", false
+	}
+	client, ok := a.registry.MCPClient(server)
+	if !ok {
+		return "", false
+	}
+	if err := client.Load(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return "", true
+	}
+	prompt, ok := client.Prompt(name)
+	if !ok {
+		fmt.Printf("Error: MCP server '%s' has no prompt '%s'. Use /prompts to list the prompts.\n", server, name)
+		return "", true
+	}
+	args, err := promptArgs(prompt, strings.TrimSpace(rest))
+	if err != nil {
+		fmt.Printf("Error: %v\nUsage: %s\n", err, promptUsage(server, prompt))
+		return "", true
+	}
+	text, err := client.GetPrompt(ctx, name, args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return "", true
+	}
+	return text, true
+}
+
+// promptArgs parses the arguments of a prompt command. Arguments are given as
+// name=value, or in the order the prompt declares them; values with spaces
+// are quoted. The whole text is the value of a prompt with a single argument.
+func promptArgs(prompt *mcpsdk.Prompt, text string) (map[string]string, error) {
+	args := make(map[string]string)
+	if text == "" {
+		return args, checkRequiredArgs(prompt, args)
+	}
+	if len(prompt.Arguments) == 1 && !strings.HasPrefix(text, prompt.Arguments[0].Name+"=") {
+		args[prompt.Arguments[0].Name] = unquote(text)
+		return args, nil
+	}
+
+	words, err := splitWords(text)
+	if err != nil {
+		return nil, err
+	}
+	declared := make(map[string]bool)
+	for _, arg := range prompt.Arguments {
+		declared[arg.Name] = true
+	}
+	var positional []string
+	for _, word := range words {
+		if name, value, ok := strings.Cut(word, "="); ok && declared[name] {
+			args[name] = value
+			continue
+		}
+		positional = append(positional, word)
+	}
+	for _, arg := range prompt.Arguments {
+		if len(positional) == 0 {
+			break
+		}
+		if _, ok := args[arg.Name]; !ok {
+			args[arg.Name], positional = positional[0], positional[1:]
+		}
+	}
+	if len(positional) > 0 {
+		return nil, errors.New("too many arguments for prompt '%s'", prompt.Name)
+	}
+	return args, checkRequiredArgs(prompt, args)
+}
+
+func checkRequiredArgs(prompt *mcpsdk.Prompt, args map[string]string) error {
+	for _, arg := range prompt.Arguments {
+		if _, ok := args[arg.Name]; arg.Required && !ok {
+			return errors.New("missing argument '%s' for prompt '%s'", arg.Name, prompt.Name)
+		}
+	}
+	return nil
+}
+
+// splitWords splits text at spaces, keeping text in single or double quotes
+// together.
+func splitWords(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in prompt arguments")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func unquote(text string) string {
+	if len(text) >= 2 && (text[0] == '"' || text[0] == '\'') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
+
+func promptUsage(server string, prompt *mcpsdk.Prompt) string {
+	usage := fmt.Sprintf("/%s.%s", server, prompt.Name)
+	for _, arg := range prompt.Arguments {
+		if arg.Required {
+			usage += fmt.Sprintf(" %s=<value>", arg.Name)
+		} else {
+			usage += fmt.Sprintf(" [%s=<value>]", arg.Name)
+		}
+	}
+	return usage
+}
+
+// refreshTools picks up the tools MCP servers added, removed or changed since
+// the last turn. The tools stay the same within a turn.
+func (a *Agent) refreshTools() {
+	if a.registry == nil || a.toolset == nil || !a.registry.RefreshMCPTools() {
+		return
+	}
+	activeTools, err := a.registry.GetActiveTools(a.toolset)
+	if err != nil {
+		fmt.Printf("Warning: Keeping the previous tools after MCP servers changed theirs: %v\n", err)
+		return
+	}
+	a.AvailableTools = activeTools
+	fmt.Println("INFO: Updated the tools after MCP servers changed theirs.")
+}
+
+// loadedMCPClients returns the MCP servers whose catalog could be loaded, and
+// reports the others.
+func (a *Agent) loadedMCPClients() []*mcp.MCPClient {
+	var clients []*mcp.MCPClient
+	for _, client := range a.registry.MCPClients() {
+		if err := client.Load(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// listPrompts prints the prompts of the MCP servers as slash commands.
+func (a *Agent) listPrompts() {
+	listed := false
+	if a.registry != nil {
+		for _, client := range a.loadedMCPClients() {
+			for _, prompt := range client.Prompts() {
+				listed = true
+				fmt.Println(promptUsage(client.Name, prompt))
+				if prompt.Description != "" {
+					fmt.Printf("    %s\n", prompt.Description)
+				}
+			}
+		}
+	}
+	if !listed {
+		fmt.Println("No MCP server offers prompts.")
+	}
+}
+
+// listResources prints the resources of the MCP servers in the @server:uri
+// form used to attach them.
+func (a *Agent) listResources() {
+	listed := false
+	if a.registry != nil {
+		for _, client := range a.loadedMCPClients() {
+			for _, r := range client.Resources() {
+				listed = true
+				fmt.Printf("@%s:%s", client.Name, r.URI)
+				if r.Description != "" {
+					fmt.Printf("  %s", r.Description)
+				}
+				fmt.Println()
+			}
+			for _, tmpl := range client.ResourceTemplates() {
+				listed = true
+				fmt.Printf("@%s:%s  (template)\n", client.Name, tmpl.URITemplate)
+			}
+		}
+	}
+	if !listed {
+		fmt.Println("No MCP server offers resources.")
+	}
+}
+
+// mcpCommand shows the status of the MCP servers, or restarts one with
+// "/mcp restart <server>".
+func (a *Agent) mcpCommand(args []string) {
+	if len(args) > 0 {
+		if args[0] != "restart" || len(args) != 2 {
+			fmt.Println("Usage: /mcp [restart <server>]")
+			return
+		}
+		if a.registry == nil {
+			fmt.Println("No MCP servers are configured.")
+			return
+		}
+		client, ok := a.registry.MCPClient(args[1])
+		if !ok {
+			fmt.Printf("Error: MCP server '%s' not registered.\n", args[1])
+			return
+		}
+		if err := client.Restart(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		return
+	}
+
+	var clients []*mcp.MCPClient
+	if a.registry != nil {
+		clients = a.registry.MCPClients()
+	}
+	if len(clients) == 0 {
+		fmt.Println("No MCP servers are configured.")
+		return
+	}
+	for _, client := range clients {
+		fmt.Println(describeMCPStatus(client.Status(), time.Now()))
+	}
+}
+
+// describeMCPStatus summarizes the status of an MCP server on one line, with
+// the last error on a second one.
+func describeMCPStatus(s mcp.Status, now time.Time) string {
+	line := fmt.Sprintf("%s: %s", s.Name, s.State)
+	switch s.State {
+	case mcp.StateRunning:
+		line += fmt.Sprintf(" for %s", now.Sub(s.Since).Round(time.Second))
+	case mcp.StateFailed:
+		if wait := s.RetryAt.Sub(now); wait > 0 {
+			line += fmt.Sprintf(", restarted when next used after %s", wait.Round(time.Second))
+		} else {
+			line += ", restarted when next used"
+		}
+	}
+	line += fmt.Sprintf(", %d tools", s.Tools)
+	if s.Restarts > 0 {
+		line += fmt.Sprintf(", %d restarts", s.Restarts)
+	}
+	if s.LastError != nil {
+		line += fmt.Sprintf("\n    last error: %v", s.LastError)
+	}
+	return line
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m4xw311/compell/tools/mcp"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestPromptArgs(t *testing.T) {
+	review := &mcpsdk.Prompt{Name: "review", Arguments: []*mcpsdk.PromptArgument{
+		{Name: "file", Required: true},
+		{Name: "focus"},
+	}}
+	single := &mcpsdk.Prompt{Name: "explain", Arguments: []*mcpsdk.PromptArgument{{Name: "topic"}}}
+
+	for _, tc := range []struct {
+		prompt *mcpsdk.Prompt
+		text   string
+		want   map[string]string
+	}{
+		{review, "main.go", map[string]string{"file": "main.go"}},
+		{review, `main.go "error handling"`, map[string]string{"file": "main.go", "focus": "error handling"}},
+		{review, `focus='error handling' file=main.go`, map[string]string{"file": "main.go", "focus": "error handling"}},
+		{review, `focus=tests main.go`, map[string]string{"file": "main.go", "focus": "tests"}},
+		{single, "how the agent loop works", map[string]string{"topic": "how the agent loop works"}},
+		{single, "", map[string]string{}},
+	} {
+		got, err := promptArgs(tc.prompt, tc.text)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("promptArgs(%s, %q) = %v, %v; want %v", tc.prompt.Name, tc.text, got, err, tc.want)
+		}
+	}
+
+	for _, text := range []string{"", "focus=tests", "a b c", `"main.go`} {
+		if _, err := promptArgs(review, text); err == nil {
+			t.Errorf("expected promptArgs(review, %q) to fail", text)
+		}
+	}
+}
+
+func TestDescribeMCPStatus(t *testing.T) {
+	now := time.Now()
+	running := mcp.Status{Name: "docs", State: mcp.StateRunning, Tools: 3, Since: now.Add(-90 * time.Second), Restarts: 1}
+	if got, want := describeMCPStatus(running, now), "docs: running for 1m30s, 3 tools, 1 restarts"; got != want {
+		t.Errorf("running: %q, want %q", got, want)
+	}
+	failed := mcp.Status{Name: "docs", State: mcp.StateFailed, RetryAt: now.Add(4 * time.Second), LastError: fmt.Errorf("exit status 1")}
+	if got, want := describeMCPStatus(failed, now), "docs: failed, restarted when next used after 4s, 0 tools\n    last error: exit status 1"; got != want {
+		t.Errorf("failed: %q, want %q", got, want)
+	}
+}
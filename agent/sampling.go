@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/llm"
+	"github.com/m4xw311/compell/session"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// maxSamplingPreview bounds the text of a sampling request shown to the user.
+const maxSamplingPreview = 2000
+
+// sampler answers the sampling requests of MCP servers, which ask the model
+// to generate a message, e.g. to summarize a document the server fetched.
+// Every request needs the user's approval, unless the user allowed all
+// requests of the server. Requests count against the limits of the running
+// turn, or of a turn of their own between turns. Neither the request nor the
+// answer are recorded in the session.
+type sampler struct {
+	agent *Agent
+
+	mu      sync.Mutex
+	allowed map[string]bool // Servers whose requests the user always allows
+}
+
+func (s *sampler) createMessage(ctx context.Context, server string, params *mcpsdk.CreateMessageParams) (*mcpsdk.CreateMessageResult, error) {
+	messages, err := samplingMessages(params)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isAllowed(server) {
+		if s.agent.Headless {
+			return nil, errors.New("sampling needs the user's approval, which cannot be given in a headless run")
+		}
+		approved, err := s.askApproval(ctx, server, messages)
+		if err != nil {
+			return nil, err
+		}
+		if !approved {
+			return nil, errors.New("the user declined the sampling request")
+		}
+	}
+
+	guard := s.agent.currentGuard()
+	if guard == nil {
+		guard = newTurnGuard(s.agent.Config.Limits)
+	}
+	if stop := guard.beforeLLMCall(); stop != nil {
+		return nil, errors.New("sampling request refused: %s", stop.Detail)
+	}
+	if params.MaxTokens > 0 {
+		ctx = llm.WithMaxTokens(ctx, int(params.MaxTokens))
+	}
+	response, err := s.agent.LLMClient.Chat(ctx, messages, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sample a message")
+	}
+	// The tokens are spent either way; a turn over budget stops after its
+	// next model call.
+	guard.afterLLMCall(response)
+	return &mcpsdk.CreateMessageResult{
+		Content:    &mcpsdk.TextContent{Text: response.Content},
+		Model:      s.agent.Config.Model,
+		Role:       "assistant",
+		StopReason: "endTurn",
+	}, nil
+}
+
+// isAllowed reports whether the user allowed all requests of server.
+func (s *sampler) isAllowed(server string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.allowed[server]
+}
+
+func (s *sampler) askApproval(ctx context.Context, server string, messages []session.Message) (bool, error) {
+	var preview []string
+	for _, m := range messages {
+		preview = append(preview, fmt.Sprintf("[%s] %s", m.Role, m.Content))
+	}
+	text := strings.Join(preview, "\n")
+	if len(text) > maxSamplingPreview {
+		// Cut at a character boundary.
+		end := maxSamplingPreview
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		text = text[:end] + "\n..."
+	}
+	fmt.Printf("MCP server '%s' wants the model to answer:\n%s\n", server, text)
+
+	for {
+		fmt.Printf("Do you want to allow this? [y]es, [n]o, [a]lways allow requests of '%s': ", server)
+		answer, err := s.agent.readLine(ctx, nil)
+		if err != nil {
+			return false, err
+		}
+		switch strings.TrimSpace(strings.ToLower(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no", "":
+			return false, nil
+		case "a", "always":
+			s.mu.Lock()
+			if s.allowed == nil {
+				s.allowed = make(map[string]bool)
+			}
+			s.allowed[server] = true
+			s.mu.Unlock()
+			return true, nil
+		}
+		fmt.Println("Please answer with one of the listed options.")
+	}
+}
+
+// samplingMessages converts the conversation of a sampling request. Not all
+// providers support system messages, so the server's system prompt is put in
+// front of the first message. Only text is supported.
+func samplingMessages(params *mcpsdk.CreateMessageParams) ([]session.Message, error) {
+	var messages []session.Message
+	for _, m := range params.Messages {
+		text, ok := m.Content.(*mcpsdk.TextContent)
+		if !ok {
+			return nil, errors.New("sampling supports text messages only, got %T", m.Content)
+		}
+		messages = append(messages, session.Message{Role: string(m.Role), Content: text.Text})
+	}
+	if len(messages) == 0 {
+		return nil, errors.New("the sampling request has no messages")
+	}
+	if params.SystemPrompt != "" {
+		messages[0].Content = params.SystemPrompt + "\n\n" + messages[0].Content
+	}
+	return messages, nil
+}
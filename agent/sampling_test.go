@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/llm"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestSampler(t *testing.T) {
+	a := &Agent{
+		Config:    &config.Config{Model: "test-model"},
+		LLMClient: &llm.MockLLMClient{MockResponseContent: "A short summary."},
+		Input:     strings.NewReader("n\na\n"),
+	}
+	s := &sampler{agent: a}
+	params := &mcpsdk.CreateMessageParams{
+		SystemPrompt: "Summarize.",
+		Messages:     []*mcpsdk.SamplingMessage{{Role: "user", Content: &mcpsdk.TextContent{Text: "A long document."}}},
+	}
+
+	if _, err := s.createMessage(context.Background(), "docs", params); err == nil {
+		t.Error("expected the declined request to fail")
+	}
+	// Once the user always allows the server, it is not asked again.
+	for i := 0; i < 2; i++ {
+		result, err := s.createMessage(context.Background(), "docs", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text, ok := result.Content.(*mcpsdk.TextContent); !ok || text.Text != "A short summary." || result.Model != "test-model" {
+			t.Errorf("result = %+v", result)
+		}
+	}
+
+	// Requests count against the limits of the running turn.
+	guard := newTurnGuard(config.Limits{MaxLLMCalls: 2, MaxTokens: 100})
+	a.setGuard(guard)
+	a.LLMClient = funcLLMClient(func(messages []session.Message, availableTools []tools.Tool) *session.Message {
+		return &session.Message{Role: "assistant", Content: "Summary.", Usage: &session.Usage{InputTokens: 60, OutputTokens: 50}}
+	})
+	if _, err := s.createMessage(context.Background(), "docs", params); err != nil {
+		t.Fatal(err)
+	}
+	if guard.llmCalls != 1 || guard.inputTokens != 60 || guard.outputTokens != 50 {
+		t.Errorf("guard after sampling: %d calls, %d+%d tokens", guard.llmCalls, guard.inputTokens, guard.outputTokens)
+	}
+	if stop := guard.afterLLMCall(&session.Message{}); stop == nil || stop.Reason != StopMaxTokens {
+		t.Errorf("sampled tokens not counted against max_tokens: %v", stop)
+	}
+	guard.beforeLLMCall()
+	if _, err := s.createMessage(context.Background(), "docs", params); err == nil || !strings.Contains(err.Error(), "limit of 2 LLM calls") {
+		t.Errorf("expected the request to exceed max_llm_calls, got %v", err)
+	}
+	a.setGuard(nil)
+
+	messages, err := samplingMessages(params)
+	if err != nil || len(messages) != 1 || messages[0].Content != "Summarize.\n\nA long document." {
+		t.Errorf("samplingMessages = %+v, %v", messages, err)
+	}
+	params.Messages[0].Content = &mcpsdk.ImageContent{MIMEType: "image/png"}
+	if _, err := samplingMessages(params); err == nil {
+		t.Error("expected images to be rejected")
+	}
+}
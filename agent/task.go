@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/m4xw311/compell/checkpoint"
+)
+
+// TaskReport is the outcome of a task run with RunTask.
+type TaskReport struct {
+	Session string
+	// Answer is the final message of the model, or why the task stopped.
+	Answer string
+	// StopReason is set when the task stopped before the model finished,
+	// e.g. because a limit was hit.
+	StopReason string
+	// Changes describes the paths the task changed, e.g. "modified main.go".
+	Changes []string
+}
+
+// RunTask runs prompt as a single turn and reports the outcome. It is meant
+// for headless runs, e.g. for an MCP client, so the agent should be Headless.
+func (a *Agent) RunTask(ctx context.Context, prompt string) (*TaskReport, error) {
+	var before int
+	if a.Checkpoints != nil {
+		before = len(a.Checkpoints.List())
+	}
+	err := a.runTurn(ctx, a.expandAttachments(ctx, prompt))
+	if saveErr := a.Session.Save(); saveErr != nil {
+		fmt.Printf("Warning: failed to save session: %v\n", saveErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TaskReport{Session: a.Session.Name, Answer: "(The agent gave no answer.)"}
+	if last := a.Session.Messages[len(a.Session.Messages)-1]; last.Role == "assistant" {
+		report.StopReason = last.StopReason
+		if last.Content != "" {
+			report.Answer = last.Content
+		}
+	}
+	if a.Checkpoints != nil {
+		if checkpoints := a.Checkpoints.List(); len(checkpoints) > before {
+			report.Changes = describeChanges(checkpoints[len(checkpoints)-1])
+		}
+	}
+	return report, nil
+}
+
+// Close stops the background processes and MCP servers started by the
+// agent's tools.
+func (a *Agent) Close() {
+	if a.registry != nil {
+		a.registry.Close()
+	}
+}
+
+// reportProgress tells Progress, if set, about the work of the turn.
+func (a *Agent) reportProgress(message string) {
+	if a.Progress != nil {
+		a.Progress(message)
+	}
+}
+
+// describeChanges compares the paths recorded in a checkpoint with their
+// current state, e.g. "created docs/", "modified main.go" or "deleted
+// old.go". Paths created and deleted again are left out.
+func describeChanges(cp *checkpoint.Checkpoint) []string {
+	entries := append([]checkpoint.Entry(nil), cp.Entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	var changes []string
+	for _, e := range entries {
+		info, err := os.Lstat(e.Path)
+		exists := err == nil
+		path := e.Path
+		if (exists && info.IsDir()) || (!exists && e.Kind == checkpoint.KindDir) {
+			path += string(os.PathSeparator)
+		}
+		switch {
+		case e.Kind == checkpoint.KindAbsent && exists:
+			changes = append(changes, "created "+path)
+		case e.Kind != checkpoint.KindAbsent && !exists:
+			changes = append(changes, "deleted "+path)
+		case e.Kind == checkpoint.KindFile && exists:
+			changes = append(changes, "modified "+path)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/checkpoint"
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+)
+
+func TestRunTask(t *testing.T) {
+	sess := newTestSession(t)
+	os.WriteFile("old.txt", []byte("old"), 0o644)
+	os.WriteFile("main.go", []byte("package main"), 0o644)
+	cfg := &config.Config{ApprovalRules: []config.PolicyRule{{Tool: "git_commit", Action: config.PolicyAsk}}}
+
+	calls := []session.ToolCall{
+		{ToolCallID: "call_1", Name: "write_file", Args: map[string]interface{}{"path": "notes.txt", "content": "notes"}},
+		{ToolCallID: "call_2", Name: "write_file", Args: map[string]interface{}{"path": "main.go", "content": "package main\n"}},
+		{ToolCallID: "call_3", Name: "delete_file", Args: map[string]interface{}{"path": "old.txt"}},
+		{ToolCallID: "call_4", Name: "git_commit", Args: map[string]interface{}{"message": "Add notes"}},
+	}
+	client := funcLLMClient(func(messages []session.Message, availableTools []tools.Tool) *session.Message {
+		if last := messages[len(messages)-1]; last.Role == "tool" {
+			return &session.Message{Role: "assistant", Content: "Wrote the notes."}
+		}
+		return &session.Message{Role: "assistant", ToolCalls: calls}
+	})
+
+	registry := tools.NewToolRegistry(cfg)
+	var available []tools.Tool
+	for _, name := range []string{"write_file", "delete_file", "git_commit"} {
+		tool, _ := registry.GetTool(name)
+		available = append(available, tool)
+	}
+	checkpoints, err := checkpoint.Open(sess.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress []string
+	a := &Agent{
+		Config:         cfg,
+		Session:        sess,
+		LLMClient:      client,
+		AvailableTools: available,
+		Mode:           ModeAuto,
+		Checkpoints:    checkpoints,
+		Headless:       true,
+		Progress:       func(message string) { progress = append(progress, message) },
+		registry:       registry,
+	}
+	defer a.Close()
+
+	report, err := a.RunTask(context.Background(), "write the notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TaskReport{
+		Session: "test",
+		Answer:  "Wrote the notes.",
+		Changes: []string{"modified main.go", "created notes.txt", "deleted old.txt"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	if len(progress) != 4 || progress[3] != "Calling tool `git_commit`" {
+		t.Errorf("progress = %q", progress)
+	}
+	// Nobody can approve the commit.
+	if commit := sess.Messages[len(sess.Messages)-2]; !commit.Result.IsError || !strings.Contains(commit.Content, "headless") {
+		t.Errorf("git_commit result = %q", commit.Content)
+	}
+}
@@ -0,0 +1,275 @@
+// Package checkpoint records the state of files before the agent changes them
+// so that the changes made in a turn can be rolled back. It works on plain
+// directories and does not depend on git.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// EntryKind describes what existed at a path before it was changed.
+type EntryKind string
+
+const (
+	KindFile   EntryKind = "file"
+	KindDir    EntryKind = "dir"
+	KindAbsent EntryKind = "absent"
+)
+
+// Entry is the pre-image of a single path.
+type Entry struct {
+	Path string      `json:"path"`
+	Kind EntryKind   `json:"kind"`
+	Mode os.FileMode `json:"mode,omitempty"`
+	Blob string      `json:"blob,omitempty"` // Saved content for files, relative to the store directory
+}
+
+// Checkpoint groups the pre-images recorded during one turn.
+type Checkpoint struct {
+	ID      string    `json:"id"`
+	Prompt  string    `json:"prompt"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Store holds the checkpoints of a session under .compell/checkpoints/<session>.
+type Store struct {
+	mu          sync.Mutex
+	dir         string
+	nextID      int
+	checkpoints []*Checkpoint
+	current     *Checkpoint
+	recorded    map[string]bool // Paths recorded in the current checkpoint
+}
+
+// Open loads or creates the checkpoint store for the named session.
+func Open(sessionName string) (*Store, error) {
+	dir := filepath.Join(".compell", "checkpoints", sessionName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create checkpoint directory")
+	}
+	s := &Store{dir: dir, nextID: 1}
+
+	data, err := os.ReadFile(s.manifestPath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read checkpoint manifest")
+	}
+	if err := json.Unmarshal(data, &s.checkpoints); err != nil {
+		return nil, errors.Wrapf(err, "could not parse checkpoint manifest")
+	}
+	for _, cp := range s.checkpoints {
+		if id, err := strconv.Atoi(cp.ID); err == nil && id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	return s, nil
+}
+
+// Begin starts a new checkpoint for a turn triggered by prompt.
+func (s *Store) Begin(prompt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = &Checkpoint{
+		ID:      strconv.Itoa(s.nextID),
+		Prompt:  prompt,
+		Created: time.Now(),
+	}
+	s.recorded = make(map[string]bool)
+}
+
+// Commit finishes the current checkpoint. Checkpoints without changes are
+// discarded. It returns the committed checkpoint, or nil if there was none.
+func (s *Store) Commit() (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := s.current
+	s.current, s.recorded = nil, nil
+	if cp == nil || len(cp.Entries) == 0 {
+		return nil, nil
+	}
+	s.checkpoints = append(s.checkpoints, cp)
+	s.nextID++
+	return cp, s.saveManifest()
+}
+
+// Record saves the current state of path into the current checkpoint, unless
+// it was already recorded in this checkpoint. It is a no-op outside a turn.
+func (s *Store) Record(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	path = filepath.Clean(path)
+	if s.recorded[path] {
+		return nil
+	}
+
+	entry := Entry{Path: path}
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		entry.Kind = KindAbsent
+	case err != nil:
+		return errors.Wrapf(err, "could not checkpoint '%s'", path)
+	case info.IsDir():
+		entry.Kind = KindDir
+		entry.Mode = info.Mode().Perm()
+	default:
+		entry.Kind = KindFile
+		entry.Mode = info.Mode().Perm()
+		entry.Blob = filepath.Join(s.current.ID, strconv.Itoa(len(s.current.Entries)))
+		if err := s.saveBlob(path, entry.Blob); err != nil {
+			return err
+		}
+	}
+
+	s.current.Entries = append(s.current.Entries, entry)
+	s.recorded[path] = true
+	return nil
+}
+
+// List returns the committed checkpoints, oldest first.
+func (s *Store) List() []*Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Checkpoint(nil), s.checkpoints...)
+}
+
+// Restore rolls the working tree back to its state before the checkpoint with
+// the given id, undoing that checkpoint and every later one. The undone
+// checkpoints are removed from the store. It returns the restored paths.
+func (s *Store) Restore(id string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := -1
+	for i, cp := range s.checkpoints {
+		if cp.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("checkpoint '%s' not found", id)
+	}
+
+	var restored []string
+	for i := len(s.checkpoints) - 1; i >= index; i-- {
+		cp := s.checkpoints[i]
+		// Undo in reverse order, so that e.g. files are removed before the
+		// directories that were created to hold them.
+		for j := len(cp.Entries) - 1; j >= 0; j-- {
+			if err := s.restoreEntry(cp.Entries[j]); err != nil {
+				return restored, err
+			}
+			restored = append(restored, cp.Entries[j].Path)
+		}
+		s.checkpoints = s.checkpoints[:i]
+		os.RemoveAll(filepath.Join(s.dir, cp.ID))
+		if err := s.saveManifest(); err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+// Undo restores the most recent checkpoint. It returns nil if there is none.
+func (s *Store) Undo() (*Checkpoint, []string, error) {
+	checkpoints := s.List()
+	if len(checkpoints) == 0 {
+		return nil, nil, nil
+	}
+	last := checkpoints[len(checkpoints)-1]
+	restored, err := s.Restore(last.ID)
+	return last, restored, err
+}
+
+func (s *Store) restoreEntry(e Entry) error {
+	switch e.Kind {
+	case KindAbsent:
+		if err := os.Remove(e.Path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove '%s'", e.Path)
+		}
+	case KindDir:
+		if err := os.MkdirAll(e.Path, e.Mode); err != nil {
+			return errors.Wrapf(err, "could not recreate directory '%s'", e.Path)
+		}
+	case KindFile:
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Blob))
+		if err != nil {
+			return errors.Wrapf(err, "could not read saved content of '%s'", e.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.Path), 0755); err != nil {
+			return errors.Wrapf(err, "could not recreate parent directory of '%s'", e.Path)
+		}
+		if err := os.WriteFile(e.Path, data, e.Mode); err != nil {
+			return errors.Wrapf(err, "could not restore '%s'", e.Path)
+		}
+	default:
+		return errors.New("unknown checkpoint entry kind '%s' for '%s'", e.Kind, e.Path)
+	}
+	return nil
+}
+
+func (s *Store) saveBlob(path, blob string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not checkpoint '%s'", path)
+	}
+	blobPath := filepath.Join(s.dir, blob)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return errors.Wrapf(err, "could not create checkpoint directory")
+	}
+	if err := os.WriteFile(blobPath, data, 0644); err != nil {
+		return errors.Wrapf(err, "could not save checkpoint of '%s'", path)
+	}
+	return nil
+}
+
+func (s *Store) saveManifest() error {
+	data, err := json.MarshalIndent(s.checkpoints, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "failed to serialize checkpoints")
+	}
+	return os.WriteFile(s.manifestPath(), data, 0644)
+}
+
+func (s *Store) manifestPath() string {
+	return filepath.Join(s.dir, "checkpoints.json")
+}
+
+// Summary returns a one-line description of the checkpoint for listings.
+func (cp *Checkpoint) Summary() string {
+	return fmt.Sprintf("%s  %s  %d path(s)  %q", cp.ID, cp.Created.Format("2006-01-02 15:04:05"), len(cp.Entries), cp.Prompt)
+}
+
+type storeKey struct{}
+
+// WithStore returns a context carrying the store, so that tools can record
+// pre-images without knowing about sessions.
+func WithStore(ctx context.Context, s *Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, s)
+}
+
+// Record saves the state of path in the store carried by ctx, if any. Tools
+// call it right before changing path.
+func Record(ctx context.Context, path string) error {
+	s, ok := ctx.Value(storeKey{}).(*Store)
+	if !ok || s == nil {
+		return nil
+	}
+	return s.Record(path)
+}
@@ -0,0 +1,121 @@
+package checkpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordAndUndo(t *testing.T) {
+	chdirTemp(t)
+	if err := os.WriteFile("existing.txt", []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("doomed.txt", []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open("test")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	store.Begin("make changes")
+	ctx := WithStore(context.Background(), store)
+
+	// Modify, create from scratch, create in a new directory, and delete.
+	mustRecord(t, ctx, "existing.txt")
+	os.WriteFile("existing.txt", []byte("changed"), 0644)
+	mustRecord(t, ctx, "existing.txt") // Recording twice keeps the first pre-image.
+	os.WriteFile("existing.txt", []byte("changed again"), 0644)
+	mustRecord(t, ctx, "new.txt")
+	os.WriteFile("new.txt", []byte("new"), 0644)
+	mustRecord(t, ctx, "dir")
+	os.Mkdir("dir", 0755)
+	mustRecord(t, ctx, filepath.Join("dir", "inner.txt"))
+	os.WriteFile(filepath.Join("dir", "inner.txt"), []byte("inner"), 0644)
+	mustRecord(t, ctx, "doomed.txt")
+	os.Remove("doomed.txt")
+
+	cp, err := store.Commit()
+	if err != nil || cp == nil {
+		t.Fatalf("Commit = %v, %v", cp, err)
+	}
+
+	// A reopened store sees the committed checkpoint.
+	store, err = Open("test")
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, _, err := store.Undo(); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+
+	if data, _ := os.ReadFile("existing.txt"); string(data) != "original" {
+		t.Errorf("existing.txt = %q, want %q", data, "original")
+	}
+	if data, _ := os.ReadFile("doomed.txt"); string(data) != "keep me" {
+		t.Errorf("doomed.txt = %q, want %q", data, "keep me")
+	}
+	if info, err := os.Stat("doomed.txt"); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("doomed.txt mode = %v, want 0600", info.Mode().Perm())
+	}
+	for _, path := range []string{"new.txt", "dir"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s should have been removed, stat err = %v", path, err)
+		}
+	}
+	if len(store.List()) != 0 {
+		t.Errorf("undone checkpoint still listed: %v", store.List())
+	}
+}
+
+func TestRestoreUndoesLaterCheckpoints(t *testing.T) {
+	chdirTemp(t)
+	store, err := Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"one", "two", "three"} {
+		store.Begin("write " + content)
+		if err := store.Record("f.txt"); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile("f.txt", []byte(content), 0644)
+		if _, err := store.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Restore("2"); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if data, _ := os.ReadFile("f.txt"); string(data) != "one" {
+		t.Errorf("f.txt = %q, want %q", data, "one")
+	}
+	if got := store.List(); len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("remaining checkpoints = %v, want only checkpoint 1", got)
+	}
+
+	store.Begin("no changes")
+	if cp, _ := store.Commit(); cp != nil {
+		t.Errorf("empty turn produced checkpoint %v", cp)
+	}
+}
+
+func mustRecord(t *testing.T, ctx context.Context, path string) {
+	t.Helper()
+	if err := Record(ctx, path); err != nil {
+		t.Fatalf("Record(%s): %v", path, err)
+	}
+}
@@ -5,17 +5,30 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/m4xw311/compell/agent"
 	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
 	"github.com/m4xw311/compell/llm"
+	"github.com/m4xw311/compell/sandbox"
 	"github.com/m4xw311/compell/session"
 )
 
 func main() {
+	// compell re-executes itself to set up the command sandbox.
+	if sandbox.IsHelper() {
+		sandbox.RunHelper()
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "mcp-serve" {
+		mcpServe(os.Args[2:])
+		return
+	}
+
 	// Define flags
 	modeFlag := flag.String("m", "", "Execution mode: 'auto' or 'prompt'")
 	sessionFlag := flag.String("s", "", "Session name to create or use")
@@ -100,34 +113,10 @@ func main() {
 	}
 
 	// Initialize LLM Client
-	var client llm.LLMClient
-	switch cfg.LLMClient {
-	case "gemini":
-		client, err = llm.NewGeminiLLMClient(context.Background(), cfg.Model)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing Gemini client: %+v\n", err)
-			os.Exit(1)
-		}
-	case "openai":
-		client, err = llm.NewOpenAILLMClient(context.Background(), cfg.Model)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing OpenAI client: %+v\n", err)
-			os.Exit(1)
-		}
-	case "bedrock":
-		client, err = llm.NewBedrockLLMClient(context.Background(), cfg.Model)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing Bedrock client: %+v\n", err)
-			os.Exit(1)
-		}
-	case "anthropic":
-		client, err = llm.NewAnthropicLLMClient(context.Background(), cfg.Model)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing Anthropic client: %+v\n", err)
-			os.Exit(1)
-		}
-	default:
-		client = &llm.MockLLMClient{}
+	client, err := newLLMClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing LLM client: %+v\n", err)
+		os.Exit(1)
 	}
 
 	// Validate tool verbosity
@@ -151,6 +140,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Route Ctrl-C to the agent so it can cancel the current turn instead of
+	// killing the process mid-write.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	compellAgent.Interrupts = interrupts
+
 	// Get initial prompt from remaining arguments
 	initialPrompt := strings.Join(flag.Args(), " ")
 
@@ -180,6 +176,37 @@ func newBoolFlag(b *boolFlag) flag.Value {
 	return b
 }
 
+// newLLMClient returns the client of the configured LLM provider.
+func newLLMClient(cfg *config.Config) (llm.LLMClient, error) {
+	var client llm.LLMClient
+	var err error
+	switch cfg.LLMClient {
+	case "gemini":
+		client, err = llm.NewGeminiLLMClient(context.Background(), cfg.Model)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize Gemini client")
+		}
+	case "openai":
+		client, err = llm.NewOpenAILLMClient(context.Background(), cfg.Model)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize OpenAI client")
+		}
+	case "bedrock":
+		client, err = llm.NewBedrockLLMClient(context.Background(), cfg.Model)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize Bedrock client")
+		}
+	case "anthropic":
+		client, err = llm.NewAnthropicLLMClient(context.Background(), cfg.Model)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize Anthropic client")
+		}
+	default:
+		client = &llm.MockLLMClient{}
+	}
+	return client, nil
+}
+
 func defaultSessionName() string {
 	wd, err := os.Getwd()
 	if err != nil {
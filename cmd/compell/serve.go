@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/m4xw311/compell/agent"
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/mcpserver"
+)
+
+// mcpServe implements "compell mcp-serve", which serves compell's tools, or
+// with -agent the agent itself, to other MCP clients over stdio, or
+// Streamable HTTP with -http.
+func mcpServe(args []string) {
+	flags := flag.NewFlagSet("mcp-serve", flag.ExitOnError)
+	toolsetFlag := flags.String("t", "", "Toolset to serve (defaults to all built-in tools); with -agent, the default toolset of tasks")
+	httpFlag := flags.String("http", "", "Serve Streamable HTTP at this address, e.g. 'localhost:8765', instead of stdio")
+	agentFlag := flags.Bool("agent", false, "Serve the agent as the run_task tool instead of its tools")
+	modeFlag := flags.String("m", "auto", "With -agent, the default execution mode of tasks: 'auto' or 'prompt'")
+	flags.Parse(args)
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %+v\n", err)
+		os.Exit(1)
+	}
+
+	server, err := mcpserver.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing MCP server: %+v\n", err)
+		os.Exit(1)
+	}
+	defer server.Close()
+	if *httpFlag == "" {
+		server.RedirectStdout()
+	}
+
+	if *agentFlag {
+		mode := agent.Mode(*modeFlag)
+		if mode != agent.ModeAuto && mode != agent.ModePrompt {
+			fmt.Fprintf(os.Stderr, "Invalid mode '%s'. Must be 'auto' or 'prompt'.\n", *modeFlag)
+			os.Exit(1)
+		}
+		client, err := newLLMClient(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing LLM client: %+v\n", err)
+			os.Exit(1)
+		}
+		server.ServeAgent(mcpserver.AgentOptions{Client: client, Toolset: *toolsetFlag, Mode: mode})
+	} else if err := server.ServeTools(*toolsetFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing MCP server: %+v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *httpFlag != "" {
+		err = server.ListenAndServe(ctx, *httpFlag)
+	} else {
+		err = server.ServeStdio(ctx)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "MCP server stopped with an error: %+v\n", err)
+		server.Close()
+		os.Exit(1)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/m4xw311/compell/errors"
 	"gopkg.in/yaml.v3"
@@ -13,15 +14,142 @@ type FilesystemAccess struct {
 	ReadOnly []string `yaml:"read_only"`
 }
 
+// MCPServer is an MCP server whose tools are made available. Local servers
+// are started as a subprocess speaking stdio; remote servers are reached over
+// Streamable HTTP or SSE at URL.
 type MCPServer struct {
-	Name    string   `yaml:"name"`
-	Command string   `yaml:"command"`
-	Args    []string `yaml:"args"`
+	Name      string   `yaml:"name"`
+	Transport string   `yaml:"transport"` // stdio (default), http or sse
+	Command   string   `yaml:"command"`
+	Args      []string `yaml:"args"` // ${VAR} references are replaced by environment variables
+	// A local server only inherits the variables most programs need, such as
+	// PATH and HOME, those allowed by EnvFilter and those named in EnvFrom.
+	// Env sets further variables; ${VAR} references in their values are
+	// replaced by environment variables.
+	EnvFilter EnvFilter         `yaml:"env_filter"`
+	EnvFrom   []string          `yaml:"env_from"`
+	Env       map[string]string `yaml:"env"`
+	Cwd       string            `yaml:"cwd"` // Working directory of a local server
+	URL       string            `yaml:"url"`
+	// Headers are sent with every request to a remote server. ${VAR}
+	// references in URL and header values are replaced by environment
+	// variables, so that tokens need not be written into the config.
+	Headers map[string]string `yaml:"headers"`
+	// Timeout bounds each request to the server, such as a tool call.
+	// Defaults to 2 minutes.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// MCP server transports.
+const (
+	MCPTransportStdio = "stdio"
+	MCPTransportHTTP  = "http"
+	MCPTransportSSE   = "sse"
+)
+
+// Validate checks that the server has a known transport and what the
+// transport needs to connect.
+func (s MCPServer) Validate() error {
+	switch s.Transport {
+	case "", MCPTransportStdio:
+		if s.Command == "" {
+			return errors.New("MCP server '%s' needs a command", s.Name)
+		}
+	case MCPTransportHTTP, MCPTransportSSE:
+		if s.URL == "" {
+			return errors.New("MCP server '%s' needs a url for transport '%s'", s.Name, s.Transport)
+		}
+		if len(s.Env) > 0 || len(s.EnvFrom) > 0 || s.Cwd != "" {
+			return errors.New("MCP server '%s': env, env_from and cwd only apply to local servers, not transport '%s'", s.Name, s.Transport)
+		}
+	default:
+		return errors.New("invalid transport '%s' for MCP server '%s': must be 'stdio', 'http' or 'sse'", s.Transport, s.Name)
+	}
+	return nil
+}
+
+// EnvFilter selects the environment variables a child process inherits.
+// Patterns are globs matched against variable names, such as "AWS_*".
+// Variables matching Deny are removed. If Allow is set, only matching
+// variables are passed; otherwise all variables are passed except those that
+// look like credentials (e.g. "*_API_KEY", "*_TOKEN").
+type EnvFilter struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Redaction configures the removal of secrets from tool output before it is
+// saved in the session or sent to the LLM.
+type Redaction struct {
+	Disabled bool     `yaml:"disabled"`
+	Patterns []string `yaml:"patterns"` // Additional regular expressions to redact
 }
 
 type Toolset struct {
 	Name  string   `yaml:"name"`
 	Tools []string `yaml:"tools"`
+	// Aliases offer MCP tools of the toolset to the model under another name,
+	// keyed by the alias, e.g. "search: docs.search". MCP tools are otherwise
+	// named "<server>__<tool>".
+	Aliases map[string]string `yaml:"aliases"`
+}
+
+// Limits bounds the work the agent may do for a single user prompt. A zero
+// value disables the corresponding limit.
+type Limits struct {
+	MaxLLMCalls         int           `yaml:"max_llm_calls"`
+	MaxToolCalls        int           `yaml:"max_tool_calls"`
+	MaxDuration         time.Duration `yaml:"max_duration"`
+	MaxTokens           int           `yaml:"max_tokens"`
+	MaxCost             float64       `yaml:"max_cost"`
+	InputTokenCost      float64       `yaml:"input_token_cost"`  // Cost per million input tokens
+	OutputTokenCost     float64       `yaml:"output_token_cost"` // Cost per million output tokens
+	MaxRepeatedFailures int           `yaml:"max_repeated_failures"`
+}
+
+// CommandExecution configures the execute_command tool.
+type CommandExecution struct {
+	Timeout        time.Duration `yaml:"timeout"`          // Default timeout of a command line
+	MaxOutputBytes int           `yaml:"max_output_bytes"` // Output beyond this is truncated, keeping the head and tail
+}
+
+// Sandbox configures the optional Linux sandbox that commands run in. The
+// sandbox enforces filesystem_access, denies writes outside the workspace and
+// the Writable paths, disables networking unless Network is set and runs
+// commands with a cleaned environment.
+type Sandbox struct {
+	Enabled  bool          `yaml:"enabled"`
+	Backend  string        `yaml:"backend"`  // "auto" (default), "bubblewrap" or "landlock"
+	Network  bool          `yaml:"network"`  // Allow network access
+	Writable []string      `yaml:"writable"` // Additional absolute paths commands may write to
+	Env      []string      `yaml:"env"`      // Additional environment variables passed to commands
+	Limits   SandboxLimits `yaml:"limits"`
+}
+
+// SandboxLimits are resource limits applied to sandboxed commands. A zero
+// value leaves the corresponding limit unchanged.
+type SandboxLimits struct {
+	CPUSeconds    uint64 `yaml:"cpu_seconds" json:"cpu_seconds,omitempty"`
+	MemoryMB      uint64 `yaml:"memory_mb" json:"memory_mb,omitempty"` // Address space limit
+	MaxProcesses  uint64 `yaml:"max_processes" json:"max_processes,omitempty"`
+	MaxFileSizeMB uint64 `yaml:"max_file_size_mb" json:"max_file_size_mb,omitempty"`
+	MaxOpenFiles  uint64 `yaml:"max_open_files" json:"max_open_files,omitempty"`
+}
+
+// WebFetch configures the fetch_url tool.
+type WebFetch struct {
+	AllowedDomains []string      `yaml:"allowed_domains"` // If set, only these domains and their subdomains may be fetched
+	DeniedDomains  []string      `yaml:"denied_domains"`  // Domains that may never be fetched, including subdomains
+	Timeout        time.Duration `yaml:"timeout"`         // Time limit of a request
+	MaxBytes       int64         `yaml:"max_bytes"`       // Responses beyond this size are cut off
+	CacheTTL       time.Duration `yaml:"cache_ttl"`       // How long responses are cached; 0 disables caching
+}
+
+// Delegation configures the delegate_task tool, which runs a task in a
+// sub-agent with its own context.
+type Delegation struct {
+	Toolsets []string `yaml:"toolsets"` // Toolsets sub-agents may use, the first being the default
+	Limits   Limits   `yaml:"limits"`   // Limits of a single delegated task
 }
 
 type Config struct {
@@ -30,7 +158,20 @@ type Config struct {
 	Toolsets             []Toolset        `yaml:"toolsets"`
 	AdditionalMCPServers []MCPServer      `yaml:"additional_mcp_servers"`
 	AllowedCommands      []string         `yaml:"allowed_commands"`
+	CommandExecution     CommandExecution `yaml:"command_execution"`
 	FilesystemAccess     FilesystemAccess `yaml:"filesystem_access"`
+	Limits               Limits           `yaml:"limits"`
+	ApprovalRules        []PolicyRule     `yaml:"approval_rules"`
+	Sandbox              Sandbox          `yaml:"sandbox"`
+	// ToolEnvFilters selects the environment of the commands started by
+	// tools, keyed by tool name (execute_command, start_process).
+	ToolEnvFilters map[string]EnvFilter `yaml:"tool_env_filters"`
+	Redaction      Redaction            `yaml:"redaction"`
+	// ShadowCommits commits the working tree to refs/compell/<session> after
+	// every turn that changed it, leaving the current branch untouched.
+	ShadowCommits bool       `yaml:"shadow_commits"`
+	Delegation    Delegation `yaml:"delegation"`
+	WebFetch      WebFetch   `yaml:"web_fetch"`
 }
 
 // LoadConfig loads configuration from the user's home directory and the current
@@ -41,6 +182,19 @@ func LoadConfig() (*Config, error) {
 	// Default .compell directory to be hidden
 	cfg.FilesystemAccess.Hidden = append(cfg.FilesystemAccess.Hidden, ".compell", ".compell/**")
 
+	// Default per-turn limits so that a misbehaving model cannot loop forever
+	cfg.Limits.MaxLLMCalls = 100
+	cfg.Limits.MaxRepeatedFailures = 3
+	cfg.WebFetch.Timeout = 30 * time.Second
+	cfg.WebFetch.MaxBytes = 5 * 1024 * 1024
+	cfg.WebFetch.CacheTTL = 15 * time.Minute
+
+	cfg.Delegation.Limits.MaxLLMCalls = 50
+	cfg.Delegation.Limits.MaxRepeatedFailures = 3
+
+	cfg.CommandExecution.Timeout = 2 * time.Minute
+	cfg.CommandExecution.MaxOutputBytes = 32 * 1024
+
 	// Load user-level config first
 	home, err := os.UserHomeDir()
 	if err == nil {
@@ -64,6 +218,18 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	for _, rule := range cfg.ApprovalRules {
+		if err := rule.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid approval rule")
+		}
+	}
+	cfg.ApprovalRules = append(cfg.ApprovalRules, defaultApprovalRules...)
+	for _, server := range cfg.AdditionalMCPServers {
+		if err := server.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid MCP server")
+		}
+	}
+
 	return cfg, nil
 }
 
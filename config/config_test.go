@@ -6,3 +6,22 @@ func TestConfig(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("Config test not yet implemented.")
 }
+
+func TestMCPServerValidate(t *testing.T) {
+	for _, tc := range []struct {
+		server MCPServer
+		valid  bool
+	}{
+		{MCPServer{Name: "gopls", Command: "gopls", Args: []string{"mcp"}}, true},
+		{MCPServer{Name: "gopls", Transport: MCPTransportStdio}, false},
+		{MCPServer{Name: "team", Transport: MCPTransportHTTP, URL: "https://mcp.example.com/mcp"}, true},
+		{MCPServer{Name: "team", Transport: MCPTransportSSE}, false},
+		{MCPServer{Name: "team", Transport: "websocket", URL: "wss://mcp.example.com"}, false},
+		{MCPServer{Name: "pse", Command: "npx", Env: map[string]string{"API_KEY": "${PSE_API_KEY}"}, Cwd: "tools"}, true},
+		{MCPServer{Name: "team", Transport: MCPTransportHTTP, URL: "https://mcp.example.com/mcp", EnvFrom: []string{"HTTPS_PROXY"}}, false},
+	} {
+		if err := tc.server.Validate(); (err == nil) != tc.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tc.server, err, tc.valid)
+		}
+	}
+}
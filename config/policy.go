@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// PolicyAction is the outcome of evaluating approval rules for a tool call.
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow"
+	PolicyAsk   PolicyAction = "ask"
+	PolicyDeny  PolicyAction = "deny"
+)
+
+// defaultApprovalRules are evaluated after the configured rules. They make
+// tools that change the repository history ask for approval even in auto mode,
+// unless a configured rule says otherwise.
+var defaultApprovalRules = []PolicyRule{
+	{Tool: "git_commit", Action: PolicyAsk},
+	{Tool: "git_branch", Args: map[string]string{"name": ".*"}, Action: PolicyAsk},
+}
+
+// PolicyRule decides how a tool call is approved. Tool is a glob matched
+// against the tool name (e.g. "read_*"). Args maps argument names to regular
+// expressions that must match the whole argument value; a rule with Args only
+// matches calls that have all of the listed arguments.
+type PolicyRule struct {
+	Tool   string            `yaml:"tool" json:"tool"`
+	Args   map[string]string `yaml:"args,omitempty" json:"args,omitempty"`
+	Action PolicyAction      `yaml:"action" json:"action"`
+}
+
+// Validate checks that the rule has a known action and well-formed patterns.
+func (r PolicyRule) Validate() error {
+	switch r.Action {
+	case PolicyAllow, PolicyAsk, PolicyDeny:
+	default:
+		return errors.New("invalid action '%s' for tool '%s': must be 'allow', 'ask' or 'deny'", r.Action, r.Tool)
+	}
+	if _, err := path.Match(r.Tool, ""); err != nil {
+		return errors.Wrapf(err, "invalid tool pattern '%s'", r.Tool)
+	}
+	for name, pattern := range r.Args {
+		if _, err := compileArgPattern(pattern); err != nil {
+			return errors.Wrapf(err, "invalid pattern for argument '%s' of tool '%s'", name, r.Tool)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to a call of toolName with args.
+func (r PolicyRule) Matches(toolName string, args map[string]interface{}) (bool, error) {
+	ok, err := path.Match(r.Tool, toolName)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid tool pattern '%s'", r.Tool)
+	}
+	if !ok {
+		return false, nil
+	}
+	for name, pattern := range r.Args {
+		value, present := args[name]
+		if !present {
+			return false, nil
+		}
+		re, err := compileArgPattern(pattern)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid pattern for argument '%s' of tool '%s'", name, r.Tool)
+		}
+		if !re.MatchString(fmt.Sprint(value)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// EvaluatePolicy returns the action of the first rule that matches the call.
+// The boolean result is false when no rule matched. Tool calls are evaluated
+// with tools.EvaluatePolicy, which splits command lines and cleans paths
+// first.
+func EvaluatePolicy(rules []PolicyRule, toolName string, args map[string]interface{}) (PolicyAction, bool, error) {
+	for _, rule := range rules {
+		ok, err := rule.Matches(toolName, args)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			return rule.Action, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// compileArgPattern compiles an argument pattern anchored at both ends, so
+// that "ls .*" must match the whole value. Command lines are matched per
+// simple command, see tools.EvaluatePolicy, so that "ls .*" does not allow
+// "ls; rm -rf /" and "rm .*" does deny "echo hi && rm -rf src".
+func compileArgPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
@@ -0,0 +1,69 @@
+package config
+
+import "testing"
+
+func TestEvaluatePolicy(t *testing.T) {
+	rules := []PolicyRule{
+		{Tool: "read_*", Action: PolicyAllow},
+		{Tool: "write_file", Args: map[string]string{"path": "src/.*"}, Action: PolicyAllow},
+		{Tool: "write_file", Action: PolicyAsk},
+		{Tool: "execute_command", Args: map[string]string{"command": "rm .*"}, Action: PolicyDeny},
+	}
+
+	tests := []struct {
+		tool    string
+		args    map[string]interface{}
+		want    PolicyAction
+		matched bool
+	}{
+		{"read_file", map[string]interface{}{"path": "main.go"}, PolicyAllow, true},
+		{"read_dir", nil, PolicyAllow, true},
+		{"write_file", map[string]interface{}{"path": "src/a.go"}, PolicyAllow, true},
+		{"write_file", map[string]interface{}{"path": "main.go"}, PolicyAsk, true},
+		{"execute_command", map[string]interface{}{"command": "rm -rf /"}, PolicyDeny, true},
+		// Patterns are anchored, so a command merely containing "rm " does not match.
+		{"execute_command", map[string]interface{}{"command": "echo rm -rf /"}, "", false},
+		{"delete_file", map[string]interface{}{"path": "a.txt"}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, matched, err := EvaluatePolicy(rules, tt.tool, tt.args)
+		if err != nil {
+			t.Fatalf("EvaluatePolicy(%s, %v): %v", tt.tool, tt.args, err)
+		}
+		if got != tt.want || matched != tt.matched {
+			t.Errorf("EvaluatePolicy(%s, %v) = %q, %t; want %q, %t", tt.tool, tt.args, got, matched, tt.want, tt.matched)
+		}
+	}
+}
+
+func TestPolicyRuleValidate(t *testing.T) {
+	if err := (PolicyRule{Tool: "read_file", Action: "maybe"}).Validate(); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+	if err := (PolicyRule{Tool: "execute_command", Args: map[string]string{"command": "("}, Action: PolicyDeny}).Validate(); err == nil {
+		t.Error("expected an error for an invalid argument pattern")
+	}
+	if err := (PolicyRule{Tool: "read_*", Action: PolicyAllow}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDefaultApprovalRules(t *testing.T) {
+	rules := append([]PolicyRule{{Tool: "git_commit", Action: PolicyAllow}}, defaultApprovalRules...)
+	for _, tt := range []struct {
+		tool    string
+		args    map[string]interface{}
+		want    PolicyAction
+		matched bool
+	}{
+		{"git_commit", map[string]interface{}{"message": "x"}, PolicyAllow, true},
+		{"git_branch", map[string]interface{}{}, "", false},
+		{"git_branch", map[string]interface{}{"name": "feature"}, PolicyAsk, true},
+	} {
+		got, matched, err := EvaluatePolicy(rules, tt.tool, tt.args)
+		if err != nil || got != tt.want || matched != tt.matched {
+			t.Errorf("EvaluatePolicy(%s, %v) = %q, %t, %v; want %q, %t", tt.tool, tt.args, got, matched, err, tt.want, tt.matched)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"path/filepath"
 	"runtime"
@@ -33,3 +34,15 @@ func Wrapf(err error, format string, a ...interface{}) error {
 	}
 	return fmt.Errorf("[%s:%d] %s: %w", file, line, fmt.Sprintf(format, a...), err)
 }
+
+// Is reports whether any error in err's chain matches target. It is a thin
+// wrapper around the standard library so callers need only import this package.
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if one is
+// found, sets target to that error value and returns true.
+func As(err error, target any) bool {
+	return stderrors.As(err, target)
+}
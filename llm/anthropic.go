@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -47,9 +48,12 @@ func (a *AnthropicLLMClient) Chat(ctx context.Context, messages []session.Messag
 
 	params := anthropic.MessageNewParams{
 		Model:     anthropic.Model(a.model),
-		MaxTokens: 4096,
+		MaxTokens: defaultMaxTokens,
 		Messages:  anthropicMessages,
 	}
+	if n := maxTokens(ctx); n > 0 {
+		params.MaxTokens = int64(n)
+	}
 
 	if systemPrompt != "" {
 		params.System = []anthropic.TextBlockParam{
@@ -119,19 +123,18 @@ func convertMessagesToAnthropicMessages(messages []session.Message) ([]anthropic
 		case "tool":
 			// Handle tool responses
 			if len(msg.ToolCalls) > 0 {
+				result := toolResultOf(msg)
+				block := &anthropic.ToolResultBlockParam{
+					ToolUseID: msg.ToolCalls[0].ToolCallID,
+					Content:   convertToolResultContent(result),
+				}
+				if result.IsError {
+					block.IsError = anthropic.Bool(true)
+				}
 				anthropicMessages = append(anthropicMessages, anthropic.MessageParam{
-					Role: anthropic.MessageParamRoleUser,
-					Content: []anthropic.ContentBlockParamUnion{{
-						OfToolResult: &anthropic.ToolResultBlockParam{
-							ToolUseID: msg.ToolCalls[0].ToolCallID,
-							Content: []anthropic.ToolResultBlockParamContentUnion{{
-								OfText: &anthropic.TextBlockParam{
-									Text: msg.Content,
-								},
-							}},
-						},
-					},
-					}})
+					Role:    anthropic.MessageParamRoleUser,
+					Content: []anthropic.ContentBlockParamUnion{{OfToolResult: block}},
+				})
 			}
 		case "system":
 			// Handle system messages (take the last one as the system prompt)
@@ -142,6 +145,36 @@ func convertMessagesToAnthropicMessages(messages []session.Message) ([]anthropic
 	return anthropicMessages, systemPrompt
 }
 
+// convertToolResultContent converts the parts of a tool result to Anthropic
+// content blocks. Supported images are sent as images, other binary parts as
+// a textual placeholder.
+func convertToolResultContent(result session.ToolResult) []anthropic.ToolResultBlockParamContentUnion {
+	var content []anthropic.ToolResultBlockParamContentUnion
+	for _, part := range result.Content {
+		if part.Type == session.PartImage && isAnthropicImageType(part.MIMEType) {
+			content = append(content, anthropic.ToolResultBlockParamContentUnion{
+				OfImage: &anthropic.ImageBlockParam{
+					Source: anthropic.ImageBlockParamSourceUnion{
+						OfBase64: &anthropic.Base64ImageSourceParam{
+							Data:      base64.StdEncoding.EncodeToString(part.Data),
+							MediaType: anthropic.Base64ImageSourceMediaType(part.MIMEType),
+						},
+					},
+				},
+			})
+			continue
+		}
+		text := part.Text
+		if part.Type != session.PartText {
+			text = session.ToolResult{Content: []session.ContentPart{part}}.Text()
+		}
+		content = append(content, anthropic.ToolResultBlockParamContentUnion{
+			OfText: &anthropic.TextBlockParam{Text: text},
+		})
+	}
+	return content
+}
+
 // convertToolsToAnthropicTools converts our Tool interface to Anthropic's tool format.
 func convertToolsToAnthropicTools(ts []tools.Tool) []anthropic.ToolParam {
 	if len(ts) == 0 {
@@ -194,5 +227,9 @@ func processAnthropicResponse(resp *anthropic.Message) (*session.Message, error)
 		Role:      "assistant",
 		Content:   responseContent,
 		ToolCalls: toolCalls,
+		Usage: &session.Usage{
+			InputTokens:  int(resp.Usage.InputTokens),
+			OutputTokens: int(resp.Usage.OutputTokens),
+		},
 	}, nil
 }
@@ -1,8 +1,36 @@
 package llm
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/m4xw311/compell/session"
+)
 
 func TestAnthropic(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("Anthropic test not yet implemented.")
 }
+
+func TestConvertToolResultToAnthropicMessages(t *testing.T) {
+	result := session.ErrorResult("Command exited with code 2.")
+	messages := []session.Message{
+		{
+			Role:      "tool",
+			Content:   result.Text(),
+			Result:    &result,
+			ToolCalls: []session.ToolCall{{ToolCallID: "call_1", Name: "execute_command"}},
+		},
+	}
+
+	converted, _ := convertMessagesToAnthropicMessages(messages)
+	block := converted[0].Content[0].OfToolResult
+	if block == nil {
+		t.Fatalf("Expected a tool result block, got %+v", converted[0].Content[0])
+	}
+	if !block.IsError.Valid() || !block.IsError.Value {
+		t.Errorf("Expected is_error to be set")
+	}
+	if len(block.Content) != 1 || block.Content[0].OfText == nil || block.Content[0].OfText.Text != "Command exited with code 2." {
+		t.Errorf("Unexpected tool result content: %+v", block.Content)
+	}
+}
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -63,7 +64,11 @@ func (b *BedrockLLMClient) Chat(ctx context.Context, messages []session.Message,
 	anthropicMessages, systemPrompt := convertMessagesToAnthropicFormat(messages)
 
 	// Create the request body for Anthropic on Bedrock
-	requestBody, err := createAnthropicRequest(anthropicMessages, systemPrompt, availableTools)
+	limit := maxTokens(ctx)
+	if limit <= 0 {
+		limit = defaultMaxTokens
+	}
+	requestBody, err := createAnthropicRequest(anthropicMessages, systemPrompt, availableTools, limit)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create Anthropic request")
 	}
@@ -131,15 +136,18 @@ func convertMessagesToAnthropicFormat(messages []session.Message) ([]map[string]
 		case "tool":
 			// Handle tool responses
 			if len(msg.ToolCalls) > 0 {
+				result := toolResultOf(msg)
+				block := map[string]interface{}{
+					"type":        "tool_result",
+					"tool_use_id": msg.ToolCalls[0].ToolCallID,
+					"content":     convertToolResultToBedrock(result),
+				}
+				if result.IsError {
+					block["is_error"] = true
+				}
 				anthropicMessages = append(anthropicMessages, map[string]interface{}{
-					"role": "user",
-					"content": []map[string]interface{}{
-						{
-							"type":        "tool_result",
-							"tool_use_id": msg.ToolCalls[0].ToolCallID,
-							"content":     msg.Content,
-						},
-					},
+					"role":    "user",
+					"content": []map[string]interface{}{block},
 				})
 			}
 		}
@@ -148,11 +156,12 @@ func convertMessagesToAnthropicFormat(messages []session.Message) ([]map[string]
 	return anthropicMessages, systemPrompt
 }
 
-// createAnthropicRequest creates the request body for Anthropic models on Bedrock.
-func createAnthropicRequest(messages []map[string]interface{}, systemPrompt string, availableTools []tools.Tool) ([]byte, error) {
+// createAnthropicRequest creates the request body for Anthropic models on
+// Bedrock, generating at most maxTokens tokens.
+func createAnthropicRequest(messages []map[string]interface{}, systemPrompt string, availableTools []tools.Tool, maxTokens int) ([]byte, error) {
 	request := map[string]interface{}{
 		"anthropic_version": "bedrock-2023-05-31",
-		"max_tokens":        4096,
+		"max_tokens":        maxTokens,
 		"messages":          messages,
 	}
 
@@ -242,9 +251,43 @@ func processBedrockResponse(body []byte, availableTools []tools.Tool) (*session.
 		}
 	}
 
-	return &session.Message{
+	msg := &session.Message{
 		Role:      "assistant",
 		Content:   responseContent,
 		ToolCalls: toolCalls,
-	}, nil
+	}
+	if usage, ok := response["usage"].(map[string]interface{}); ok {
+		inputTokens, _ := usage["input_tokens"].(float64)
+		outputTokens, _ := usage["output_tokens"].(float64)
+		msg.Usage = &session.Usage{
+			InputTokens:  int(inputTokens),
+			OutputTokens: int(outputTokens),
+		}
+	}
+	return msg, nil
+}
+
+// convertToolResultToBedrock converts the parts of a tool result to content
+// blocks of the Anthropic messages format used on Bedrock.
+func convertToolResultToBedrock(result session.ToolResult) []map[string]interface{} {
+	var content []map[string]interface{}
+	for _, part := range result.Content {
+		if part.Type == session.PartImage && isAnthropicImageType(part.MIMEType) {
+			content = append(content, map[string]interface{}{
+				"type": "image",
+				"source": map[string]interface{}{
+					"type":       "base64",
+					"media_type": part.MIMEType,
+					"data":       base64.StdEncoding.EncodeToString(part.Data),
+				},
+			})
+			continue
+		}
+		text := part.Text
+		if part.Type != session.PartText {
+			text = session.ToolResult{Content: []session.ContentPart{part}}.Text()
+		}
+		content = append(content, map[string]interface{}{"type": "text", "text": text})
+	}
+	return content
 }
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/m4xw311/compell/session"
@@ -22,8 +23,8 @@ func (m *MockTool) Description() string {
 	return m.description
 }
 
-func (m *MockTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
-	return "mock result", nil
+func (m *MockTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	return session.TextResult("mock result"), nil
 }
 
 func TestConvertMessagesToAnthropicFormat(t *testing.T) {
@@ -120,7 +121,7 @@ func TestCreateAnthropicRequest(t *testing.T) {
 	}
 
 	// Test with no tools
-	body, err := createAnthropicRequest(messages, "", nil)
+	body, err := createAnthropicRequest(messages, "", nil, 100)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -128,6 +129,9 @@ func TestCreateAnthropicRequest(t *testing.T) {
 	if len(body) == 0 {
 		t.Error("Expected non-empty request body")
 	}
+	if !strings.Contains(string(body), `"max_tokens":100`) {
+		t.Errorf("Expected max_tokens of 100, got %s", body)
+	}
 
 	// Test with tools
 	tools := []tools.Tool{
@@ -137,7 +141,7 @@ func TestCreateAnthropicRequest(t *testing.T) {
 		},
 	}
 
-	body, err = createAnthropicRequest(messages, "", tools)
+	body, err = createAnthropicRequest(messages, "", tools, defaultMaxTokens)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -146,3 +150,37 @@ func TestCreateAnthropicRequest(t *testing.T) {
 		t.Error("Expected non-empty request body")
 	}
 }
+
+func TestConvertToolResultToAnthropicFormat(t *testing.T) {
+	result := session.ErrorResult("file not found")
+	result.Content = append(result.Content, session.ContentPart{Type: session.PartImage, MIMEType: "image/png", Data: []byte{1, 2, 3}})
+	messages := []session.Message{
+		{
+			Role:      "tool",
+			Content:   result.Text(),
+			Result:    &result,
+			ToolCalls: []session.ToolCall{{ToolCallID: "call_1", Name: "read_file"}},
+		},
+	}
+
+	converted, _ := convertMessagesToAnthropicFormat(messages)
+	block := converted[0]["content"].([]map[string]interface{})[0]
+	if block["is_error"] != true {
+		t.Errorf("Expected is_error to be set, got %v", block["is_error"])
+	}
+	content := block["content"].([]map[string]interface{})
+	if len(content) != 2 || content[0]["text"] != "file not found" || content[1]["type"] != "image" {
+		t.Errorf("Unexpected tool result content: %v", content)
+	}
+	source := content[1]["source"].(map[string]interface{})
+	if source["media_type"] != "image/png" || source["data"] != "AQID" {
+		t.Errorf("Unexpected image source: %v", source)
+	}
+
+	// Messages saved without a result are sent as successful text.
+	messages[0].Result = nil
+	converted, _ = convertMessagesToAnthropicFormat(messages)
+	if block := converted[0]["content"].([]map[string]interface{})[0]; block["is_error"] != nil {
+		t.Errorf("Expected no is_error for a message without a result, got %v", block["is_error"])
+	}
+}
@@ -13,6 +13,43 @@ type LLMClient interface {
 	Chat(ctx context.Context, messages []session.Message, availableTools []tools.Tool) (*session.Message, error)
 }
 
+// defaultMaxTokens bounds the response of the providers that require a limit.
+const defaultMaxTokens = 4096
+
+type maxTokensKey struct{}
+
+// WithMaxTokens returns a context limiting the tokens the model may generate
+// in the Chat calls made with it, e.g. for the sampling requests of MCP
+// servers, which set their own limit.
+func WithMaxTokens(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxTokensKey{}, n)
+}
+
+// maxTokens returns the limit set with WithMaxTokens, or 0.
+func maxTokens(ctx context.Context) int {
+	n, _ := ctx.Value(maxTokensKey{}).(int)
+	return n
+}
+
+// toolResultOf returns the result carried by a "tool" message. Sessions saved
+// before results were recorded only have the text of the result.
+func toolResultOf(msg session.Message) session.ToolResult {
+	if msg.Result != nil {
+		return *msg.Result
+	}
+	return session.TextResult(msg.Content)
+}
+
+// isAnthropicImageType reports whether Anthropic models accept images of the
+// MIME type. Other images are described in text instead.
+func isAnthropicImageType(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
 // MockLLMClient is a placeholder for testing that can be configured to
 // return specific responses, including text and tool calls.
 type MockLLMClient struct {
@@ -1,8 +1,20 @@
 package llm
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestClient(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("Client test not yet implemented.")
 }
+
+func TestWithMaxTokens(t *testing.T) {
+	if n := maxTokens(context.Background()); n != 0 {
+		t.Errorf("maxTokens without a limit = %d", n)
+	}
+	if n := maxTokens(WithMaxTokens(context.Background(), 200)); n != 200 {
+		t.Errorf("maxTokens = %d, want 200", n)
+	}
+}
@@ -45,6 +45,10 @@ func (g *GeminiLLMClient) Chat(ctx context.Context, messages []session.Message,
 	// Convert available tools to Gemini's tool format.
 	geminiTools := convertToolsToGeminiTools(availableTools)
 	g.model.Tools = geminiTools
+	g.model.MaxOutputTokens = nil
+	if n := maxTokens(ctx); n > 0 {
+		g.model.SetMaxOutputTokens(int32(n))
+	}
 
 	// The last message is the new prompt.
 	lastMessage := history[len(history)-1]
@@ -90,11 +94,16 @@ func convertMessagesToGeminiContent(messages []session.Message) []*genai.Content
 				continue // Skip this malformed message
 			}
 			toolName := msg.ToolCalls[0].Name
+			// The response needs to be a JSON-serializable map or struct.
+			// We wrap the raw string output in a map, under "error" for failed
+			// calls as Gemini expects.
+			key := "output"
+			if toolResultOf(msg).IsError {
+				key = "error"
+			}
 			parts = append(parts, genai.FunctionResponse{
-				Name: toolName,
-				// The response needs to be a JSON-serializable map or struct.
-				// We wrap the raw string output in a map.
-				Response: map[string]interface{}{"output": msg.Content},
+				Name:     toolName,
+				Response: map[string]interface{}{key: msg.Content},
 			})
 		case "user":
 			fallthrough
@@ -190,9 +199,16 @@ func processGeminiResponse(ctx context.Context, resp *genai.GenerateContentRespo
 		}
 	}
 
-	return &session.Message{
+	msg := &session.Message{
 		Role:      "assistant",
 		Content:   responseContent,
 		ToolCalls: toolCalls,
-	}, nil
+	}
+	if resp.UsageMetadata != nil {
+		msg.Usage = &session.Usage{
+			InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
+			OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+		}
+	}
+	return msg, nil
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/m4xw311/compell/errors"
 	"github.com/m4xw311/compell/session"
@@ -54,6 +55,9 @@ func (o *OpenAILLMClient) Chat(ctx context.Context, messages []session.Message,
 		Messages: chatMessages,
 		Tools:    convertToolsToOpenAITools(availableTools),
 	}
+	if n := maxTokens(ctx); n > 0 {
+		params.MaxCompletionTokens = openai.Int(int64(n))
+	}
 
 	resp, err := o.client.Chat.Completions.New(ctx, params)
 	if err != nil {
@@ -70,6 +74,10 @@ func processOpenaiResponse(resp *openai.ChatCompletion) (*session.Message, error
 	}
 
 	choice := resp.Choices[0].Message
+	usage := &session.Usage{
+		InputTokens:  int(resp.Usage.PromptTokens),
+		OutputTokens: int(resp.Usage.CompletionTokens),
+	}
 
 	// If model requests tool calls, the ToolCalls field will be present.
 	if len(choice.ToolCalls) > 0 {
@@ -90,11 +98,12 @@ func processOpenaiResponse(resp *openai.ChatCompletion) (*session.Message, error
 			Role:      "assistant",
 			Content:   choice.Content,
 			ToolCalls: sessToolCalls,
+			Usage:     usage,
 		}, nil
 	}
 
 	// Otherwise, return a normal assistant text response.
-	return &session.Message{Role: "assistant", Content: choice.Content}, nil
+	return &session.Message{Role: "assistant", Content: choice.Content, Usage: usage}, nil
 }
 
 // convertMessagesToOpenaiContent converts our internal message format to OpenAI's.
@@ -133,7 +142,13 @@ func convertMessagesToOpenaiContent(messages []session.Message) []openai.ChatCom
 				fmt.Printf("Warning: tool message is malformed; expected exactly one ToolCall to identify the function name, but found %d. Skipping.\n", len(msg.ToolCalls))
 				continue
 			}
-			chatMessages = append(chatMessages, openai.ToolMessage(msg.Content, msg.ToolCalls[0].ToolCallID))
+			// Tool messages have no error flag in the OpenAI API, so failures
+			// are marked in the content.
+			content := msg.Content
+			if toolResultOf(msg).IsError && !strings.HasPrefix(content, "Error") {
+				content = "Error: " + content
+			}
+			chatMessages = append(chatMessages, openai.ToolMessage(content, msg.ToolCalls[0].ToolCallID))
 		case "user":
 			fallthrough
 		default:
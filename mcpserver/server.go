@@ -0,0 +1,306 @@
+// Package mcpserver serves compell's tools to other MCP clients, such as
+// editors and other agents, over stdio or Streamable HTTP.
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/secrets"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// sessionTools keep their state in the agent's session, which MCP clients do
+// not have, so they are not served.
+var sessionTools = map[string]bool{
+	"todo_write": true,
+	"todo_read":  true,
+}
+
+// Server serves compell to MCP clients: its tools, and the agent itself
+// through the run_task tool (see task.go). Tool calls are checked exactly
+// like the agent's: the tools enforce filesystem_access and allowed_commands
+// themselves, the configured approval rules are applied, and secrets are
+// redacted from the results. There is no user to ask for approval, so calls
+// that need it are refused; as in auto mode, calls no rule matches are
+// allowed, since the MCP client has its own approval.
+type Server struct {
+	cfg      *config.Config
+	registry *tools.ToolRegistry // Nil until ServeTools is called
+	redactor *secrets.Redactor
+	tools    []tools.Tool
+	server   *mcpsdk.Server
+	stdout   *os.File // The real stdout once RedirectStdout has been called
+
+	mu       sync.Mutex
+	busy     map[string]bool // Sessions a task is running in
+	nextTask int
+}
+
+// New returns a server that offers no tools yet.
+func New(cfg *config.Config) (*Server, error) {
+	redactor, err := secrets.NewRedactor(&cfg.Redaction)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set up redaction")
+	}
+	redactor.AddSecrets(tools.MCPServerSecrets(cfg))
+	return &Server{
+		cfg:      cfg,
+		redactor: redactor,
+		server:   mcpsdk.NewServer(&mcpsdk.Implementation{Name: "compell", Version: "v1.0.0"}, nil),
+		busy:     make(map[string]bool),
+	}, nil
+}
+
+// ServeTools offers the built-in tools, or the tools of the named toolset if
+// toolset is not empty.
+func (s *Server) ServeTools(toolset string) error {
+	registry := tools.NewToolRegistry(s.cfg)
+	served := registry.BuiltinTools()
+	if toolset != "" {
+		ts, err := s.cfg.GetToolset(toolset)
+		if err != nil {
+			registry.Close()
+			return errors.Wrapf(err, "failed to get toolset")
+		}
+		if served, err = registry.GetActiveTools(ts); err != nil {
+			registry.Close()
+			return errors.Wrapf(err, "failed to get active tools")
+		}
+	}
+	s.registry = registry
+	for _, t := range served {
+		if sessionTools[t.Name()] {
+			continue
+		}
+		s.AddTool(t)
+	}
+	return nil
+}
+
+// AddTool serves t, with the schema of its arguments if it has one (see
+// tools.Schemer). Otherwise, like the tools offered to the model, it takes
+// an object of arguments described by its description.
+func (s *Server) AddTool(t tools.Tool) {
+	s.tools = append(s.tools, t)
+	schema := &jsonschema.Schema{Type: "object"}
+	if schemer, ok := t.(tools.Schemer); ok {
+		schema = schemer.Schema()
+	}
+	s.server.AddTool(&mcpsdk.Tool{
+		Name:        t.Name(),
+		Description: t.Description(),
+		InputSchema: schema,
+	}, func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+		return toCallToolResult(s.call(ctx, t, params.Arguments)), nil
+	})
+}
+
+// Tools returns the served tools.
+func (s *Server) Tools() []tools.Tool {
+	return s.tools
+}
+
+// MCPServer returns the underlying MCP server, e.g. to connect it to a
+// transport of its own.
+func (s *Server) MCPServer() *mcpsdk.Server {
+	return s.server
+}
+
+// Close stops the background processes and MCP servers started by the tools.
+func (s *Server) Close() {
+	if s.registry != nil {
+		s.registry.Close()
+	}
+}
+
+// call runs a tool call the way the agent does, reporting failures as error
+// results.
+func (s *Server) call(ctx context.Context, t tools.Tool, args map[string]any) session.ToolResult {
+	action, matched, err := tools.EvaluatePolicy(s.cfg.ApprovalRules, t.Name(), args)
+	if err != nil {
+		return session.ErrorResult(fmt.Sprintf("Error executing tool %s: %v", t.Name(), err))
+	}
+	if matched {
+		switch action {
+		case config.PolicyDeny:
+			return session.ErrorResult("Tool execution denied by policy.")
+		case config.PolicyAsk:
+			return session.ErrorResult("Tool execution needs the user's approval, which cannot be asked for over MCP. " +
+				"Allow the call with an approval rule in the configuration.")
+		}
+	}
+
+	result, err := t.Execute(ctx, args)
+	if err != nil {
+		result = session.ErrorResult(fmt.Sprintf("Error executing tool %s: %v", t.Name(), err))
+	}
+	for i, part := range result.Content {
+		if part.Type == session.PartText {
+			result.Content[i].Text = s.redactor.Redact(part.Text)
+		}
+	}
+	return result
+}
+
+// toCallToolResult converts the result of a tool to MCP content.
+func toCallToolResult(result session.ToolResult) *mcpsdk.CallToolResult {
+	res := &mcpsdk.CallToolResult{IsError: result.IsError}
+	for _, part := range result.Content {
+		switch part.Type {
+		case session.PartImage:
+			res.Content = append(res.Content, &mcpsdk.ImageContent{MIMEType: part.MIMEType, Data: part.Data})
+		case session.PartAudio:
+			res.Content = append(res.Content, &mcpsdk.AudioContent{MIMEType: part.MIMEType, Data: part.Data})
+		default:
+			res.Content = append(res.Content, &mcpsdk.TextContent{Text: part.Text})
+		}
+	}
+	if len(res.Content) == 0 {
+		// Some clients reject results without content.
+		res.Content = []mcpsdk.Content{&mcpsdk.TextContent{Text: ""}}
+	}
+	return res
+}
+
+// RedirectStdout points os.Stdout at stderr and keeps the real stdout for
+// ServeStdio, whose protocol it carries. Setting up the tools prints too, e.g.
+// when MCP servers are started, so call it before ServeTools or ServeAgent
+// when serving over stdio.
+func (s *Server) RedirectStdout() {
+	if s.stdout == nil {
+		s.stdout, os.Stdout = os.Stdout, os.Stderr
+	}
+}
+
+// ServeStdio serves a single client over stdin and stdout until it
+// disconnects or ctx is done. Stdout carries the protocol, so everything
+// compell prints meanwhile, such as warnings, goes to stderr (see
+// RedirectStdout).
+func (s *Server) ServeStdio(ctx context.Context) error {
+	s.RedirectStdout()
+	// The transport writes to os.Stdout as it is when the transport is created.
+	os.Stdout = s.stdout
+	transport := mcpsdk.NewStdioTransport()
+	os.Stdout = os.Stderr
+	if err := s.server.Run(ctx, transport); err != nil && ctx.Err() == nil {
+		return errors.Wrapf(err, "failed to serve MCP over stdio")
+	}
+	return nil
+}
+
+// tokenEnv names the environment variable holding the bearer token HTTP
+// clients must send. If it is not set, a token is generated and printed.
+const tokenEnv = "COMPELL_MCP_TOKEN"
+
+// ListenAndServe serves clients over Streamable HTTP at addr, e.g.
+// "localhost:8765", until ctx is done. Clients must send the bearer token
+// taken from COMPELL_MCP_TOKEN or generated at start, and requests from web
+// pages of other sites are refused (see httpHandler).
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		var err error
+		if token, err = generateToken(); err != nil {
+			return err
+		}
+		fmt.Printf("INFO: Clients must send the header 'Authorization: Bearer %s' (set %s to choose the token)\n", token, tokenEnv)
+	}
+	// The token must not leak to the tools' output.
+	s.redactor.AddSecretEnv([]string{tokenEnv + "=" + token})
+	if !isLoopback(addr) {
+		fmt.Printf("Warning: Serving compell's tools on %s, which other machines may reach; anyone with the token can use them.\n", addr)
+	}
+	httpServer := &http.Server{Addr: addr, Handler: s.httpHandler(addr, token)}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+	fmt.Printf("INFO: Serving MCP over Streamable HTTP at http://%s\n", addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.Wrapf(err, "failed to serve MCP on %s", addr)
+	}
+	return nil
+}
+
+// httpHandler serves the Streamable HTTP transport to requests carrying the
+// bearer token. Against DNS rebinding, requests whose Host or Origin is
+// neither a loopback name nor the host of addr are refused; when addr
+// listens on all interfaces, IP addresses are accepted too, since rebinding
+// needs a domain name.
+func (s *Server) httpHandler(addr, token string) http.Handler {
+	handler := mcpsdk.NewStreamableHTTPHandler(func(*http.Request) *mcpsdk.Server { return s.server }, nil)
+	listenHost, _, _ := net.SplitHostPort(addr)
+	allowedHost := func(hostport string) bool {
+		host := hostport
+		if h, _, err := net.SplitHostPort(hostport); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		ip := net.ParseIP(host)
+		switch {
+		case strings.EqualFold(host, "localhost"), ip != nil && ip.IsLoopback(), strings.EqualFold(host, listenHost):
+			return true
+		case ip != nil:
+			unspecified := net.ParseIP(listenHost)
+			return listenHost == "" || unspecified != nil && unspecified.IsUnspecified()
+		}
+		return false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host) {
+			http.Error(w, "Forbidden host", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !allowedHost(u.Host) {
+				http.Error(w, "Forbidden origin", http.StatusForbidden)
+				return
+			}
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// generateToken returns a random bearer token.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrapf(err, "failed to generate a token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isLoopback reports whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
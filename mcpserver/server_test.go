@@ -0,0 +1,205 @@
+package mcpserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestServer(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.WriteFile("notes.txt", []byte("token sk-test-0123456789"), 0o644)
+	os.WriteFile("secret.env", []byte("API_KEY=1"), 0o644)
+
+	cfg := &config.Config{
+		FilesystemAccess: config.FilesystemAccess{Hidden: []string{"*.env"}},
+		Redaction:        config.Redaction{Patterns: []string{`sk-test-\d+`}},
+		ApprovalRules: []config.PolicyRule{
+			{Tool: "write_file", Action: config.PolicyDeny},
+			{Tool: "git_commit", Action: config.PolicyAsk},
+		},
+	}
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if err := server.ServeTools(""); err != nil {
+		t.Fatal(err)
+	}
+
+	clientTransport, serverTransport := mcpsdk.NewInMemoryTransports()
+	if _, err := server.MCPServer().Connect(context.Background(), serverTransport); err != nil {
+		t.Fatal(err)
+	}
+	client := mcpsdk.NewClient(&mcpsdk.Implementation{Name: "test", Version: "v1.0.0"}, nil)
+	conn, err := client.Connect(context.Background(), clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	served := make(map[string]*mcpsdk.Tool)
+	for tool, err := range conn.Tools(context.Background(), nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		served[tool.Name] = tool
+	}
+	if served["read_file"] == nil || served["execute_command"] == nil || served["todo_write"] != nil {
+		t.Fatalf("served tools = %v", served)
+	}
+	// The tools describe their arguments.
+	for name, want := range map[string]map[string]string{
+		"read_file":       {"path": "string"},
+		"execute_command": {"command": "string", "working_dir": "string", "timeout_seconds": "number"},
+	} {
+		schema := served[name].InputSchema
+		if schema.Type != "object" || len(schema.Properties) != len(want) {
+			t.Errorf("%s schema = %+v", name, schema)
+			continue
+		}
+		for prop, typ := range want {
+			if p := schema.Properties[prop]; p == nil || p.Type != typ || p.Description == "" {
+				t.Errorf("%s property %s = %+v, want type %s", name, prop, p, typ)
+			}
+		}
+	}
+	if got := served["execute_command"].InputSchema.Required; !reflect.DeepEqual(got, []string{"command"}) {
+		t.Errorf("execute_command requires %v", got)
+	}
+
+	call := func(name string, args map[string]any) (string, bool) {
+		t.Helper()
+		result, err := conn.CallTool(context.Background(), &mcpsdk.CallToolParams{Name: name, Arguments: args})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text []string
+		for _, c := range result.Content {
+			text = append(text, c.(*mcpsdk.TextContent).Text)
+		}
+		return strings.Join(text, "\n"), result.IsError
+	}
+
+	for _, tc := range []struct {
+		tool    string
+		args    map[string]any
+		want    string
+		isError bool
+	}{
+		{"read_file", map[string]any{"path": "notes.txt"}, "token [REDACTED:", false},
+		{"read_file", map[string]any{"path": "secret.env"}, "is hidden", true},
+		{"write_file", map[string]any{"path": "new.txt", "content": "x"}, "denied by policy", true},
+		{"git_commit", map[string]any{"message": "x"}, "needs the user's approval", true},
+	} {
+		text, isError := call(tc.tool, tc.args)
+		if !strings.Contains(text, tc.want) || isError != tc.isError {
+			t.Errorf("%s(%v) = %q, error %v; want %q, error %v", tc.tool, tc.args, text, isError, tc.want, tc.isError)
+		}
+	}
+	if _, err := os.Stat("new.txt"); err == nil {
+		t.Error("a denied write_file call wrote the file")
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:8765": true,
+		"127.0.0.1:8765": true,
+		"[::1]:8765":     true,
+		":8765":          false,
+		"0.0.0.0:8765":   false,
+		"10.0.0.2:8765":  false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	server, err := New(&config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		addr, host, origin, auth string
+		want                     int
+	}{
+		{"localhost:8765", "localhost:8765", "", "Bearer secret", 0},
+		{"localhost:8765", "127.0.0.1:8765", "http://localhost:3000", "Bearer secret", 0},
+		{"localhost:8765", "localhost:8765", "", "", http.StatusUnauthorized},
+		{"localhost:8765", "localhost:8765", "", "Bearer wrong", http.StatusUnauthorized},
+		// DNS rebinding: a page of evil.example resolving to 127.0.0.1.
+		{"localhost:8765", "evil.example:8765", "", "Bearer secret", http.StatusForbidden},
+		{"localhost:8765", "localhost:8765", "http://evil.example", "Bearer secret", http.StatusForbidden},
+		{"dev.example:8765", "dev.example:8765", "", "Bearer secret", 0},
+		{"0.0.0.0:8765", "10.0.0.2:8765", "", "Bearer secret", 0},
+		{"0.0.0.0:8765", "evil.example:8765", "", "Bearer secret", http.StatusForbidden},
+		{"10.0.0.2:8765", "10.0.0.3:8765", "", "Bearer secret", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+		req.Host = tt.host
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		server.httpHandler(tt.addr, "secret").ServeHTTP(rec, req)
+		if tt.want == 0 && (rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden) {
+			t.Errorf("%s: request to %s from %q with %q refused: %d", tt.addr, tt.host, tt.origin, tt.auth, rec.Code)
+		} else if tt.want != 0 && rec.Code != tt.want {
+			t.Errorf("%s: request to %s from %q with %q = %d, want %d", tt.addr, tt.host, tt.origin, tt.auth, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	t.Chdir(t.TempDir())
+	stdin, stdout := os.Stdin, os.Stdout
+	t.Cleanup(func() { os.Stdin, os.Stdout = stdin, stdout })
+	inR, inW, _ := os.Pipe()
+	outR, outW, _ := os.Pipe()
+	os.Stdin, os.Stdout = inR, outW
+
+	// Loading the broken MCP server prints a warning while the tools are set up.
+	cfg := &config.Config{
+		AdditionalMCPServers: []config.MCPServer{{Name: "broken", Command: "/nonexistent/server"}},
+		Toolsets:             []config.Toolset{{Name: "mcp", Tools: []string{"read_file", "broken.*"}}},
+	}
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.RedirectStdout()
+	if err := server.ServeTools("mcp"); err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- server.ServeStdio(context.Background()) }()
+	io.WriteString(inW, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"v1.0.0"}}}`+"\n")
+	inW.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("ServeStdio: %v", err)
+	}
+	outW.Close()
+
+	out, _ := io.ReadAll(outR)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], `{"jsonrpc":"2.0","id":1,"result":`) {
+		t.Errorf("stdout = %q, want only the initialize response", out)
+	}
+}
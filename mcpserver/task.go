@@ -0,0 +1,239 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/m4xw311/compell/agent"
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/llm"
+	"github.com/m4xw311/compell/session"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// runTaskToolName is the name of the tool that runs a task in the agent.
+const runTaskToolName = "run_task"
+
+// AgentOptions configure the run_task tool.
+type AgentOptions struct {
+	Client llm.LLMClient
+	// Toolset is used for tasks that do not name one. Defaults to the
+	// "default" toolset.
+	Toolset string
+	// Mode is used for tasks that do not name one. Defaults to auto.
+	Mode agent.Mode
+}
+
+// validSessionName matches the session names a client may choose; they are
+// used in file paths.
+var validSessionName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ServeAgent offers the run_task tool, which runs a task in a headless agent
+// and returns its answer and the files it changed. Every task is a single
+// turn in a fresh session, or in a named one to continue earlier tasks. The
+// agent's tool calls are approved as in Server: calls that need the user's
+// approval are refused, and in prompt mode only the calls allowed by a rule
+// run. Progress notifications are sent for every tool the agent calls if the
+// client asked for them.
+func (s *Server) ServeAgent(opts AgentOptions) {
+	if opts.Mode == "" {
+		opts.Mode = agent.ModeAuto
+	}
+	limit := func(typ, description string) *jsonschema.Schema {
+		return &jsonschema.Schema{Type: typ, Description: description}
+	}
+	s.server.AddTool(&mcpsdk.Tool{
+		Name: runTaskToolName,
+		Description: "Runs a task in compell, a coding agent working in its own workspace, and returns its final answer " +
+			"and the files it changed. The agent cannot see your conversation, so describe the task completely.",
+		InputSchema: &jsonschema.Schema{
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"prompt":  {Type: "string", Description: "The task."},
+				"toolset": {Type: "string", Description: "The toolset of the agent."},
+				"mode":    {Type: "string", Enum: []any{string(agent.ModeAuto), string(agent.ModePrompt)}, Description: "In prompt mode, only tool calls allowed by an approval rule run."},
+				"session": {Type: "string", Description: "A session to run the task in, created if it does not exist. Defaults to a fresh session."},
+				"budget": {
+					Type:        "object",
+					Description: "Limits of the task. They can only tighten the configured limits.",
+					Properties: map[string]*jsonschema.Schema{
+						"max_llm_calls":  limit("integer", "Maximum number of LLM requests."),
+						"max_tool_calls": limit("integer", "Maximum number of tool calls."),
+						"max_tokens":     limit("integer", "Maximum input plus output tokens."),
+						"max_cost":       limit("number", "Maximum estimated cost."),
+						"max_duration":   limit("string", "Wall-clock limit, e.g. \"10m\"."),
+					},
+				},
+			},
+			Required: []string{"prompt"},
+		},
+	}, func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+		report, err := s.runTask(ctx, ss, params, opts)
+		if err != nil {
+			return toCallToolResult(session.ErrorResult(fmt.Sprintf("Error executing tool %s: %v", runTaskToolName, err))), nil
+		}
+		return toCallToolResult(session.TextResult(s.redactor.Redact(describeReport(report)))), nil
+	})
+}
+
+func (s *Server) runTask(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any], opts AgentOptions) (*agent.TaskReport, error) {
+	args := params.Arguments
+	prompt, ok := args["prompt"].(string)
+	if !ok || strings.TrimSpace(prompt) == "" {
+		return nil, errors.New("missing or invalid 'prompt' argument")
+	}
+	toolset := opts.Toolset
+	if name, ok := args["toolset"].(string); ok && name != "" {
+		toolset = name
+	}
+	mode := opts.Mode
+	if name, ok := args["mode"].(string); ok && name != "" {
+		mode = agent.Mode(name)
+		if mode != agent.ModeAuto && mode != agent.ModePrompt {
+			return nil, errors.New("invalid mode '%s': must be 'auto' or 'prompt'", name)
+		}
+	}
+	cfg := *s.cfg
+	if budget, ok := args["budget"]; ok {
+		fields, ok := budget.(map[string]any)
+		if !ok {
+			return nil, errors.New("invalid 'budget' argument: must be an object")
+		}
+		limits, err := applyBudget(cfg.Limits, fields)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Limits = limits
+	}
+
+	name, _ := args["session"].(string)
+	sess, release, err := s.taskSession(name)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	sess.Mode, sess.Toolset, sess.ToolVerbosity = string(mode), toolset, string(agent.ToolVerbosityNone)
+
+	a, err := agent.New(&cfg, sess, toolset, mode, opts.Client, agent.ToolVerbosityNone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize the agent")
+	}
+	defer a.Close()
+	a.Headless = true
+	if token := params.GetProgressToken(); token != nil {
+		var progress float64
+		a.Progress = func(message string) {
+			progress++
+			ss.NotifyProgress(ctx, &mcpsdk.ProgressNotificationParams{ProgressToken: token, Progress: progress, Message: message})
+		}
+	}
+	fmt.Printf("INFO: Running task in session %s...\n", sess.Name)
+	return a.RunTask(ctx, prompt)
+}
+
+// taskSession loads or creates the named session, or creates a fresh one if
+// name is empty, and marks it busy until release is called. Tasks cannot run
+// in the same session at the same time.
+func (s *Server) taskSession(name string) (sess *session.Session, release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "" {
+		s.nextTask++
+		name = fmt.Sprintf("mcp-task_%s_%d", time.Now().Format("2006-01-02_15-04-05"), s.nextTask)
+	} else if !validSessionName.MatchString(name) {
+		return nil, nil, errors.New("invalid session name '%s': use letters, digits, '.', '_' and '-'", name)
+	}
+	if s.busy[name] {
+		return nil, nil, errors.New("session '%s' is running another task", name)
+	}
+
+	sess, err = session.Load(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		sess, err = session.New(name)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to open session '%s'", name)
+	}
+	s.busy[name] = true
+	return sess, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.busy, name)
+	}, nil
+}
+
+// applyBudget tightens limits by the budget of a task. A budget cannot raise
+// a configured limit.
+func applyBudget(limits config.Limits, budget map[string]any) (config.Limits, error) {
+	for name := range budget {
+		switch name {
+		case "max_llm_calls", "max_tool_calls", "max_tokens", "max_cost", "max_duration":
+		default:
+			return limits, errors.New("unknown budget '%s'", name)
+		}
+	}
+	number := func(name string) (float64, bool, error) {
+		value, ok := budget[name]
+		if !ok {
+			return 0, false, nil
+		}
+		n, ok := value.(float64)
+		if !ok || n <= 0 {
+			return 0, false, errors.New("invalid budget '%s': must be a positive number", name)
+		}
+		return n, true, nil
+	}
+	for name, limit := range map[string]*int{
+		"max_llm_calls":  &limits.MaxLLMCalls,
+		"max_tool_calls": &limits.MaxToolCalls,
+		"max_tokens":     &limits.MaxTokens,
+	} {
+		n, ok, err := number(name)
+		if err != nil {
+			return limits, err
+		}
+		if ok && (*limit == 0 || int(n) < *limit) {
+			*limit = max(int(n), 1)
+		}
+	}
+	if n, ok, err := number("max_cost"); err != nil {
+		return limits, err
+	} else if ok && (limits.MaxCost == 0 || n < limits.MaxCost) {
+		limits.MaxCost = n
+	}
+	if value, ok := budget["max_duration"]; ok {
+		text, _ := value.(string)
+		d, err := time.ParseDuration(text)
+		if err != nil || d <= 0 {
+			return limits, errors.New("invalid budget 'max_duration': must be a positive duration such as \"10m\"")
+		}
+		if limits.MaxDuration == 0 || d < limits.MaxDuration {
+			limits.MaxDuration = d
+		}
+	}
+	return limits, nil
+}
+
+// describeReport formats the outcome of a task for the client.
+func describeReport(report *agent.TaskReport) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session: %s\n", report.Session)
+	if report.StopReason != "" {
+		fmt.Fprintf(&b, "Stopped early: %s\n", report.StopReason)
+	}
+	b.WriteString("\nFile changes:\n")
+	if len(report.Changes) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, change := range report.Changes {
+		fmt.Fprintf(&b, "- %s\n", change)
+	}
+	fmt.Fprintf(&b, "\nAnswer:\n%s", report.Answer)
+	return b.String()
+}
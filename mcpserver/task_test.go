@@ -0,0 +1,133 @@
+package mcpserver
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/tools"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// writingLLMClient writes notes.txt, then answers.
+type writingLLMClient struct{}
+
+func (writingLLMClient) Chat(ctx context.Context, messages []session.Message, availableTools []tools.Tool) (*session.Message, error) {
+	if messages[len(messages)-1].Role == "tool" {
+		return &session.Message{Role: "assistant", Content: "Wrote the notes."}, nil
+	}
+	return &session.Message{Role: "assistant", ToolCalls: []session.ToolCall{{
+		ToolCallID: "call_1",
+		Name:       "write_file",
+		Args:       map[string]interface{}{"path": "notes.txt", "content": "notes"},
+	}}}, nil
+}
+
+func TestRunTask(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cfg := &config.Config{Toolsets: []config.Toolset{{Name: "default", Tools: []string{"write_file"}}}}
+	server, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.ServeAgent(AgentOptions{Client: writingLLMClient{}})
+
+	var mu sync.Mutex
+	var progress []string
+	clientTransport, serverTransport := mcpsdk.NewInMemoryTransports()
+	if _, err := server.MCPServer().Connect(context.Background(), serverTransport); err != nil {
+		t.Fatal(err)
+	}
+	client := mcpsdk.NewClient(&mcpsdk.Implementation{Name: "test", Version: "v1.0.0"}, &mcpsdk.ClientOptions{
+		ProgressNotificationHandler: func(ctx context.Context, cs *mcpsdk.ClientSession, params *mcpsdk.ProgressNotificationParams) {
+			mu.Lock()
+			defer mu.Unlock()
+			progress = append(progress, params.Message)
+		},
+	})
+	conn, err := client.Connect(context.Background(), clientTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	call := func(args map[string]any) (string, bool, error) {
+		params := &mcpsdk.CallToolParams{Meta: mcpsdk.Meta{}, Name: "run_task", Arguments: args}
+		params.SetProgressToken("task")
+		result, err := conn.CallTool(context.Background(), params)
+		if err != nil {
+			return "", false, err
+		}
+		return result.Content[0].(*mcpsdk.TextContent).Text, result.IsError, nil
+	}
+
+	text, isError, err := call(map[string]any{"prompt": "write the notes", "session": "notes", "budget": map[string]any{"max_tool_calls": 3}})
+	want := "Session: notes\n\nFile changes:\n- created notes.txt\n\nAnswer:\nWrote the notes."
+	if err != nil || isError || text != want {
+		t.Errorf("run_task = %q, %v; want %q", text, err, want)
+	}
+	if data, err := os.ReadFile("notes.txt"); err != nil || string(data) != "notes" {
+		t.Errorf("notes.txt = %q, %v", data, err)
+	}
+	sess, err := session.Load("notes")
+	if err != nil || len(sess.Messages) != 4 {
+		t.Errorf("session not saved: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(progress)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	mu.Lock()
+	if len(progress) != 1 || progress[0] != "Calling tool `write_file`" {
+		t.Errorf("progress = %q", progress)
+	}
+	mu.Unlock()
+
+	// Invalid arguments are refused by the input schema or the tool.
+	for _, args := range []map[string]any{
+		{"prompt": ""},
+		{"prompt": "x", "mode": "yolo"},
+		{"prompt": "x", "session": "../escape"},
+		{"prompt": "x", "budget": map[string]any{"max_dollars": 1}},
+	} {
+		if text, isError, err := call(args); err == nil && !isError {
+			t.Errorf("run_task(%v) = %q, want an error", args, text)
+		}
+	}
+}
+
+func TestApplyBudget(t *testing.T) {
+	limits := config.Limits{MaxLLMCalls: 100, MaxDuration: time.Minute}
+	got, err := applyBudget(limits, map[string]any{
+		"max_llm_calls":  float64(10),
+		"max_tool_calls": float64(5),
+		"max_duration":   "1h",
+		"max_cost":       0.5,
+	})
+	want := config.Limits{MaxLLMCalls: 10, MaxToolCalls: 5, MaxDuration: time.Minute, MaxCost: 0.5}
+	if err != nil || got != want {
+		t.Errorf("applyBudget = %+v, %v; want %+v", got, err, want)
+	}
+	// A budget cannot raise a limit.
+	if got, _ := applyBudget(limits, map[string]any{"max_llm_calls": float64(1000)}); got.MaxLLMCalls != 100 {
+		t.Errorf("max_llm_calls raised to %d", got.MaxLLMCalls)
+	}
+	for _, budget := range []map[string]any{
+		{"max_tokens": float64(-1)},
+		{"max_duration": "soon"},
+		{"max_tool_calls": "5"},
+	} {
+		if _, err := applyBudget(limits, budget); err == nil {
+			t.Errorf("applyBudget(%v) succeeded", budget)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sandbox
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// Landlock system calls and flags, see linux/landlock.h.
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	oPath = 0x200000 // O_PATH, missing from package syscall
+)
+
+// Landlock filesystem access rights related to writing.
+const (
+	accessWriteFile  = 1 << 1
+	accessRemoveDir  = 1 << 4
+	accessRemoveFile = 1 << 5
+	accessMakeChar   = 1 << 6
+	accessMakeDir    = 1 << 7
+	accessMakeReg    = 1 << 8
+	accessMakeSock   = 1 << 9
+	accessMakeFifo   = 1 << 10
+	accessMakeBlock  = 1 << 11
+	accessMakeSym    = 1 << 12
+	accessRefer      = 1 << 13 // ABI 2
+	accessTruncate   = 1 << 14 // ABI 3
+
+	// accessFile are the rights that apply to files rather than directories.
+	accessFile = accessWriteFile | accessTruncate
+)
+
+// writableDevices are device files commands may always write to.
+var writableDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty"}
+
+// landlockABI returns the Landlock ABI version supported by the kernel.
+func landlockABI() (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0, errors.Wrapf(errno, "Landlock is not supported by this kernel")
+	}
+	return int(abi), nil
+}
+
+// writeAccess returns the write rights known to the given ABI version.
+func writeAccess(abi int) uint64 {
+	access := uint64(accessWriteFile | accessRemoveDir | accessRemoveFile | accessMakeChar | accessMakeDir |
+		accessMakeReg | accessMakeSock | accessMakeFifo | accessMakeBlock | accessMakeSym)
+	if abi >= 2 {
+		access |= accessRefer
+	}
+	if abi >= 3 {
+		access |= accessTruncate
+	}
+	return access
+}
+
+// restrictWrites uses Landlock to deny the calling thread, and the programs
+// it executes, any write outside the given paths and writableDevices. Reads
+// are not restricted by Landlock; hidden paths are masked with mounts.
+func restrictWrites(paths []string) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+	handled := writeAccess(abi)
+
+	attr := struct{ handledAccessFS uint64 }{handled}
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to create Landlock ruleset")
+	}
+	ruleset := int(fd)
+	defer syscall.Close(ruleset)
+
+	for _, path := range append(paths, writableDevices...) {
+		if err := addPathRule(ruleset, path, handled); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return errors.Wrapf(errno, "failed to set no_new_privs")
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, uintptr(ruleset), 0, 0); errno != 0 {
+		return errors.Wrapf(errno, "failed to enforce Landlock ruleset")
+	}
+	return nil
+}
+
+// addPathRule allows access beneath path. Missing paths are ignored.
+func addPathRule(ruleset int, path string, access uint64) error {
+	fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to open '%s' for the sandbox", path)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return errors.Wrapf(err, "failed to stat '%s'", path)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
+
+	// struct landlock_path_beneath_attr is packed: a u64 followed by an s32.
+	var rule [12]byte
+	*(*uint64)(unsafe.Pointer(&rule[0])) = access
+	*(*int32)(unsafe.Pointer(&rule[8])) = int32(fd)
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule[0])), 0, 0, 0)
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to add Landlock rule for '%s'", path)
+	}
+	return nil
+}
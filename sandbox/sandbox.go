@@ -0,0 +1,192 @@
+// Package sandbox isolates the commands compell runs on behalf of the model.
+// On Linux, commands run in new namespaces without network access (unless
+// allowed), with hidden paths masked, read-only paths mounted read-only,
+// writes outside the workspace denied, resource limits applied and a cleaned
+// environment.
+//
+// Commands are not started directly: Apply rewrites them to run compell
+// itself as a small helper (see RunHelper) that finishes setting up the
+// sandbox from inside and then executes the real command.
+package sandbox
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/secrets"
+)
+
+// HelperArg is the first argument with which compell is re-executed as the
+// sandbox helper.
+const HelperArg = "__compell-sandbox-exec"
+
+// Sandbox backends.
+const (
+	BackendAuto       = "auto"
+	BackendBubblewrap = "bubblewrap" // Uses the bwrap executable
+	BackendLandlock   = "landlock"   // Uses namespaces and Landlock directly
+)
+
+// Sandbox runs commands with the restrictions from the configuration. A nil
+// *Sandbox is valid and means that commands run unrestricted.
+type Sandbox struct {
+	cfg       config.Sandbox
+	fsAccess  config.FilesystemAccess
+	workspace string
+	backend   string
+	bwrap     string // Path of the bwrap executable
+	err       error  // Set for sandboxes that could not be initialized
+}
+
+// spec is what the helper needs to know to finish setting up the sandbox.
+type spec struct {
+	Workspace string               `json:"workspace"`
+	Hidden    []string             `json:"hidden,omitempty"`    // Patterns of paths to mask
+	ReadOnly  []string             `json:"read_only,omitempty"` // Patterns of paths to mount read-only
+	Writable  []string             `json:"writable,omitempty"`  // Paths beneath which writes are allowed
+	Isolate   bool                 `json:"isolate,omitempty"`   // Apply mounts and Landlock rules in the helper
+	Limits    config.SandboxLimits `json:"limits"`
+}
+
+// New creates the sandbox described by cfg for the current working directory.
+// It returns nil if the sandbox is disabled, and an error if it is enabled
+// but cannot be used on this system.
+func New(cfg *config.Sandbox, fsAccess *config.FilesystemAccess) (*Sandbox, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	workspace, err := os.Getwd()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get working directory")
+	}
+	for _, path := range cfg.Writable {
+		if !filepath.IsAbs(path) {
+			return nil, errors.New("sandbox writable path '%s' must be absolute", path)
+		}
+	}
+
+	s := &Sandbox{cfg: *cfg, fsAccess: *fsAccess, workspace: workspace}
+	if err := s.selectBackend(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Unavailable returns a sandbox that refuses to run any command, reporting
+// err. It is used when the sandbox is enabled but could not be set up, so
+// that commands never silently run unrestricted.
+func Unavailable(err error) *Sandbox {
+	return &Sandbox{err: err}
+}
+
+// Backend returns the name of the backend in use.
+func (s *Sandbox) Backend() string {
+	if s == nil {
+		return ""
+	}
+	return s.backend
+}
+
+// Environ returns the environment for commands: the whole environment of
+// compell without a sandbox, and only well-known and configured variables
+// with one.
+func (s *Sandbox) Environ() []string {
+	if s == nil {
+		return os.Environ()
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if secrets.IsBaseEnv(name) || slices.Contains(s.cfg.Env, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// Apply rewrites cmd, which must not have been started, to run inside the
+// sandbox. It must be called after the command's path, arguments, directory
+// and process attributes are set up.
+func (s *Sandbox) Apply(cmd *exec.Cmd) error {
+	if s == nil {
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if cmd.Err != nil {
+		// Let Start report that the command could not be found.
+		return nil
+	}
+	return s.apply(cmd)
+}
+
+// IsHelper reports whether the current process was started as the sandbox helper.
+func IsHelper() bool {
+	return len(os.Args) > 1 && os.Args[1] == HelperArg
+}
+
+// restrictedPaths returns the existing paths in workspace that match the
+// hidden and read-only patterns. Nothing below a hidden path or a read-only
+// directory is reported, except hidden paths inside read-only directories.
+func restrictedPaths(workspace string, hidden, readOnly []string) (hiddenPaths, readOnlyPaths []string, err error) {
+	readOnlyDir := ""
+	err = filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries we cannot read cannot be reached by commands either.
+			return nil
+		}
+		rel, err := filepath.Rel(workspace, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+
+		match, err := matchAny(hidden, rel)
+		if err != nil {
+			return err
+		}
+		if match {
+			hiddenPaths = append(hiddenPaths, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if readOnlyDir != "" && strings.HasPrefix(path, readOnlyDir+string(filepath.Separator)) {
+			return nil
+		}
+		match, err = matchAny(readOnly, rel)
+		if err != nil {
+			return err
+		}
+		if match {
+			readOnlyPaths = append(readOnlyPaths, path)
+			if d.IsDir() {
+				readOnlyDir = path
+			}
+		}
+		return nil
+	})
+	return hiddenPaths, readOnlyPaths, err
+}
+
+func matchAny(patterns []string, path string) (bool, error) {
+	for _, pattern := range patterns {
+		match, err := doublestar.PathMatch(pattern, path)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid glob pattern '%s'", pattern)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,334 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+)
+
+const (
+	rlimitNproc = 6 // RLIMIT_NPROC, missing from package syscall
+
+	prSetNoNewPrivs = 38 // PR_SET_NO_NEW_PRIVS
+	prCapbsetDrop   = 24 // PR_CAPBSET_DROP
+)
+
+func (s *Sandbox) selectBackend() error {
+	switch s.cfg.Backend {
+	case "", BackendAuto:
+		if path, err := exec.LookPath("bwrap"); err == nil {
+			s.backend, s.bwrap = BackendBubblewrap, path
+			return nil
+		}
+		if _, err := landlockABI(); err == nil {
+			s.backend = BackendLandlock
+			return nil
+		}
+		return errors.New("no sandbox backend available: install bubblewrap or use a kernel with Landlock enabled")
+	case BackendBubblewrap:
+		path, err := exec.LookPath("bwrap")
+		if err != nil {
+			return errors.Wrapf(err, "the bubblewrap sandbox backend requires the 'bwrap' executable")
+		}
+		s.backend, s.bwrap = BackendBubblewrap, path
+	case BackendLandlock:
+		if _, err := landlockABI(); err != nil {
+			return errors.Wrapf(err, "the landlock sandbox backend is not available")
+		}
+		s.backend = BackendLandlock
+	default:
+		return errors.New("unknown sandbox backend '%s'", s.cfg.Backend)
+	}
+	return nil
+}
+
+func (s *Sandbox) apply(cmd *exec.Cmd) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrapf(err, "could not find the compell executable for the sandbox helper")
+	}
+	dir, err := filepath.Abs(cmd.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "invalid command directory '%s'", cmd.Dir)
+	}
+
+	sp := spec{Workspace: s.workspace, Limits: s.cfg.Limits}
+	targetPath, targetArgs := cmd.Path, cmd.Args
+	switch s.backend {
+	case BackendBubblewrap:
+		hidden, readOnly, err := restrictedPaths(s.workspace, s.fsAccess.Hidden, s.fsAccess.ReadOnly)
+		if err != nil {
+			return err
+		}
+		args := append([]string{"bwrap"}, s.bwrapArgs(dir, hidden, readOnly)...)
+		args = append(args, "--", cmd.Path)
+		targetPath, targetArgs = s.bwrap, append(args, cmd.Args[1:]...)
+	case BackendLandlock:
+		sp.Isolate = true
+		sp.Hidden, sp.ReadOnly = s.fsAccess.Hidden, s.fsAccess.ReadOnly
+		sp.Writable = append([]string{s.workspace}, s.cfg.Writable...)
+		s.setNamespaces(cmd)
+	}
+
+	data, err := json.Marshal(sp)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode sandbox settings")
+	}
+	cmd.Path = exe
+	cmd.Args = append([]string{exe, HelperArg, string(data), targetPath, "--"}, targetArgs...)
+	return nil
+}
+
+// bwrapArgs returns the bubblewrap options for running a command in dir.
+func (s *Sandbox) bwrapArgs(dir string, hidden, readOnly []string) []string {
+	args := []string{"--die-with-parent", "--unshare-user", "--unshare-ipc", "--unshare-uts", "--unshare-pid", "--unshare-cgroup-try"}
+	if !s.cfg.Network {
+		args = append(args, "--unshare-net")
+	}
+	args = append(args, "--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp")
+	for _, path := range append([]string{s.workspace}, s.cfg.Writable...) {
+		args = append(args, "--bind-try", path, path)
+	}
+	for _, path := range readOnly {
+		args = append(args, "--ro-bind", path, path)
+	}
+	for _, path := range hidden {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			args = append(args, "--tmpfs", path, "--remount-ro", path)
+		} else {
+			args = append(args, "--ro-bind", "/dev/null", path)
+		}
+	}
+	return append(args, "--chdir", dir)
+}
+
+// setNamespaces makes the helper start in new user, mount, IPC and UTS
+// namespaces, and a new network namespace unless networking is allowed. The
+// helper is root inside the user namespace so that it can set up mounts.
+func (s *Sandbox) setNamespaces(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if !s.cfg.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+}
+
+// RunHelper finishes setting up the sandbox and executes the command given
+// in the arguments. It never returns.
+func RunHelper() {
+	// Landlock and the capability changes apply to the calling thread, which
+	// must therefore be the one that executes the command.
+	runtime.LockOSThread()
+	err := runHelper(os.Args[2:])
+	fmt.Fprintf(os.Stderr, "compell sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+func runHelper(args []string) error {
+	if len(args) < 4 || args[2] != "--" {
+		return errors.New("invalid sandbox helper arguments")
+	}
+	var sp spec
+	if err := json.Unmarshal([]byte(args[0]), &sp); err != nil {
+		return errors.Wrapf(err, "invalid sandbox settings")
+	}
+	path, argv := args[1], args[3:]
+
+	if sp.Isolate {
+		if err := isolateFilesystem(sp); err != nil {
+			return err
+		}
+		if err := restrictWrites(append(sp.Writable, "/tmp")); err != nil {
+			return err
+		}
+		if err := dropCapabilities(); err != nil {
+			return err
+		}
+	}
+	if err := setRlimits(sp.Limits); err != nil {
+		return err
+	}
+	if err := syscall.Exec(path, argv, os.Environ()); err != nil {
+		return errors.Wrapf(err, "failed to execute '%s'", path)
+	}
+	return nil
+}
+
+// isolateFilesystem gives the helper a private /tmp, masks hidden paths and
+// makes read-only paths read-only in its private mount namespace.
+func isolateFilesystem(sp spec) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the working directory")
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.Wrapf(err, "failed to make mounts private")
+	}
+	if err := privateTmp(sp.Writable); err != nil {
+		return err
+	}
+	hidden, readOnly, err := restrictedPaths(sp.Workspace, sp.Hidden, sp.ReadOnly)
+	if err != nil {
+		return err
+	}
+	for _, path := range readOnly {
+		if err := bindReadOnly(path, path); err != nil {
+			return err
+		}
+	}
+	for _, path := range hidden {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			err = syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0555")
+			if err != nil {
+				return errors.Wrapf(err, "failed to hide '%s'", path)
+			}
+			continue
+		}
+		if err := bindReadOnly("/dev/null", path); err != nil {
+			return err
+		}
+	}
+	// Enter the working directory again, so that relative paths go through
+	// the new mounts rather than the directory they cover.
+	if err := os.Chdir(dir); err != nil {
+		return errors.Wrapf(err, "failed to enter '%s'", dir)
+	}
+	return nil
+}
+
+// privateTmp mounts an empty tmpfs on /tmp, as bubblewrap's --tmpfs /tmp
+// does, so that commands have scratch space that is discarded when they exit.
+// Writable paths beneath /tmp, such as a workspace in a temporary directory,
+// are bound back onto it.
+func privateTmp(writable []string) error {
+	var kept []string
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	for _, path := range writable {
+		if path != "/tmp" && !strings.HasPrefix(path, "/tmp/") {
+			continue
+		}
+		fd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.Wrapf(err, "failed to open '%s' for the sandbox", path)
+		}
+		kept, fds = append(kept, path), append(fds, fd)
+	}
+
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return errors.Wrapf(err, "failed to mount a private /tmp")
+	}
+	for i, path := range kept {
+		var st syscall.Stat_t
+		err := syscall.Fstat(fds[i], &st)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat '%s'", path)
+		}
+		if st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+			err = os.MkdirAll(path, 0o755)
+		} else if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+			err = os.WriteFile(path, nil, 0o644)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to create '%s' in the private /tmp", path)
+		}
+		if err := syscall.Mount(fmt.Sprintf("/proc/self/fd/%d", fds[i]), path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return errors.Wrapf(err, "failed to bind-mount '%s'", path)
+		}
+	}
+	return nil
+}
+
+// bindReadOnly bind-mounts src onto dst read-only, keeping the flags of the
+// underlying mount that an unprivileged user namespace may not clear.
+func bindReadOnly(src, dst string) error {
+	if err := syscall.Mount(src, dst, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return errors.Wrapf(err, "failed to bind-mount '%s'", dst)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dst, &st); err != nil {
+		return errors.Wrapf(err, "failed to stat '%s'", dst)
+	}
+	const kept = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+	flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | uintptr(st.Flags)&kept
+	if err := syscall.Mount("", dst, "", flags, ""); err != nil {
+		return errors.Wrapf(err, "failed to make '%s' read-only", dst)
+	}
+	return nil
+}
+
+// dropCapabilities removes every capability from the bounding set and the
+// current sets, so that the command, although root in the user namespace,
+// cannot undo the sandbox.
+func dropCapabilities() error {
+	for capability := uintptr(0); ; capability++ {
+		_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapbsetDrop, capability, 0, 0, 0, 0)
+		if errno == syscall.EINVAL {
+			break // No more capabilities
+		}
+		if errno != 0 {
+			return errors.Wrapf(errno, "failed to drop capability %d", capability)
+		}
+	}
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: 0x20080522} // _LINUX_CAPABILITY_VERSION_3
+	var data [2]struct{ effective, permitted, inheritable uint32 }
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if errno != 0 {
+		return errors.Wrapf(errno, "failed to clear capabilities")
+	}
+	return nil
+}
+
+// setRlimits applies the configured resource limits, never raising a limit.
+func setRlimits(limits config.SandboxLimits) error {
+	const mb = 1024 * 1024
+	for _, l := range []struct {
+		resource int
+		value    uint64
+		name     string
+	}{
+		{syscall.RLIMIT_CPU, limits.CPUSeconds, "cpu_seconds"},
+		{syscall.RLIMIT_AS, limits.MemoryMB * mb, "memory_mb"},
+		{rlimitNproc, limits.MaxProcesses, "max_processes"},
+		{syscall.RLIMIT_FSIZE, limits.MaxFileSizeMB * mb, "max_file_size_mb"},
+		{syscall.RLIMIT_NOFILE, limits.MaxOpenFiles, "max_open_files"},
+	} {
+		if l.value == 0 {
+			continue
+		}
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(l.resource, &current); err != nil {
+			return errors.Wrapf(err, "failed to read limit %s", l.name)
+		}
+		value := min(l.value, current.Max)
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+			return errors.Wrapf(err, "failed to set limit %s", l.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+)
+
+func TestLandlockSandbox(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("Landlock unavailable: %v", err)
+	}
+	if err := exec.Command("unshare", "-Ur", "true").Run(); err != nil {
+		t.Skipf("user namespaces unavailable: %v", err)
+	}
+
+	workspace := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "secret.txt"), []byte("s3cret"), 0644)
+	os.WriteFile(filepath.Join(workspace, "locked.txt"), []byte("locked"), 0644)
+	os.Mkdir(filepath.Join(workspace, "private"), 0755)
+	os.WriteFile(filepath.Join(workspace, "private", "key"), []byte("k"), 0644)
+
+	wd, _ := os.Getwd()
+	os.Chdir(workspace)
+	defer os.Chdir(wd)
+	s, err := New(&config.Sandbox{
+		Enabled: true,
+		Backend: BackendLandlock,
+		Limits:  config.SandboxLimits{MaxOpenFiles: 64},
+	}, &config.FilesystemAccess{Hidden: []string{"secret.txt", "private"}, ReadOnly: []string{"locked.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(script string) (string, error) {
+		cmd := exec.Command("/bin/sh", "-c", script)
+		cmd.Dir = workspace
+		cmd.Env = s.Environ()
+		if err := s.Apply(cmd); err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	if out, err := run("cat secret.txt; ls private"); err != nil || out != "" {
+		t.Errorf("hidden paths readable: %q, %v", out, err)
+	}
+	if out, err := run("cat locked.txt"); err != nil || out != "locked" {
+		t.Errorf("read-only file not readable: %q, %v", out, err)
+	}
+	if _, err := run("echo x >> locked.txt"); err == nil {
+		t.Error("write to read-only file succeeded")
+	}
+	if _, err := run("echo x > " + filepath.Join(outside, "f")); err == nil {
+		t.Error("write outside the workspace succeeded")
+	}
+	if out, err := run("echo created > new.txt && mkdir -p sub && echo x > sub/f && rm sub/f && cat new.txt"); err != nil || out != "created" {
+		t.Errorf("write inside the workspace failed: %q, %v", out, err)
+	}
+	if out, err := run("ulimit -n"); err != nil || out != "64" {
+		t.Errorf("open files limit = %q, %v; want 64", out, err)
+	}
+	if out, err := run("cat /proc/net/dev"); err != nil || strings.Count(out, ":") != 1 || !strings.Contains(out, "lo:") {
+		t.Errorf("expected only the loopback interface, got %q, %v", out, err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(workspace, "locked.txt")); string(data) != "locked" {
+		t.Errorf("read-only file modified: %q", data)
+	}
+}
+
+// TestPrivateTmp checks that both backends give commands a writable /tmp of
+// their own, which leaves the real /tmp untouched.
+func TestPrivateTmp(t *testing.T) {
+	for _, backend := range []string{BackendBubblewrap, BackendLandlock} {
+		t.Run(backend, func(t *testing.T) {
+			if err := exec.Command("unshare", "-Ur", "true").Run(); err != nil {
+				t.Skipf("user namespaces unavailable: %v", err)
+			}
+			workspace := t.TempDir()
+			t.Chdir(workspace)
+			s, err := New(&config.Sandbox{Enabled: true, Backend: backend}, &config.FilesystemAccess{})
+			if err != nil {
+				t.Skipf("%s unavailable: %v", backend, err)
+			}
+
+			scratch := filepath.Join("/tmp", filepath.Base(workspace)+".scratch")
+			cmd := exec.Command("/bin/sh", "-c", "echo x > "+scratch+" && cat "+scratch+" && echo y > out.txt")
+			cmd.Dir = workspace
+			cmd.Env = s.Environ()
+			if err := s.Apply(cmd); err != nil {
+				t.Fatal(err)
+			}
+			if out, err := cmd.CombinedOutput(); err != nil || strings.TrimSpace(string(out)) != "x" {
+				t.Errorf("write to /tmp failed: %q, %v", out, err)
+			}
+			if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+				os.Remove(scratch)
+				t.Errorf("write to /tmp reached the real /tmp: %v", err)
+			}
+			// The workspace, itself beneath /tmp here, stays writable.
+			if data, err := os.ReadFile(filepath.Join(workspace, "out.txt")); err != nil || string(data) != "y\n" {
+				t.Errorf("write to the workspace failed: %q, %v", data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+func (s *Sandbox) selectBackend() error {
+	return errors.New("the command sandbox is only supported on Linux")
+}
+
+func (s *Sandbox) apply(cmd *exec.Cmd) error {
+	return errors.New("the command sandbox is only supported on Linux")
+}
+
+// RunHelper is only supported on Linux.
+func RunHelper() {
+	fmt.Fprintln(os.Stderr, "compell sandbox: not supported on this platform")
+	os.Exit(126)
+}
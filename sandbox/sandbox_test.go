@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+)
+
+func TestMain(m *testing.M) {
+	// Sandboxed commands re-execute the test binary as the helper.
+	if IsHelper() {
+		RunHelper()
+	}
+	os.Exit(m.Run())
+}
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("COMPELL_TEST_SECRET", "s3cret")
+	t.Setenv("COMPELL_TEST_ALLOWED", "yes")
+	t.Setenv("LC_ALL", "C")
+
+	var disabled *Sandbox
+	if !contains(disabled.Environ(), "COMPELL_TEST_SECRET=s3cret") {
+		t.Error("a disabled sandbox must pass the whole environment")
+	}
+
+	s := &Sandbox{cfg: config.Sandbox{Env: []string{"COMPELL_TEST_ALLOWED"}}}
+	env := s.Environ()
+	if contains(env, "COMPELL_TEST_SECRET=s3cret") {
+		t.Error("unlisted variables must not be passed to sandboxed commands")
+	}
+	for _, kv := range []string{"COMPELL_TEST_ALLOWED=yes", "LC_ALL=C", "PATH=" + os.Getenv("PATH")} {
+		if !contains(env, kv) {
+			t.Errorf("expected %s in the sandbox environment", kv)
+		}
+	}
+}
+
+func TestRestrictedPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{".compell/config.yaml", "vendor/lib/a.go", "vendor/secret.key", "src/.env", "src/main.go"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755)
+		os.WriteFile(filepath.Join(dir, path), nil, 0644)
+	}
+
+	hidden, readOnly, err := restrictedPaths(dir, []string{".compell", ".compell/**", "**/*.key", "**/.env"}, []string{"vendor", "vendor/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := func(paths ...string) []string {
+		for i, p := range paths {
+			paths[i] = filepath.Join(dir, p)
+		}
+		return paths
+	}
+	if want := abs(".compell", "src/.env", "vendor/secret.key"); !reflect.DeepEqual(hidden, want) {
+		t.Errorf("hidden = %v, want %v", hidden, want)
+	}
+	if want := abs("vendor"); !reflect.DeepEqual(readOnly, want) {
+		t.Errorf("read-only = %v, want %v", readOnly, want)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	s := Unavailable(os.ErrPermission)
+	if err := s.Apply(nil); err != os.ErrPermission {
+		t.Errorf("Apply() = %v, want the initialization error", err)
+	}
+}
+
+func contains(env []string, kv string) bool {
+	for _, e := range env {
+		if e == kv {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+// Package secrets keeps credentials away from the processes compell starts
+// and out of what tools return to the model and the session transcript.
+package secrets
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+)
+
+// secretEnvPatterns match the names of environment variables that usually
+// hold credentials. They are removed from child environments unless allowed
+// explicitly, and their values are redacted from tool output.
+var secretEnvPatterns = []string{
+	"*_API_KEY", "*_APIKEY", "*_API_KEY_*",
+	"*_SECRET", "*_SECRET_*",
+	"*_TOKEN", "*_TOKEN_*",
+	"*PASSWORD*", "*_PASSWD",
+	"*_CREDENTIALS",
+}
+
+// IsSecretEnv reports whether the named environment variable looks like it
+// holds a credential.
+func IsSecretEnv(name string) bool {
+	return matchEnv(secretEnvPatterns, strings.ToUpper(name))
+}
+
+// baseEnv match the names of the environment variables most programs need,
+// such as the search path, home directory and locale. The Windows ones keep
+// processes working there.
+var baseEnv = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LANGUAGE", "LC_*", "TERM", "TZ", "TMPDIR",
+	"SYSTEMROOT", "WINDIR", "COMSPEC", "PATHEXT", "TEMP", "TMP", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// IsBaseEnv reports whether the named environment variable is one most
+// programs need to run, such as PATH or HOME.
+func IsBaseEnv(name string) bool {
+	return matchEnv(baseEnv, strings.ToUpper(name))
+}
+
+// ScrubEnv returns the variables of env that most programs need (see
+// IsBaseEnv) and those matching filter.Allow, except those matching
+// filter.Deny. Unlike FilterEnv, nothing else is passed by default.
+func ScrubEnv(env []string, filter config.EnvFilter) []string {
+	var scrubbed []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !matchEnv(filter.Deny, name) && (IsBaseEnv(name) || matchEnv(filter.Allow, name)) {
+			scrubbed = append(scrubbed, kv)
+		}
+	}
+	return scrubbed
+}
+
+// FilterEnv returns the variables of env (in "NAME=value" form) that filter
+// lets through. Variables matching a deny pattern are always removed. If
+// allow patterns are given, only matching variables are kept; otherwise every
+// variable is kept except those that look like credentials.
+func FilterEnv(env []string, filter config.EnvFilter) []string {
+	var filtered []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		switch {
+		case matchEnv(filter.Deny, name):
+			continue
+		case len(filter.Allow) > 0:
+			if !matchEnv(filter.Allow, name) {
+				continue
+			}
+		case IsSecretEnv(name):
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+// envReference matches a ${VAR} reference to an environment variable.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv replaces ${VAR} references in s by the values lookup returns,
+// usually os.LookupEnv. Referencing an unset variable is an error, so that a
+// missing token is noticed rather than sent empty. The error names the
+// variable but never includes values.
+func ExpandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", errors.New("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// matchEnv matches a variable name against glob patterns such as "AWS_*".
+func matchEnv(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// detector finds one kind of secret. When group is non-zero only that
+// submatch is redacted, e.g. the value of an assignment but not its name.
+type detector struct {
+	name  string
+	re    *regexp.Regexp
+	group int
+}
+
+// builtinDetectors recognize common credential formats.
+var builtinDetectors = []detector{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z0-9 ]*PRIVATE KEY-----|\z)`), 0},
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`), 0},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`), 1},
+	{"anthropic-api-key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`), 0},
+	{"openai-api-key", regexp.MustCompile(`\bsk-(?:proj-|svcacct-|admin-)?[A-Za-z0-9_-]{20,}`), 0},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`), 0},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`), 0},
+	{"slack-token", regexp.MustCompile(`\bxox[abeoprs]-[A-Za-z0-9-]{10,}`), 0},
+	{"bearer-token", regexp.MustCompile(`(?i)\bauthorization:\s*bearer\s+([A-Za-z0-9._~+/=-]{8,})`), 1},
+	// Upper case assignments such as "OPENAI_API_KEY=..." in env dumps and
+	// config files, but not ordinary code like "token = next()".
+	{"secret-assignment", regexp.MustCompile(`\b[A-Z0-9_]*(?:API_?KEY|SECRET|TOKEN|PASSWORD|PASSWD)[A-Z0-9_]*["']?\s*[:=]\s*["']?([^\s"',;]{8,})`), 1},
+}
+
+// minSecretValueLength is the minimum length of an environment variable value
+// to be redacted wherever it appears. Shorter values cause false positives.
+const minSecretValueLength = 8
+
+// Redactor replaces secrets in text with a marker naming the kind of secret.
+// A nil *Redactor leaves text unchanged.
+type Redactor struct {
+	detectors []detector
+	values    []string // Literal secret values, longest first
+}
+
+// NewRedactor creates a redactor from the configuration. It returns nil if
+// redaction is disabled.
+func NewRedactor(cfg *config.Redaction) (*Redactor, error) {
+	if cfg.Disabled {
+		return nil, nil
+	}
+	r := &Redactor{detectors: append([]detector(nil), builtinDetectors...)}
+	for i, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redaction pattern '%s'", pattern)
+		}
+		r.detectors = append(r.detectors, detector{name: "pattern-" + strconv.Itoa(i+1), re: re})
+	}
+
+	// The values of compell's own credentials, such as the LLM API key, are
+	// redacted wherever they show up, whatever their format.
+	r.AddSecretEnv(os.Environ())
+	return r, nil
+}
+
+// AddSecretEnv makes r redact the values of the variables of env (in
+// "NAME=value" form) that look like credentials wherever they show up, such
+// as the variables set for MCP servers in the configuration.
+func (r *Redactor) AddSecretEnv(env []string) {
+	var values []string
+	for _, kv := range env {
+		if name, value, _ := strings.Cut(kv, "="); IsSecretEnv(name) {
+			values = append(values, value)
+		}
+	}
+	r.AddSecrets(values)
+}
+
+// AddSecrets makes r redact values wherever they show up, such as the tokens
+// sent to MCP servers in headers. Values too short to be told apart from
+// ordinary text are ignored.
+func (r *Redactor) AddSecrets(values []string) {
+	if r == nil {
+		return
+	}
+	for _, value := range values {
+		if len(value) >= minSecretValueLength && !slices.Contains(r.values, value) {
+			r.values = append(r.values, value)
+		}
+	}
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// Redact returns text with every detected secret replaced by a marker such
+// as "[REDACTED:aws-access-key-id]".
+func (r *Redactor) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	for _, value := range r.values {
+		text = strings.ReplaceAll(text, value, "[REDACTED:env]")
+	}
+	for _, d := range r.detectors {
+		text = d.redact(text)
+	}
+	return text
+}
+
+func (d detector) redact(text string) string {
+	marker := "[REDACTED:" + d.name + "]"
+	if d.group == 0 {
+		return d.re.ReplaceAllLiteralString(text, marker)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range d.re.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2*d.group], m[2*d.group+1]
+		if start < 0 || strings.HasPrefix(text[start:end], "[REDACTED:") {
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString(marker)
+		last = end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
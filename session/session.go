@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
 )
 
@@ -16,10 +17,21 @@ type ToolCall struct {
 	Args       map[string]interface{} `json:"args"`
 }
 
+// Usage records the tokens consumed by a single LLM call.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
 type Message struct {
-	Role      string     `json:"role"` // "user", "assistant", "tool"
-	Content   string     `json:"content"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role       string     `json:"role"` // "user", "assistant", "tool"
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	Usage      *Usage     `json:"usage,omitempty"`       // Set on assistant messages when the provider reports it
+	StopReason string     `json:"stop_reason,omitempty"` // Set when a turn ended before the model finished
+	// Result is the full result of a tool call, set on "tool" messages. Content
+	// holds its text.
+	Result *ToolResult `json:"result,omitempty"`
 }
 
 type Session struct {
@@ -28,7 +40,15 @@ type Session struct {
 	Mode          string    `json:"mode"`           // New field to store mode
 	Toolset       string    `json:"toolset"`        // New field to store toolset
 	ToolVerbosity string    `json:"tool_verbosity"` // New field to store tool verbosity
-	path          string
+	// ApprovalRules holds the "always allow" choices the user made during this session.
+	ApprovalRules []config.PolicyRule `json:"approval_rules,omitempty"`
+	// Todos is the plan the agent maintains with the todo tools.
+	Todos []TodoItem `json:"todos,omitempty"`
+	// Parent is the name of the session that delegated this session's task.
+	Parent string `json:"parent,omitempty"`
+	// Delegations names the sessions of the tasks delegated to sub-agents.
+	Delegations []string `json:"delegations,omitempty"`
+	path        string
 }
 
 // New creates a new session.
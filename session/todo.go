@@ -0,0 +1,106 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// Todo statuses.
+const (
+	TodoPending    = "pending"
+	TodoInProgress = "in_progress"
+	TodoCompleted  = "completed"
+	TodoCancelled  = "cancelled"
+)
+
+// Todo priorities.
+const (
+	PriorityHigh   = "high"
+	PriorityMedium = "medium"
+	PriorityLow    = "low"
+)
+
+// TodoItem is one step of the plan the agent keeps for a multi-step task.
+type TodoItem struct {
+	ID       string `json:"id"`
+	Content  string `json:"content"`
+	Status   string `json:"status"`
+	Priority string `json:"priority"`
+}
+
+// ValidateTodos checks that every item has a unique id, some content and a
+// known status and priority, and that at most one item is in progress.
+func ValidateTodos(items []TodoItem) error {
+	ids := make(map[string]bool)
+	inProgress := 0
+	for i, item := range items {
+		if item.ID == "" {
+			return errors.New("todo %d has no id", i+1)
+		}
+		if ids[item.ID] {
+			return errors.New("duplicate todo id '%s'", item.ID)
+		}
+		ids[item.ID] = true
+		if strings.TrimSpace(item.Content) == "" {
+			return errors.New("todo '%s' has no content", item.ID)
+		}
+		switch item.Status {
+		case TodoPending, TodoCompleted, TodoCancelled:
+		case TodoInProgress:
+			inProgress++
+		default:
+			return errors.New("todo '%s' has unknown status '%s'", item.ID, item.Status)
+		}
+		switch item.Priority {
+		case PriorityHigh, PriorityMedium, PriorityLow:
+		default:
+			return errors.New("todo '%s' has unknown priority '%s'", item.ID, item.Priority)
+		}
+	}
+	if inProgress > 1 {
+		return errors.New("only one todo may be in progress at a time, found %d", inProgress)
+	}
+	return nil
+}
+
+// RenderTodos formats the todo list as a checklist, one item per line.
+func RenderTodos(items []TodoItem) string {
+	if len(items) == 0 {
+		return "No todos."
+	}
+	var b strings.Builder
+	for _, item := range items {
+		box := "[ ]"
+		switch item.Status {
+		case TodoInProgress:
+			box = "[~]"
+		case TodoCompleted:
+			box = "[x]"
+		case TodoCancelled:
+			box = "[-]"
+		}
+		fmt.Fprintf(&b, "%s %s. %s", box, item.ID, item.Content)
+		if item.Priority != PriorityMedium {
+			fmt.Fprintf(&b, " (%s)", item.Priority)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context carrying the session, so that tools such as
+// todo_write can keep state in it.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session carried by ctx, or nil.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
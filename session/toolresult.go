@@ -0,0 +1,65 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Content part types.
+const (
+	PartText  = "text"
+	PartImage = "image"
+	PartAudio = "audio"
+)
+
+// ContentPart is one part of a tool result: text, or binary data such as an
+// image or audio clip.
+type ContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	MIMEType string `json:"mime_type,omitempty"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+// ToolMetadata describes how a tool call went. Zero fields are unknown or do
+// not apply to the tool.
+type ToolMetadata struct {
+	BytesWritten int64         `json:"bytes_written,omitempty"`
+	ExitCode     *int          `json:"exit_code,omitempty"`
+	Duration     time.Duration `json:"duration,omitempty"`
+	Truncated    bool          `json:"truncated,omitempty"` // Output was cut to fit the output limit
+	Denied       bool          `json:"denied,omitempty"`    // The user refused to run the call
+}
+
+// ToolResult is the outcome of a tool call. IsError marks a call that failed,
+// which LLM providers are told through their native error flag.
+type ToolResult struct {
+	Content  []ContentPart `json:"content"`
+	IsError  bool          `json:"is_error,omitempty"`
+	Metadata ToolMetadata  `json:"metadata,omitzero"`
+}
+
+// TextResult returns a successful result holding text.
+func TextResult(text string) ToolResult {
+	return ToolResult{Content: []ContentPart{{Type: PartText, Text: text}}}
+}
+
+// ErrorResult returns a failed result holding an error message.
+func ErrorResult(text string) ToolResult {
+	return ToolResult{Content: []ContentPart{{Type: PartText, Text: text}}, IsError: true}
+}
+
+// Text returns the text of the result. Non-text parts are described by a
+// placeholder such as "[image/png image, 2048 bytes]".
+func (r ToolResult) Text() string {
+	var parts []string
+	for _, part := range r.Content {
+		if part.Type == PartText {
+			parts = append(parts, part.Text)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("[%s %s, %d bytes]", part.MIMEType, part.Type, len(part.Data)))
+	}
+	return strings.Join(parts, "\n")
+}
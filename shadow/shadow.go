@@ -0,0 +1,312 @@
+// Package shadow keeps an audit trail of the changes the agent makes by
+// committing the working tree after every turn that changed it to a
+// per-session ref, refs/compell/<session>. The user's branch, index and
+// working tree are never touched: snapshots are written through a temporary
+// index file.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/secrets"
+)
+
+// gitTimeout bounds every git invocation.
+const gitTimeout = time.Minute
+
+// outsideMessage is the message of the commits recording changes made between
+// turns, by the user or other programs, so that turn commits only contain the
+// changes of their turn.
+const outsideMessage = "Changes made outside compell"
+
+// Recorder commits the working tree to the shadow ref of a session. A nil
+// *Recorder records nothing.
+type Recorder struct {
+	ref      string
+	fsAccess *config.FilesystemAccess
+	before   string // Tree of the working tree when the current turn began
+}
+
+// Commit is a commit on a shadow ref.
+type Commit struct {
+	Hash    string
+	Date    time.Time
+	Subject string
+	Files   int // Number of changed paths
+}
+
+// Open returns the recorder for the named session. It fails if the working
+// directory is not inside a git repository or the session name cannot be used
+// in a ref name.
+func Open(sessionName string, fsAccess *config.FilesystemAccess) (*Recorder, error) {
+	if _, err := git(context.Background(), nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, errors.Wrapf(err, "shadow commits require a git repository")
+	}
+	ref := "refs/compell/" + sessionName
+	if _, err := git(context.Background(), nil, "check-ref-format", ref); err != nil {
+		return nil, errors.New("session name '%s' cannot be used in a git ref name", sessionName)
+	}
+	return &Recorder{ref: ref, fsAccess: fsAccess}, nil
+}
+
+// Ref returns the shadow ref of the session.
+func (r *Recorder) Ref() string {
+	return r.ref
+}
+
+// Begin snapshots the working tree at the start of a turn.
+func (r *Recorder) Begin(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	tree, err := r.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	r.before = tree
+	return nil
+}
+
+// End commits the working tree to the shadow ref with message if it changed
+// since Begin. It returns the new commit, or nil if nothing changed.
+func (r *Recorder) End(ctx context.Context, message string) (*Commit, error) {
+	if r == nil || r.before == "" {
+		return nil, nil
+	}
+	before := r.before
+	r.before = ""
+	after, err := r.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if after == before {
+		return nil, nil
+	}
+
+	parent, err := r.tip(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Changes made since the last turn are committed separately, so that the
+	// turn commit can be diffed and cherry-picked on its own.
+	if parent == "" || r.treeOf(ctx, parent) != before {
+		parent, err = commitTree(ctx, before, parent, outsideMessage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hash, err := commitTree(ctx, after, parent, message)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := git(ctx, nil, "update-ref", "-m", "compell: turn", r.ref, hash); err != nil {
+		return nil, err
+	}
+
+	stat, err := git(ctx, nil, "diff-tree", "--no-commit-id", "--name-only", "-r", parent, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Commit{Hash: hash, Date: time.Now(), Subject: subject(message), Files: len(lines(stat))}, nil
+}
+
+// List returns the commits on the shadow ref that are not on HEAD, oldest
+// first.
+func (r *Recorder) List(ctx context.Context) ([]Commit, error) {
+	tip, err := r.tip(ctx)
+	if err != nil || tip == "" {
+		return nil, err
+	}
+	args := []string{"log", "--reverse", "--format=%x00%H %ct %s", "--name-only", tip}
+	if head, _ := git(ctx, nil, "rev-parse", "--verify", "-q", "HEAD"); head != "" {
+		args = append(args, "--not", "HEAD")
+	}
+	out, err := git(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, entry := range strings.Split(out, "\x00") {
+		entryLines := lines(entry)
+		if len(entryLines) == 0 {
+			continue
+		}
+		fields := strings.SplitN(entryLines[0], " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		c := Commit{Hash: fields[0], Files: len(entryLines) - 1}
+		if len(fields) == 3 {
+			c.Subject = fields[2]
+		}
+		if unix, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			c.Date = time.Unix(unix, 0)
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// Resolve returns the full hash of the shadow commit named by rev, which may
+// be an abbreviated hash or a 1-based position in List.
+func (r *Recorder) Resolve(ctx context.Context, rev string) (string, error) {
+	commits, err := r.List(ctx)
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for i, c := range commits {
+		if rev == strconv.Itoa(i+1) || (len(rev) >= 4 && strings.HasPrefix(c.Hash, rev)) {
+			matches = append(matches, c.Hash)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", errors.New("no shadow commit '%s' in %s", rev, r.ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", errors.New("shadow commit '%s' is ambiguous", rev)
+	}
+}
+
+// Diff returns the patch of the shadow commit named by rev.
+func (r *Recorder) Diff(ctx context.Context, rev string) (string, error) {
+	hash, err := r.Resolve(ctx, rev)
+	if err != nil {
+		return "", err
+	}
+	return git(ctx, nil, "show", "--format=commit %H%n%n    %s%n", "--stat", "--patch", hash)
+}
+
+// CherryPick applies the shadow commit named by rev to the current branch.
+func (r *Recorder) CherryPick(ctx context.Context, rev string) (string, error) {
+	hash, err := r.Resolve(ctx, rev)
+	if err != nil {
+		return "", err
+	}
+	return git(ctx, nil, "cherry-pick", hash)
+}
+
+// snapshot writes the working tree, minus ignored and hidden paths, to the
+// object database and returns its tree hash.
+func (r *Recorder) snapshot(ctx context.Context) (string, error) {
+	realIndex, err := git(ctx, nil, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	index, err := os.CreateTemp(filepath.Dir(realIndex), "compell-index-*")
+	if err != nil {
+		return "", errors.Wrapf(err, "could not create temporary index")
+	}
+	defer os.Remove(index.Name())
+
+	// Starting from a copy of the real index lets git skip rehashing files
+	// that did not change. A missing index is fine: git starts from scratch.
+	data, err := os.ReadFile(realIndex)
+	if err != nil && !os.IsNotExist(err) {
+		index.Close()
+		return "", errors.Wrapf(err, "could not read the git index")
+	}
+	_, err = index.Write(data)
+	if closeErr := index.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "could not write temporary index")
+	}
+	if len(data) == 0 {
+		os.Remove(index.Name())
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+	addArgs := []string{"add", "--all", "--", "."}
+	for _, pattern := range r.fsAccess.Hidden {
+		addArgs = append(addArgs, ":(exclude,glob)"+pattern)
+	}
+	if _, err := git(ctx, env, addArgs...); err != nil {
+		return "", err
+	}
+	return git(ctx, env, "write-tree")
+}
+
+// tip returns the commit the next shadow commit is based on: the tip of the
+// shadow ref, or HEAD before the first one. It is "" in an empty repository.
+func (r *Recorder) tip(ctx context.Context) (string, error) {
+	hash, err := git(ctx, nil, "rev-parse", "--verify", "-q", r.ref)
+	if err == nil {
+		return hash, nil
+	}
+	head, _ := git(ctx, nil, "rev-parse", "--verify", "-q", "HEAD")
+	return head, nil
+}
+
+func (r *Recorder) treeOf(ctx context.Context, commit string) string {
+	tree, _ := git(ctx, nil, "rev-parse", commit+"^{tree}")
+	return tree
+}
+
+// commitTree creates a commit of tree with an optional parent.
+func commitTree(ctx context.Context, tree, parent, message string) (string, error) {
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	return git(ctx, committerEnv(ctx), args...)
+}
+
+// committerEnv supplies an identity for shadow commits when git has none
+// configured, so that recording never fails for that reason.
+func committerEnv(ctx context.Context) []string {
+	if name, _ := git(ctx, nil, "config", "user.name"); name != "" {
+		return nil
+	}
+	return []string{
+		"GIT_AUTHOR_NAME=compell", "GIT_AUTHOR_EMAIL=compell@localhost",
+		"GIT_COMMITTER_NAME=compell", "GIT_COMMITTER_EMAIL=compell@localhost",
+	}
+}
+
+// git runs git with args and extra environment variables and returns its
+// trimmed standard output.
+func git(ctx context.Context, env []string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--no-pager", "-c", "core.quotepath=off"}, args...)...)
+	cmd.Env = append(secrets.FilterEnv(os.Environ(), config.EnvFilter{}), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.New("git %s failed: %v\n%s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func lines(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// subject returns the first line of message, shortened for listings.
+func subject(message string) string {
+	s, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	if runes := []rune(s); len(runes) > 72 {
+		s = string(runes[:69]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,132 @@
+package shadow
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/m4xw311/compell/config"
+)
+
+func gitCmd(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newRepo(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	gitCmd(t, "init", "--quiet", "-b", "main")
+	gitCmd(t, "config", "user.name", "Test")
+	gitCmd(t, "config", "user.email", "test@example.com")
+	os.WriteFile("a.txt", []byte("one\n"), 0644)
+	gitCmd(t, "add", "a.txt")
+	gitCmd(t, "commit", "--quiet", "-m", "Initial commit")
+}
+
+func TestRecordTurns(t *testing.T) {
+	newRepo(t)
+	ctx := context.Background()
+	r, err := Open("session-1", &config.FilesystemAccess{Hidden: []string{".env"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A turn without changes records nothing.
+	if err := r.Begin(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := r.End(ctx, "just a question"); err != nil || c != nil {
+		t.Fatalf("End = %v, %v; want no commit", c, err)
+	}
+
+	// The user's uncommitted change is recorded separately from the turn.
+	os.WriteFile("user.txt", []byte("user\n"), 0644)
+	r.Begin(ctx)
+	os.WriteFile("a.txt", []byte("two\n"), 0644)
+	os.WriteFile(".env", []byte("SECRET=1\n"), 0644)
+	c, err := r.End(ctx, "change a")
+	if err != nil || c == nil {
+		t.Fatalf("End = %v, %v", c, err)
+	}
+	if c.Files != 1 {
+		t.Errorf("turn commit changed %d path(s), want 1", c.Files)
+	}
+
+	commits, err := r.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].Subject != outsideMessage || commits[1].Subject != "change a" {
+		t.Fatalf("unexpected commits: %+v", commits)
+	}
+	if files := gitCmd(t, "ls-tree", "--name-only", "refs/compell/session-1"); strings.Contains(files, ".env") {
+		t.Errorf("hidden file recorded in shadow commit: %s", files)
+	}
+
+	diff, err := r.Diff(ctx, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, "+two") || strings.Contains(diff, "user.txt") {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	// The user's branch, index and working tree are untouched.
+	if status := gitCmd(t, "status", "--porcelain"); !strings.Contains(status, "M a.txt") || !strings.Contains(status, "?? user.txt") {
+		t.Errorf("unexpected status:\n%s", status)
+	}
+	if head := gitCmd(t, "log", "--format=%s", "-1"); head != "Initial commit" {
+		t.Errorf("HEAD moved to %q", head)
+	}
+
+	// Cherry-picking the turn onto a clean branch applies only its change.
+	gitCmd(t, "checkout", "--quiet", "--", "a.txt")
+	if _, err := r.CherryPick(ctx, commits[1].Hash[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile("a.txt"); string(data) != "two\n" {
+		t.Errorf("a.txt = %q after cherry-pick", data)
+	}
+	if _, err := r.Resolve(ctx, "3"); err == nil {
+		t.Error("expected an error for an unknown shadow commit")
+	}
+}
+
+func TestOpenRequiresRepository(t *testing.T) {
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.Chdir(t.TempDir())
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	if _, err := Open("s", &config.FilesystemAccess{}); err == nil {
+		t.Error("expected an error outside a git repository")
+	}
+}
+
+func TestSubject(t *testing.T) {
+	for message, want := range map[string]string{
+		"  Fix the parser\nDetails  ":   "Fix the parser",
+		strings.Repeat("a", 72):         strings.Repeat("a", 72),
+		strings.Repeat("a", 73):         strings.Repeat("a", 69) + "...",
+		strings.Repeat("é", 80) + "\nx": strings.Repeat("é", 69) + "...",
+	} {
+		if got := subject(message); got != want || !utf8.ValidString(got) {
+			t.Errorf("subject(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
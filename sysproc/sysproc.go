@@ -0,0 +1,15 @@
+// Package sysproc contains helpers for managing the child processes compell
+// launches, such as shell commands and MCP servers.
+package sysproc
+
+import "os/exec"
+
+// KillOnCancel configures a command created with exec.CommandContext so that
+// cancelling its context kills the whole process group instead of only the
+// direct child. SetProcessGroup is applied as part of the configuration.
+func KillOnCancel(cmd *exec.Cmd) {
+	SetProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return KillProcessGroup(cmd)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package sysproc
+
+import "os/exec"
+
+// SetProcessGroup is a no-op on platforms without POSIX process groups.
+func SetProcessGroup(cmd *exec.Cmd) {}
+
+// KillProcessGroup kills the command's process. Platforms without POSIX
+// process groups cannot reach its descendants.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// TerminateProcessGroup kills the command's process, as there is no portable
+// way to ask it to exit.
+func TerminateProcessGroup(cmd *exec.Cmd) error {
+	return KillProcessGroup(cmd)
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package sysproc
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// SetProcessGroup places the command in its own process group. This keeps
+// terminal signals such as Ctrl-C, which are meant for compell, from reaching
+// the child directly, and lets KillProcessGroup take down any grandchildren.
+func SetProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// KillProcessGroup kills the process group led by the command's process.
+// It must only be used on commands started after SetProcessGroup.
+func KillProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// TerminateProcessGroup asks the process group led by the command's process
+// to exit with SIGTERM, giving it a chance to clean up.
+func TerminateProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
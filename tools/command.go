@@ -3,53 +3,424 @@ package tools
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/m4xw311/compell/checkpoint"
+	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/sandbox"
+	"github.com/m4xw311/compell/secrets"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/sysproc"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 )
 
+// commandWaitDelay bounds how long a cancelled command may keep its output
+// pipes open before Execute gives up waiting for it.
+const commandWaitDelay = 2 * time.Second
+
+const (
+	defaultCommandTimeout   = 2 * time.Minute
+	defaultMaxCommandOutput = 32 * 1024
+)
+
+// exitCodeNotRunnable is reported, like the shell does, for commands that
+// could not be started (e.g. not found).
+const exitCodeNotRunnable = 127
+
 // ExecuteCommandTool implements the tool for running OS commands.
 type ExecuteCommandTool struct {
 	allowedCommands []string
+	fsAccess        *config.FilesystemAccess
+	timeout         time.Duration
+	maxOutputBytes  int
+	sandbox         *sandbox.Sandbox // Nil when commands run unsandboxed
+	envFilter       config.EnvFilter
+}
+
+// NewExecuteCommandTool creates the execute_command tool from the configuration.
+// Commands run in sb, which may be nil.
+func NewExecuteCommandTool(cfg *config.Config, sb *sandbox.Sandbox) *ExecuteCommandTool {
+	return &ExecuteCommandTool{
+		allowedCommands: cfg.AllowedCommands,
+		fsAccess:        &cfg.FilesystemAccess,
+		timeout:         cfg.CommandExecution.Timeout,
+		maxOutputBytes:  cfg.CommandExecution.MaxOutputBytes,
+		sandbox:         sb,
+		envFilter:       cfg.ToolEnvFilters["execute_command"],
+	}
 }
 
 func (t *ExecuteCommandTool) Name() string { return "execute_command" }
 func (t *ExecuteCommandTool) Description() string {
+	const usage = "Executes a command line. Supports quoting, pipes (|), chaining with &&, || and ;, " +
+		"NAME=value environment assignments, redirections (<, >, >>, 2>, 2>&1, &>) and filename globs. " +
+		"Variable expansion, command substitution, subshells and background jobs are not supported. " +
+		"Every command in a pipeline or chain must be allowed. Long output is truncated, keeping the beginning and end. " +
+		"Args: command (string), [working_dir (string, relative to the project root)], [timeout_seconds (number)]."
 	if len(t.allowedCommands) == 0 {
-		return "Executes a shell command. No commands are currently allowed. Args: command (string)."
+		return usage + "\nNo commands are currently allowed."
 	}
 
-	allowedList := "Allowed command wildcard patterns:\n"
+	allowedList := "Allowed command patterns (regular expressions matched against each whole command):\n"
 	for _, cmd := range t.allowedCommands {
 		allowedList += fmt.Sprintf("- %s\n", cmd)
 	}
 
-	return fmt.Sprintf("Executes a shell command. Args: command (string).\n%s", allowedList)
+	return fmt.Sprintf("%s\n%s", usage, allowedList)
+}
+
+func (t *ExecuteCommandTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"command":         argSchema("string", "Command line to execute"),
+		"working_dir":     argSchema("string", "Working directory, relative to the project root"),
+		"timeout_seconds": argSchema("number", "Timeout in seconds"),
+	}, "command")
 }
 
-func (t *ExecuteCommandTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *ExecuteCommandTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	command, ok := args["command"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'command' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'command' argument")
+	}
+
+	chain, err := parseCommandLine(command)
+	if err != nil {
+		return session.ToolResult{}, errors.Wrapf(err, "could not parse command '%s'", command)
+	}
+	for _, item := range chain {
+		for _, c := range item.pipeline.commands {
+			allowed, err := isCommandAllowed(c.String(), t.allowedCommands)
+			if err != nil {
+				return session.ToolResult{}, err
+			}
+			if !allowed {
+				return session.ToolResult{}, errors.New("command '%s' is not in the list of allowed commands", c.String())
+			}
+		}
+	}
+
+	dir, err := resolveWorkingDir(args, t.fsAccess)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+
+	timeout := t.timeout
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	if seconds, ok := args["timeout_seconds"].(float64); ok && seconds > 0 {
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	limit := t.maxOutputBytes
+	if limit <= 0 {
+		limit = defaultMaxCommandOutput
+	}
+	output := newHeadTailBuffer(limit)
+
+	exitCode, err := t.runChain(runCtx, chain, dir, output)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if ctx.Err() != nil {
+		return session.ToolResult{}, ctx.Err()
+	}
+
+	var result session.ToolResult
+	switch {
+	case runCtx.Err() != nil:
+		result = session.ErrorResult(fmt.Sprintf("Command timed out after %s. Output:\n%s", timeout, output))
+	case exitCode != 0:
+		result = session.ErrorResult(fmt.Sprintf("Command exited with code %d. Output:\n%s", exitCode, output))
+		result.Metadata.ExitCode = &exitCode
+	default:
+		result = session.TextResult(fmt.Sprintf("Command executed successfully (exit code 0). Output:\n%s", output))
+		result.Metadata.ExitCode = &exitCode
 	}
+	result.Metadata.Truncated = output.Truncated()
+	return result, nil
+}
 
-	allowed, err := isCommandAllowed(command, t.allowedCommands)
+// Preview returns the exact command line that would be executed, with its
+// working directory and time limit.
+func (t *ExecuteCommandTool) Preview(args map[string]interface{}) (string, error) {
+	preview, err := previewCommand(args)
 	if err != nil {
 		return "", err
 	}
-	if !allowed {
-		return "", errors.New("command '%s' is not in the list of allowed commands", command)
+	if seconds, ok := args["timeout_seconds"].(float64); ok && seconds > 0 {
+		preview = fmt.Sprintf("(time limit %v)\n%s", time.Duration(seconds*float64(time.Second)), preview)
 	}
+	return preview, nil
+}
+
+func (t *ExecuteCommandTool) PreviewedArgs() []string {
+	return []string{"command", "working_dir", "timeout_seconds"}
+}
 
-	// Basic shell-like execution
-	parts := strings.Fields(command)
-	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+// previewCommand returns the command line of args as it would be run, with
+// its working directory.
+func previewCommand(args map[string]interface{}) (string, error) {
+	command, ok := args["command"].(string)
+	if !ok {
+		return "", errors.New("missing or invalid 'command' argument")
+	}
+	preview := fmt.Sprintf("$ %s", command)
+	if dir, ok := args["working_dir"].(string); ok && dir != "" {
+		preview = fmt.Sprintf("(in %s)\n%s", dir, preview)
+	}
+	return preview, nil
+}
 
-	output, err := cmd.CombinedOutput()
+// resolveWorkingDir resolves the optional working_dir argument, which must be
+// a visible directory inside the current working directory.
+func resolveWorkingDir(args map[string]interface{}, fsAccess *config.FilesystemAccess) (string, error) {
+	dir, _ := args["working_dir"].(string)
+	if dir == "" {
+		return ".", nil
+	}
+	root, err := os.Getwd()
 	if err != nil {
-		return "", errors.Wrapf(err, "command execution failed. Output:\n%s", string(output))
+		return "", errors.Wrapf(err, "could not get working directory")
+	}
+	abs := dir
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, dir)
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("access denied: working directory '%s' is outside the project", dir)
+	}
+	if fsAccess != nil {
+		hidden, err := isPathRestricted(rel, fsAccess.Hidden)
+		if err != nil {
+			return "", err
+		}
+		if hidden {
+			return "", errors.New("access denied: path '%s' is hidden", dir)
+		}
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid working directory '%s'", dir)
+	}
+	if !info.IsDir() {
+		return "", errors.New("working directory '%s' is not a directory", dir)
+	}
+	return rel, nil
+}
+
+// runChain runs the pipelines of a command line, honouring && and ||, and
+// returns the exit code of the last pipeline that ran. Errors are only
+// returned for failures of compell itself, not of the commands.
+func (t *ExecuteCommandTool) runChain(ctx context.Context, chain []chainItem, dir string, output io.Writer) (int, error) {
+	exitCode := 0
+	for _, item := range chain {
+		if ctx.Err() != nil {
+			break
+		}
+		if (item.op == "&&" && exitCode != 0) || (item.op == "||" && exitCode == 0) {
+			continue
+		}
+		code, err := t.runPipeline(ctx, item.pipeline, dir, output)
+		if err != nil {
+			return 0, err
+		}
+		exitCode = code
+	}
+	return exitCode, nil
+}
+
+// runPipeline starts every command of a pipeline with their standard streams
+// connected, waits for all of them and returns the exit code of the last one.
+func (t *ExecuteCommandTool) runPipeline(ctx context.Context, p *pipeline, dir string, output io.Writer) (int, error) {
+	var cmds []*exec.Cmd
+	var names []string    // Command names, as cmd.Args is rewritten by the sandbox
+	var files []io.Closer // Files and pipe ends to close once the commands have started
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var stdin io.Reader
+	for i, sc := range p.commands {
+		argv, err := expandWords(sc.args, dir)
+		if err != nil {
+			return 0, err
+		}
+		cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(secrets.FilterEnv(t.sandbox.Environ(), t.envFilter), sc.env...)
+		// Cancelling the context (e.g. on Ctrl-C or timeout) must also stop
+		// anything the command spawned, not just the command itself.
+		sysproc.KillOnCancel(cmd)
+		cmd.WaitDelay = commandWaitDelay
+
+		cmd.Stdin = stdin
+		cmd.Stdout = output
+		cmd.Stderr = output
+		stdin = nil
+		if i < len(p.commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to create pipe")
+			}
+			files = append(files, r, w)
+			cmd.Stdout = w
+			stdin = r
+		}
+
+		if err := t.applyRedirects(ctx, cmd, sc.redirects, dir, &files); err != nil {
+			return 0, err
+		}
+		if err := t.sandbox.Apply(cmd); err != nil {
+			return 0, err
+		}
+		cmds = append(cmds, cmd)
+		names = append(names, argv[0])
 	}
 
-	return fmt.Sprintf("Command executed successfully. Output:\n%s", string(output)), nil
+	started := make([]bool, len(cmds))
+	exitCode := 0
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintf(output, "compell: %s: %v\n", names[i], err)
+			if i == len(cmds)-1 {
+				exitCode = exitCodeNotRunnable
+			}
+			continue
+		}
+		started[i] = true
+	}
+	// The children hold their own copies of the pipe ends and files.
+	for _, f := range files {
+		f.Close()
+	}
+	files = nil
+
+	for i, cmd := range cmds {
+		if !started[i] {
+			continue
+		}
+		err := cmd.Wait()
+		if i != len(cmds)-1 {
+			continue
+		}
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			exitCode = 0
+		case errors.As(err, &exitErr):
+			exitCode = exitErr.ExitCode()
+			if exitCode < 0 {
+				// Killed by a signal.
+				exitCode = 128 + 9
+			}
+		default:
+			exitCode = 1
+			fmt.Fprintf(output, "compell: %s: %v\n", names[i], err)
+		}
+	}
+	return exitCode, nil
+}
+
+// applyRedirects opens the files named in a command's redirections, in order,
+// enforcing the filesystem access rules and checkpointing files it may change.
+func (t *ExecuteCommandTool) applyRedirects(ctx context.Context, cmd *exec.Cmd, redirects []redirect, dir string, files *[]io.Closer) error {
+	for _, r := range redirects {
+		if r.op == ">&" {
+			target := cmd.Stdout
+			if r.target == "2" {
+				target = cmd.Stderr
+			}
+			if r.fd == 2 {
+				cmd.Stderr = target
+			} else {
+				cmd.Stdout = target
+			}
+			continue
+		}
+
+		path, err := t.resolveRedirectTarget(r, dir)
+		if err != nil {
+			return err
+		}
+
+		var f *os.File
+		switch r.op {
+		case "<":
+			f, err = os.Open(path)
+		case ">", "&>":
+			if err = checkpoint.Record(ctx, path); err == nil {
+				f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			}
+		case ">>":
+			if err = checkpoint.Record(ctx, path); err == nil {
+				f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			}
+		default:
+			return errors.New("unsupported redirection '%s'", r.op)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "redirection to '%s' failed", r.target)
+		}
+		*files = append(*files, f)
+
+		switch {
+		case r.op == "&>":
+			cmd.Stdout, cmd.Stderr = f, f
+		case r.fd == 0:
+			cmd.Stdin = f
+		case r.fd == 1:
+			cmd.Stdout = f
+		case r.fd == 2:
+			cmd.Stderr = f
+		}
+	}
+	return nil
+}
+
+// resolveRedirectTarget resolves the file of a redirection made in dir. Like
+// working directories, it must be inside the current working directory, and
+// the filesystem access rules are matched against its path relative to it.
+// The null device is always allowed.
+func (t *ExecuteCommandTool) resolveRedirectTarget(r redirect, dir string) (string, error) {
+	if r.target == os.DevNull {
+		return r.target, nil
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrapf(err, "could not get working directory")
+	}
+	abs := r.target
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, dir, abs)
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("access denied: redirection to '%s' is outside the project", r.target)
+	}
+	if t.fsAccess != nil {
+		restricted := t.fsAccess.Hidden
+		if r.op != "<" {
+			restricted = append(append([]string(nil), t.fsAccess.Hidden...), t.fsAccess.ReadOnly...)
+		}
+		denied, err := isPathRestricted(rel, restricted)
+		if err != nil {
+			return "", err
+		}
+		if denied {
+			return "", errors.New("access denied: redirection to '%s' is not allowed", r.target)
+		}
+	}
+	return rel, nil
 }
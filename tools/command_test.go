@@ -1,8 +1,161 @@
 package tools
 
-import "testing"
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/session"
+)
 
 func TestCommand(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("Command test not yet implemented.")
 }
+
+func TestExecuteCommandCancel(t *testing.T) {
+	tool := &ExecuteCommandTool{allowedCommands: []string{"sleep 30"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := tool.Execute(ctx, map[string]interface{}{"command": "sleep 30"}); err == nil {
+		t.Fatal("expected an error from a cancelled command")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled command took %v to return", elapsed)
+	}
+}
+
+func TestIsCommandAllowedAnchored(t *testing.T) {
+	allowed := []string{"git .*", "go test ./..."}
+	for command, want := range map[string]bool{
+		"git status":               true,
+		"go test ./...":            true,
+		"rm -rf / ; git status":    false,
+		"echo hi && go test ./...": false,
+		"go test ./... -run TestX": false,
+		"legit status":             false,
+	} {
+		got, err := isCommandAllowed(command, allowed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("isCommandAllowed(%q) = %t, want %t", command, got, want)
+		}
+	}
+}
+
+func TestExecuteCommandChainsAndPipes(t *testing.T) {
+	dir := t.TempDir()
+	tool := &ExecuteCommandTool{
+		allowedCommands: []string{"echo .*", "tr .*", "cat .*", "false"},
+		fsAccess:        &config.FilesystemAccess{Hidden: []string{".compell/**"}, ReadOnly: []string{"**/locked.txt"}},
+	}
+	chdir(t, dir)
+	os.Mkdir(".compell", 0755)
+
+	out, err := text(tool.Execute(context.Background(), map[string]interface{}{
+		"command": `echo 'hello world' | tr a-z A-Z > out.txt && cat out.txt; false || echo recovered`,
+	}))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, "HELLO WORLD") || !strings.Contains(out, "recovered") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	// Each command in the chain is checked against the allowlist.
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"command": "echo hi; rm -rf out.txt"}); err == nil {
+		t.Error("expected a disallowed command in a chain to be rejected")
+	}
+	// Redirections respect read-only paths.
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"command": "echo hi > locked.txt"}); err == nil {
+		t.Error("expected redirection to a read-only file to be rejected")
+	}
+	// Absolute and ../ targets are resolved before the rules are applied, and
+	// must stay inside the project.
+	for _, command := range []string{
+		"echo hi > " + filepath.Join(dir, ".compell", "config.yaml"),
+		"echo hi > ./.compell/../.compell/config.yaml",
+		"echo hi > sub/../locked.txt",
+		"echo hi > ../escaped.txt",
+		"echo hi > " + filepath.Join(filepath.Dir(dir), "escaped.txt"),
+		"cat < ../escaped.txt",
+	} {
+		if _, err := tool.Execute(context.Background(), map[string]interface{}{"command": command}); err == nil {
+			t.Errorf("expected %q to be rejected", command)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.txt")); err == nil {
+		t.Error("redirection wrote outside the project")
+	}
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"command": "echo hi > " + filepath.Join(dir, "abs.txt") + " 2>/dev/null"}); err != nil {
+		t.Errorf("redirection to an absolute path in the project: %v", err)
+	}
+
+	// A failing command is a failed result, not an error of the tool.
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"command": "false"})
+	if err != nil || !result.IsError || result.Metadata.ExitCode == nil || *result.Metadata.ExitCode != 1 ||
+		!strings.Contains(result.Text(), "exited with code 1") {
+		t.Errorf("expected exit code 1 to be reported, got %+v, %v", result, err)
+	}
+}
+
+func TestExecuteCommandTruncatesOutput(t *testing.T) {
+	tool := &ExecuteCommandTool{allowedCommands: []string{"seq .*"}, maxOutputBytes: 64}
+	out, err := text(tool.Execute(context.Background(), map[string]interface{}{"command": "seq 1 10000"}))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, "bytes omitted") || !strings.HasPrefix(strings.TrimPrefix(out, "Command executed successfully (exit code 0). Output:\n"), "1\n2\n") || !strings.HasSuffix(out, "9999\n10000\n") {
+		t.Errorf("output not truncated with head and tail kept: %q", out)
+	}
+}
+
+func TestExecuteCommandWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	os.Mkdir("sub", 0755)
+	os.WriteFile(filepath.Join("sub", "marker.txt"), nil, 0644)
+	tool := &ExecuteCommandTool{allowedCommands: []string{"ls"}}
+
+	out, err := text(tool.Execute(context.Background(), map[string]interface{}{"command": "ls", "working_dir": "sub"}))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.Contains(out, "marker.txt") {
+		t.Errorf("command did not run in working_dir: %q", out)
+	}
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"command": "ls", "working_dir": ".."}); err == nil {
+		t.Error("expected a working_dir outside the project to be rejected")
+	}
+}
+
+func TestExecuteCommandTimeout(t *testing.T) {
+	tool := &ExecuteCommandTool{allowedCommands: []string{"sleep 30"}}
+	result, err := tool.Execute(context.Background(), map[string]interface{}{"command": "sleep 30", "timeout_seconds": 0.1})
+	if err != nil || !result.IsError || !strings.Contains(result.Text(), "timed out") {
+		t.Errorf("expected a timeout to be reported, got %+v, %v", result, err)
+	}
+}
+
+// text returns the text of a tool result, for tests that only check the text.
+func text(result session.ToolResult, err error) (string, error) {
+	return result.Text(), err
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+}
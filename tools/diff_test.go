@@ -0,0 +1,64 @@
+package tools
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	oldText := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newText := "a\nb\nc\nD\ne\nf\ng\nh\ni\nj\nk\n"
+
+	want := `--- f.txt
++++ f.txt
+@@ -1,10 +1,11 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+ h
+ i
+ j
++k
+`
+	if got := UnifiedDiff("f.txt", oldText, newText); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffSeparateHunks(t *testing.T) {
+	oldText := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	newText := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n"
+
+	want := `--- f.txt
++++ f.txt
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++twelve
+`
+	if got := UnifiedDiff("f.txt", oldText, newText); got != want {
+		t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffNewAndDeletedFile(t *testing.T) {
+	if got, want := UnifiedDiff("f.txt", "", "x\n"), "--- f.txt\n+++ f.txt\n@@ -0,0 +1 @@\n+x\n"; got != want {
+		t.Errorf("new file diff = %q, want %q", got, want)
+	}
+	if got, want := UnifiedDiff("f.txt", "x\ny\n", ""), "--- f.txt\n+++ f.txt\n@@ -1,2 +0,0 @@\n-x\n-y\n"; got != want {
+		t.Errorf("deleted file diff = %q, want %q", got, want)
+	}
+	if got := UnifiedDiff("f.txt", "same\n", "same\n"); got != "" {
+		t.Errorf("identical texts gave diff %q", got)
+	}
+}
@@ -0,0 +1,381 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+const (
+	// maxFetchRedirects bounds the redirects followed by fetch_url.
+	maxFetchRedirects = 10
+	// fetchUserAgent identifies compell to web servers.
+	fetchUserAgent = "compell-fetch/1.0"
+	// defaultFetchTimeout and defaultFetchMaxBytes apply when web_fetch sets
+	// no positive timeout or max_bytes.
+	defaultFetchTimeout  = 30 * time.Second
+	defaultFetchMaxBytes = 5 * 1024 * 1024
+)
+
+// FetchURLTool fetches a web page and returns its content, converting HTML to
+// markdown. Requests are checked against the configured domain lists and
+// responses are cached under .compell/cache/fetch.
+type FetchURLTool struct {
+	cfg            *config.WebFetch
+	maxOutputBytes int
+	cacheDir       string
+}
+
+// NewFetchURLTool creates the fetch_url tool.
+func NewFetchURLTool(cfg *config.Config) *FetchURLTool {
+	return &FetchURLTool{
+		cfg:            &cfg.WebFetch,
+		maxOutputBytes: cfg.CommandExecution.MaxOutputBytes,
+		cacheDir:       filepath.Join(".compell", "cache", "fetch"),
+	}
+}
+
+func (t *FetchURLTool) Name() string { return "fetch_url" }
+func (t *FetchURLTool) Description() string {
+	return "Fetches a web page over HTTP(S) and returns its content. HTML is converted to markdown; other text " +
+		"formats such as JSON are returned as is. Long pages are returned in parts: continue with the offset " +
+		"given at the end of the output. Args: url (string), [raw (boolean, return HTML without conversion)], " +
+		"[offset (number, character offset to continue from)]."
+}
+
+func (t *FetchURLTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"url":    argSchema("string", "HTTP(S) URL to fetch"),
+		"raw":    argSchema("boolean", "Return HTML without conversion"),
+		"offset": argSchema("integer", "Character offset to continue from"),
+	}, "url")
+}
+
+// fetchedPage is a fetched and converted page, as stored in the cache.
+type fetchedPage struct {
+	URL         string    `json:"url"`
+	Fetched     time.Time `json:"fetched"`
+	Status      string    `json:"status"`
+	ContentType string    `json:"content_type"`
+	Title       string    `json:"title,omitempty"`
+	Content     string    `json:"content"`
+	Truncated   bool      `json:"truncated,omitempty"` // The response exceeded the size limit
+}
+
+func (t *FetchURLTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	rawURL, ok := args["url"].(string)
+	if !ok || rawURL == "" {
+		return session.ToolResult{}, errors.New("missing or invalid 'url' argument")
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return session.ToolResult{}, errors.New("invalid url '%s': only absolute http and https URLs are supported", rawURL)
+	}
+	u.Fragment = ""
+	if err := t.checkHost(u.Hostname()); err != nil {
+		return session.ToolResult{}, err
+	}
+	raw, _ := args["raw"].(bool)
+	offset := 0
+	if n, ok := args["offset"].(float64); ok && n > 0 {
+		offset = int(n)
+	}
+
+	page := t.cached(u.String(), raw)
+	if page == nil {
+		page, err = t.fetch(ctx, u, raw)
+		if err != nil {
+			return session.ToolResult{}, err
+		}
+		t.store(u.String(), page, raw)
+	}
+	return session.TextResult(t.format(page, offset)), nil
+}
+
+// checkHost applies the domain deny and allow lists to host.
+func (t *FetchURLTool) checkHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchDomain(t.cfg.DeniedDomains, host) {
+		return errors.New("access denied: domain '%s' is denied by web_fetch.denied_domains", host)
+	}
+	if len(t.cfg.AllowedDomains) > 0 && !matchDomain(t.cfg.AllowedDomains, host) {
+		return errors.New("access denied: domain '%s' is not in web_fetch.allowed_domains", host)
+	}
+	return nil
+}
+
+// matchDomain reports whether host is one of the domains or a subdomain of
+// one. Domains may also be glob patterns such as "docs.*.example.com".
+func matchDomain(domains []string, host string) bool {
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+		if ok, err := path.Match(domain, host); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsPrivate reports whether host was explicitly allowed, which is required
+// to fetch from loopback and private network addresses.
+func (t *FetchURLTool) allowsPrivate(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range t.cfg.AllowedDomains {
+		if strings.EqualFold(domain, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeout returns the time limit of a request.
+func (t *FetchURLTool) timeout() time.Duration {
+	if t.cfg.Timeout <= 0 {
+		return defaultFetchTimeout
+	}
+	return t.cfg.Timeout
+}
+
+// maxBytes returns the size beyond which responses are cut off.
+func (t *FetchURLTool) maxBytes() int64 {
+	if t.cfg.MaxBytes <= 0 {
+		return defaultFetchMaxBytes
+	}
+	return t.cfg.MaxBytes
+}
+
+func (t *FetchURLTool) fetch(ctx context.Context, u *url.URL, raw bool) (*fetchedPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout())
+	defer cancel()
+
+	client := &http.Client{
+		// No proxy is used, so that the address check of the dialer applies
+		// to the server itself.
+		Transport: &http.Transport{DialContext: t.dialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return errors.New("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to unsupported URL '%s'", req.URL)
+			}
+			return t.checkHost(req.URL.Hostname())
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid request")
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	req.Header.Set("Accept", "text/html, text/markdown, text/plain, application/json;q=0.9, */*;q=0.5")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New("fetching '%s' timed out after %v", u, t.timeout())
+		}
+		return nil, errors.Wrapf(err, "failed to fetch '%s'", u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, errors.New("fetching '%s' failed: HTTP %s", u, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !isTextMediaType(mediaType) {
+		return nil, errors.New("unsupported content type '%s' at '%s'", mediaType, u)
+	}
+
+	maxBytes := t.maxBytes()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.New("fetching '%s' timed out after %v", u, t.timeout())
+		}
+		return nil, errors.Wrapf(err, "failed to read '%s'", u)
+	}
+	page := &fetchedPage{
+		URL:         resp.Request.URL.String(),
+		Fetched:     time.Now(),
+		Status:      resp.Status,
+		ContentType: mediaType,
+	}
+	if int64(len(body)) > maxBytes {
+		body, page.Truncated = body[:maxBytes], true
+	}
+
+	reader, err := charset.NewReader(bytes.NewReader(body), resp.Header.Get("Content-Type"))
+	if err != nil {
+		// Unknown charsets are read as UTF-8.
+		reader = bytes.NewReader(body)
+	}
+	if !raw && (mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		page.Title, page.Content, err = htmlToMarkdown(reader, resp.Request.URL)
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode '%s'", u)
+	}
+	page.Content = string(data)
+	return page, nil
+}
+
+// dialContext connects to addr, refusing loopback, private and link-local
+// addresses, checked after name resolution, unless the host was explicitly
+// allowed.
+func (t *FetchURLTool) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	allowPrivate := t.allowsPrivate(host)
+	dialer := &net.Dialer{
+		Timeout: t.timeout(),
+		Control: func(network, address string, c syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			ipStr, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(ipStr)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return errors.New("access denied: '%s' resolves to the non-public address %s; add it to web_fetch.allowed_domains to allow it", host, ipStr)
+			}
+			return nil
+		},
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// isTextMediaType reports whether fetch_url can return content of this type.
+func isTextMediaType(mediaType string) bool {
+	switch {
+	case mediaType == "", strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/yaml", "application/toml":
+		return true
+	}
+	return false
+}
+
+// format renders the part of the page starting at offset, cut at the output
+// limit. Offsets count characters, so that parts never split one.
+func (t *FetchURLTool) format(page *fetchedPage, offset int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s\n", page.URL)
+	if page.Title != "" {
+		fmt.Fprintf(&b, "Title: %s\n", page.Title)
+	}
+	b.WriteString("\n")
+
+	content := []rune(page.Content)
+	if offset >= len(content) {
+		fmt.Fprintf(&b, "[No content at offset %d; the content is %d characters long.]", offset, len(content))
+		return b.String()
+	}
+	content = content[offset:]
+	limit := t.maxOutputBytes
+	if limit <= 0 {
+		limit = defaultMaxCommandOutput
+	}
+	// The limit is in bytes: find the last character that fits.
+	end, size := 0, 0
+	for end < len(content) && size+utf8.RuneLen(content[end]) <= limit {
+		size += utf8.RuneLen(content[end])
+		end++
+	}
+	if end < len(content) {
+		// Cut at a line break when there is one near the limit.
+		for i := end - 1; i > end/2; i-- {
+			if content[i] == '\n' {
+				end = i + 1
+				break
+			}
+		}
+		b.WriteString(string(content[:end]))
+		fmt.Fprintf(&b, "\n[Content truncated: showing characters %d-%d of %d. Call fetch_url again with offset %d to continue.]",
+			offset, offset+end, offset+len(content), offset+end)
+		return b.String()
+	}
+	b.WriteString(string(content))
+	if page.Truncated {
+		fmt.Fprintf(&b, "\n[The response exceeded the size limit of %d bytes and was cut off.]", t.maxBytes())
+	}
+	return b.String()
+}
+
+// cachePath returns the cache file of a URL.
+func (t *FetchURLTool) cachePath(rawURL string, raw bool) string {
+	key := rawURL
+	if raw {
+		key = "raw:" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(t.cacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// cached returns the cached page of a URL, or nil if there is no fresh one.
+func (t *FetchURLTool) cached(rawURL string, raw bool) *fetchedPage {
+	if t.cfg.CacheTTL <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(t.cachePath(rawURL, raw))
+	if err != nil {
+		return nil
+	}
+	var page fetchedPage
+	if err := json.Unmarshal(data, &page); err != nil || time.Since(page.Fetched) > t.cfg.CacheTTL {
+		return nil
+	}
+	return &page
+}
+
+// store caches a page. Failures only cost a refetch, so they are ignored.
+func (t *FetchURLTool) store(rawURL string, page *fetchedPage, raw bool) {
+	if t.cfg.CacheTTL <= 0 {
+		return
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(t.cacheDir, 0755); err != nil {
+		return
+	}
+	os.WriteFile(t.cachePath(rawURL, raw), data, 0644)
+}
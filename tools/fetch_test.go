@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/m4xw311/compell/config"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><title>API  Guide</title><script>var x = 1;</script></head>
+<body><nav><a href="/">Home</a></nav>
+<h1>Client <em>API</em></h1>
+<p>Call <code>Open</code> first, then read the <a href="/docs/read.html">reading guide</a>.</p>
+<ul><li>Fast</li><li>Safe<ol><li>Really</li></ol></li></ul>
+<pre><code class="language-go">func main() {
+	fmt.Println("hi")
+}</code></pre>
+<table><tr><th>Name</th><th>Type</th></tr><tr><td>id</td><td>string</td></tr></table>
+<footer>Copyright</footer>
+</body></html>`
+
+func newFetchTool(t *testing.T, cfg config.WebFetch) *FetchURLTool {
+	t.Helper()
+	chdir(t, t.TempDir())
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	return NewFetchURLTool(&config.Config{WebFetch: cfg})
+}
+
+func TestFetchURLConvertsHTML(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	}))
+	defer srv.Close()
+
+	tool := newFetchTool(t, config.WebFetch{AllowedDomains: []string{"127.0.0.1"}, CacheTTL: time.Minute})
+	out, err := text(tool.Execute(context.Background(), map[string]interface{}{"url": srv.URL + "/guide"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Title: API Guide",
+		"# Client _API_",
+		"Call `Open` first, then read the [reading guide](" + srv.URL + "/docs/read.html).",
+		"- Fast\n- Safe\n  1. Really",
+		"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```",
+		"| Name | Type |\n| --- | --- |\n| id | string |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"var x", "Home", "Copyright"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, out)
+		}
+	}
+
+	// The second fetch is served from the cache.
+	if again, err := text(tool.Execute(context.Background(), map[string]interface{}{"url": srv.URL + "/guide"})); err != nil || again != out {
+		t.Errorf("cached fetch = %q, %v", again, err)
+	}
+	if requests != 1 {
+		t.Errorf("server got %d requests, want 1", requests)
+	}
+}
+
+func TestFetchURLLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, strings.Repeat("line of text\n", 1000))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/missing":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, "http://denied.example.com/", http.StatusFound)
+		case "/accents":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, strings.Repeat("é", 1000))
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	tool := newFetchTool(t, config.WebFetch{AllowedDomains: []string{"127.0.0.1"}, MaxBytes: 2000, Timeout: 100 * time.Millisecond})
+	out, err := text(tool.Execute(ctx, map[string]interface{}{"url": srv.URL + "/big"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "cut off") || strings.Count(out, "line of text") > 154 {
+		t.Errorf("response not limited:\n%s", out)
+	}
+
+	for _, path := range []string{"/slow", "/image", "/missing", "/redirect"} {
+		if _, err := tool.Execute(ctx, map[string]interface{}{"url": srv.URL + path}); err == nil {
+			t.Errorf("expected an error for %s", path)
+		}
+	}
+
+	// Output beyond the output limit is paged with offsets.
+	tool.maxOutputBytes = 1000
+	first, err := text(tool.Execute(ctx, map[string]interface{}{"url": srv.URL + "/big"}))
+	if err != nil || !strings.Contains(first, "offset 988 ") {
+		t.Fatalf("first part = %q, %v", first, err)
+	}
+	second, err := text(tool.Execute(ctx, map[string]interface{}{"url": srv.URL + "/big", "offset": 988.0}))
+	if err != nil || !strings.Contains(second, "characters 988-1976 of 2000") {
+		t.Errorf("second part = %q, %v", second, err)
+	}
+
+	// Parts never split a character, and offsets count characters.
+	tool.maxOutputBytes = 1001
+	for _, offset := range []float64{0, 1} {
+		part, err := text(tool.Execute(ctx, map[string]interface{}{"url": srv.URL + "/accents", "offset": offset}))
+		if err != nil || !utf8.ValidString(part) || strings.Count(part, "é") != 500 {
+			t.Errorf("part at offset %v = %q, %v", offset, part, err)
+		}
+	}
+
+	// Limits that are not positive fall back to the defaults.
+	tool = NewFetchURLTool(&config.Config{WebFetch: config.WebFetch{AllowedDomains: []string{"127.0.0.1"}, MaxBytes: -1, Timeout: -1}})
+	if out, err := text(tool.Execute(ctx, map[string]interface{}{"url": srv.URL + "/big"})); err != nil || strings.Contains(out, "cut off") {
+		t.Errorf("fetch with max_bytes -1 = %q, %v", out, err)
+	}
+}
+
+func TestFetchURLDomainRules(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secret")
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// Local addresses must be allowed explicitly.
+	tool := newFetchTool(t, config.WebFetch{})
+	if _, err := tool.Execute(ctx, map[string]interface{}{"url": srv.URL}); err == nil || !strings.Contains(err.Error(), "non-public") {
+		t.Errorf("expected a loopback address to be refused, got %v", err)
+	}
+
+	tool = newFetchTool(t, config.WebFetch{AllowedDomains: []string{"example.com"}, DeniedDomains: []string{"private.example.com"}})
+	for rawURL, allowed := range map[string]bool{
+		"https://example.com/a":          true,
+		"https://docs.example.com/a":     true,
+		"https://private.example.com/a":  false,
+		"https://x.private.example.com/": false,
+		"https://example.org/":           false,
+		"ftp://example.com/":             false,
+	} {
+		u, _ := url.Parse(rawURL)
+		err := tool.checkHost(u.Hostname())
+		if u.Scheme == "ftp" {
+			_, err = tool.Execute(ctx, map[string]interface{}{"url": rawURL})
+		}
+		if (err == nil) != allowed {
+			t.Errorf("%s: allowed = %t, want %t (%v)", rawURL, err == nil, allowed, err)
+		}
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/m4xw311/compell/checkpoint"
 	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 )
 
 // ReadFileTool implements the tool for reading a file.
@@ -20,25 +24,31 @@ func (t *ReadFileTool) Description() string {
 	return "Reads the entire content of a file. Args: path (string)."
 }
 
-func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *ReadFileTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path": argSchema("string", "Path relative to the project root"),
+	}, "path")
+}
+
+func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, ok := args["path"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'path' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	content, err := os.ReadFile(path)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to read file '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to read file '%s'", path)
 	}
-	return string(content), nil
+	return session.TextResult(string(content)), nil
 }
 
 // ReadDirTool implements the tool for reading directory contents.
@@ -51,23 +61,29 @@ func (t *ReadDirTool) Description() string {
 	return "Reads the contents of a directory, returning a list of file and directory names. Args: path (string)."
 }
 
-func (t *ReadDirTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *ReadDirTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path": argSchema("string", "Path relative to the project root"),
+	}, "path")
+}
+
+func (t *ReadDirTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, ok := args["path"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'path' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	entries, err := os.ReadDir(path)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to read directory '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to read directory '%s'", path)
 	}
 
 	var names []string
@@ -79,7 +95,7 @@ func (t *ReadDirTool) Execute(ctx context.Context, args map[string]interface{})
 		names = append(names, name)
 	}
 
-	return strings.Join(names, "\n"), nil
+	return session.TextResult(strings.Join(names, "\n")), nil
 }
 
 // WriteFileTool implements the tool for writing to a file.
@@ -92,27 +108,40 @@ func (t *WriteFileTool) Description() string {
 	return "Writes content to a file. Overwrites the file unless optional `start_line` and `end_line` are provided to replace a specific range. Args: path (string), content (string), [start_line (int)], [end_line (int)]."
 }
 
-func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *WriteFileTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path":       argSchema("string", "Path relative to the project root"),
+		"content":    argSchema("string", "Content to write"),
+		"start_line": argSchema("integer", "First line to replace, starting at 1"),
+		"end_line":   argSchema("integer", "Last line to replace"),
+	}, "path", "content")
+}
+
+func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, pathOk := args["path"].(string)
 	content, contentOk := args["content"].(string)
 	if !pathOk || !contentOk {
-		return "", errors.New("missing or invalid 'path' or 'content' arguments")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' or 'content' arguments")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	readOnly, err := isPathRestricted(path, t.fsAccess.ReadOnly)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if readOnly {
-		return "", errors.New("access denied: path '%s' is read-only", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is read-only", path)
+	}
+
+	if err := checkpoint.Record(ctx, path); err != nil {
+		return session.ToolResult{}, err
 	}
 
 	startLineRaw, startOk := args["start_line"]
@@ -122,16 +151,16 @@ func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}
 	if startOk || endOk {
 		// Both must be provided for a partial write.
 		if !(startOk && endOk) {
-			return "", errors.New("for partial write, both 'start_line' and 'end_line' must be provided")
+			return session.ToolResult{}, errors.New("for partial write, both 'start_line' and 'end_line' must be provided")
 		}
 
 		start, ok := startLineRaw.(float64)
 		if !ok {
-			return "", errors.New("invalid 'start_line' argument: must be a number")
+			return session.ToolResult{}, errors.New("invalid 'start_line' argument: must be a number")
 		}
 		end, ok := endLineRaw.(float64)
 		if !ok {
-			return "", errors.New("invalid 'end_line' argument: must be a number")
+			return session.ToolResult{}, errors.New("invalid 'end_line' argument: must be a number")
 		}
 		return t.executePartialWrite(path, content, int(start), int(end))
 	}
@@ -139,12 +168,32 @@ func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}
 	// Otherwise, perform a full overwrite.
 	err = os.WriteFile(path, []byte(content), 0644)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to write to file '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to write to file '%s'", path)
 	}
-	return fmt.Sprintf("Successfully wrote %d bytes to %s", len(content), path), nil
+	result := session.TextResult(fmt.Sprintf("Successfully wrote %d bytes to %s", len(content), path))
+	result.Metadata.BytesWritten = int64(len(content))
+	return result, nil
+}
+
+func (t *WriteFileTool) executePartialWrite(path, newContent string, startLine, endLine int) (session.ToolResult, error) {
+	output, err := partialWriteContent(path, newContent, startLine, endLine)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+
+	err = os.WriteFile(path, []byte(output), 0644)
+	if err != nil {
+		return session.ToolResult{}, errors.Wrapf(err, "failed to write updated content to file '%s'", path)
+	}
+
+	result := session.TextResult(fmt.Sprintf("Successfully replaced lines %d-%d in %s", startLine, endLine, path))
+	result.Metadata.BytesWritten = int64(len(output))
+	return result, nil
 }
 
-func (t *WriteFileTool) executePartialWrite(path, newContent string, startLine, endLine int) (string, error) {
+// partialWriteContent returns the content of the file at path with lines
+// startLine to endLine (1-based, inclusive) replaced by newContent.
+func partialWriteContent(path, newContent string, startLine, endLine int) (string, error) {
 	if startLine <= 0 || endLine < startLine {
 		return "", errors.New("invalid line numbers: start_line must be >= 1 and end_line must be >= start_line")
 	}
@@ -175,13 +224,49 @@ func (t *WriteFileTool) executePartialWrite(path, newContent string, startLine,
 	// Lines after the end.
 	newLines = append(newLines, lines[endLine:]...)
 
-	output := strings.Join(newLines, "\n")
-	err = os.WriteFile(path, []byte(output), 0644)
-	if err != nil {
-		return "", errors.Wrapf(err, "failed to write updated content to file '%s'", path)
+	return strings.Join(newLines, "\n"), nil
+}
+
+// Preview returns a unified diff of the change the write would make.
+func (t *WriteFileTool) Preview(args map[string]interface{}) (string, error) {
+	path, pathOk := args["path"].(string)
+	content, contentOk := args["content"].(string)
+	if !pathOk || !contentOk {
+		return "", errors.New("missing or invalid 'path' or 'content' arguments")
+	}
+	if err := checkWritable(path, t.fsAccess); err != nil {
+		return "", err
+	}
+
+	oldContent, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", errors.Wrapf(err, "failed to read file '%s'", path)
 	}
 
-	return fmt.Sprintf("Successfully replaced lines %d-%d in %s", startLine, endLine, path), nil
+	newContent := content
+	if start, ok := args["start_line"].(float64); ok {
+		end, ok := args["end_line"].(float64)
+		if !ok {
+			return "", errors.New("invalid 'end_line' argument: must be a number")
+		}
+		newContent, err = partialWriteContent(path, content, int(start), int(end))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	diff := UnifiedDiff(path, string(oldContent), newContent)
+	if diff == "" {
+		return fmt.Sprintf("No changes to %s", path), nil
+	}
+	if oldContent == nil {
+		return fmt.Sprintf("New file %s\n%s", path, diff), nil
+	}
+	return diff, nil
+}
+
+func (t *WriteFileTool) PreviewedArgs() []string {
+	return []string{"path", "content", "start_line", "end_line"}
 }
 
 // CreateDirTool implements the tool for creating a directory.
@@ -194,33 +279,43 @@ func (t *CreateDirTool) Description() string {
 	return "Creates a new directory. Args: path (string)."
 }
 
-func (t *CreateDirTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *CreateDirTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path": argSchema("string", "Path relative to the project root"),
+	}, "path")
+}
+
+func (t *CreateDirTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, ok := args["path"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'path' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	readOnly, err := isPathRestricted(path, t.fsAccess.ReadOnly)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if readOnly {
-		return "", errors.New("access denied: path '%s' is read-only", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is read-only", path)
+	}
+
+	if err := recordMissingDirs(ctx, path); err != nil {
+		return session.ToolResult{}, err
 	}
 
 	err = os.MkdirAll(path, 0755)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to create directory '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to create directory '%s'", path)
 	}
-	return fmt.Sprintf("Successfully created directory %s", path), nil
+	return session.TextResult(fmt.Sprintf("Successfully created directory %s", path)), nil
 }
 
 // DeleteFileTool implements the tool for deleting a file.
@@ -233,35 +328,64 @@ func (t *DeleteFileTool) Description() string {
 	return "Deletes a file. Args: path (string)."
 }
 
-func (t *DeleteFileTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *DeleteFileTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path": argSchema("string", "Path relative to the project root"),
+	}, "path")
+}
+
+func (t *DeleteFileTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, ok := args["path"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'path' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	readOnly, err := isPathRestricted(path, t.fsAccess.ReadOnly)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if readOnly {
-		return "", errors.New("access denied: path '%s' is read-only", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is read-only", path)
+	}
+
+	if err := checkpoint.Record(ctx, path); err != nil {
+		return session.ToolResult{}, err
 	}
 
 	err = os.Remove(path)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to delete file '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to delete file '%s'", path)
+	}
+	return session.TextResult(fmt.Sprintf("Successfully deleted file %s", path)), nil
+}
+
+// Preview returns a unified diff removing the file's entire content.
+func (t *DeleteFileTool) Preview(args map[string]interface{}) (string, error) {
+	path, ok := args["path"].(string)
+	if !ok {
+		return "", errors.New("missing or invalid 'path' argument")
+	}
+	if err := checkWritable(path, t.fsAccess); err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read file '%s'", path)
 	}
-	return fmt.Sprintf("Successfully deleted file %s", path), nil
+	return fmt.Sprintf("Delete file %s\n%s", path, UnifiedDiff(path, string(content), "")), nil
 }
 
+func (t *DeleteFileTool) PreviewedArgs() []string { return []string{"path"} }
+
 // DeleteDirTool implements the tool for deleting a directory.
 type DeleteDirTool struct {
 	fsAccess *config.FilesystemAccess
@@ -272,32 +396,83 @@ func (t *DeleteDirTool) Description() string {
 	return "Deletes an empty directory. Args: path (string)."
 }
 
-func (t *DeleteDirTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+func (t *DeleteDirTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path": argSchema("string", "Path relative to the project root"),
+	}, "path")
+}
+
+func (t *DeleteDirTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
 	path, ok := args["path"].(string)
 	if !ok {
-		return "", errors.New("missing or invalid 'path' argument")
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
 	}
 
 	hidden, err := isPathRestricted(path, t.fsAccess.Hidden)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if hidden {
-		return "", errors.New("access denied: path '%s' is hidden", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is hidden", path)
 	}
 
 	readOnly, err := isPathRestricted(path, t.fsAccess.ReadOnly)
 	if err != nil {
-		return "", err
+		return session.ToolResult{}, err
 	}
 	if readOnly {
-		return "", errors.New("access denied: path '%s' is read-only", path)
+		return session.ToolResult{}, errors.New("access denied: path '%s' is read-only", path)
+	}
+
+	if err := checkpoint.Record(ctx, path); err != nil {
+		return session.ToolResult{}, err
 	}
 
 	// os.Remove will fail on a non-empty directory, which is the desired behavior.
 	err = os.Remove(path)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to delete directory '%s'", path)
+		return session.ToolResult{}, errors.Wrapf(err, "failed to delete directory '%s'", path)
+	}
+	return session.TextResult(fmt.Sprintf("Successfully deleted directory %s", path)), nil
+}
+
+// recordMissingDirs checkpoints path and each of its missing ancestors,
+// outermost first, so that undoing a MkdirAll removes every directory it made.
+func recordMissingDirs(ctx context.Context, path string) error {
+	var missing []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := checkpoint.Record(ctx, missing[i]); err != nil {
+			return err
+		}
+	}
+	return checkpoint.Record(ctx, path)
+}
+
+// checkWritable returns an error if path is hidden or read-only.
+func checkWritable(path string, fsAccess *config.FilesystemAccess) error {
+	hidden, err := isPathRestricted(path, fsAccess.Hidden)
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return errors.New("access denied: path '%s' is hidden", path)
+	}
+
+	readOnly, err := isPathRestricted(path, fsAccess.ReadOnly)
+	if err != nil {
+		return err
+	}
+	if readOnly {
+		return errors.New("access denied: path '%s' is read-only", path)
 	}
-	return fmt.Sprintf("Successfully deleted directory %s", path), nil
+	return nil
 }
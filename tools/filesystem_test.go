@@ -1,8 +1,49 @@
 package tools
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+)
 
 func TestFilesystem(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("Filesystem test not yet implemented.")
 }
+
+func TestWriteFilePreview(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool := &WriteFileTool{fsAccess: &config.FilesystemAccess{ReadOnly: []string{"**/locked.txt"}}}
+
+	preview, err := tool.Preview(map[string]interface{}{
+		"path":       path,
+		"content":    "TWO",
+		"start_line": float64(2),
+		"end_line":   float64(2),
+	})
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	if !strings.Contains(preview, "-two\n+TWO\n") {
+		t.Errorf("preview does not show the replaced line:\n%s", preview)
+	}
+
+	preview, err = tool.Preview(map[string]interface{}{"path": filepath.Join(dir, "new.txt"), "content": "hello\n"})
+	if err != nil {
+		t.Fatalf("Preview: %v", err)
+	}
+	if !strings.HasPrefix(preview, "New file") || !strings.Contains(preview, "+hello") {
+		t.Errorf("unexpected preview for a new file:\n%s", preview)
+	}
+
+	if _, err := tool.Preview(map[string]interface{}{"path": filepath.Join(dir, "locked.txt"), "content": "x"}); err == nil {
+		t.Error("expected preview of a read-only path to fail")
+	}
+}
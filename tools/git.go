@@ -0,0 +1,494 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/secrets"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/sysproc"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+const (
+	// gitTimeout bounds every git invocation of the git tools.
+	gitTimeout = 30 * time.Second
+	// defaultGitLogCount and maxGitLogCount bound the commits listed by git_log.
+	defaultGitLogCount = 20
+	maxGitLogCount     = 200
+)
+
+// gitRunner runs git on behalf of the git tools. Paths matching the hidden
+// patterns are excluded from every command through git pathspecs.
+type gitRunner struct {
+	fsAccess       *config.FilesystemAccess
+	maxOutputBytes int
+}
+
+// run executes git with args and returns its standard output, truncated like
+// execute_command output. A non-zero exit is returned as an error carrying
+// git's standard error.
+func (g *gitRunner) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+
+	global := []string{"--no-pager", "-c", "core.quotepath=off", "-c", "color.ui=never"}
+	cmd := exec.CommandContext(ctx, "git", append(global, args...)...)
+	cmd.Env = append(secrets.FilterEnv(os.Environ(), config.EnvFilter{}), "GIT_TERMINAL_PROMPT=0", "GIT_OPTIONAL_LOCKS=0")
+	sysproc.KillOnCancel(cmd)
+	cmd.WaitDelay = commandWaitDelay
+
+	limit := g.maxOutputBytes
+	if limit <= 0 {
+		limit = defaultMaxCommandOutput
+	}
+	stdout := newHeadTailBuffer(limit)
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", errors.New("git %s timed out or was cancelled", args[0])
+		}
+		return "", errors.New("git %s failed: %v\n%s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// pathspec returns the "--" separated pathspec limiting a command to paths,
+// or to the whole tree when paths is empty, minus the hidden paths.
+func (g *gitRunner) pathspec(paths []string) ([]string, error) {
+	spec := []string{"--"}
+	for _, path := range paths {
+		if err := g.checkPath(path); err != nil {
+			return nil, err
+		}
+		spec = append(spec, path)
+	}
+	if len(paths) == 0 {
+		spec = append(spec, ".")
+	}
+	for _, pattern := range g.fsAccess.Hidden {
+		spec = append(spec, ":(exclude,glob)"+pattern)
+	}
+	return spec, nil
+}
+
+// checkPath rejects hidden paths and paths that git could take for options.
+func (g *gitRunner) checkPath(path string) error {
+	if path == "" || strings.HasPrefix(path, "-") {
+		return errors.New("invalid path '%s'", path)
+	}
+	hidden, err := isPathRestricted(path, g.fsAccess.Hidden)
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return errors.New("access denied: path '%s' is hidden", path)
+	}
+	return nil
+}
+
+// checkRev rejects revisions that git could take for options, and
+// "<rev>:<path>" revisions naming hidden paths.
+func (g *gitRunner) checkRev(rev string) error {
+	if rev == "" || strings.HasPrefix(rev, "-") || strings.ContainsAny(rev, " \t\n") {
+		return errors.New("invalid revision '%s'", rev)
+	}
+	if _, path, ok := strings.Cut(rev, ":"); ok && path != "" {
+		return g.checkPath(strings.TrimPrefix(path, "./"))
+	}
+	return nil
+}
+
+// textResult wraps the output of a git command as a tool result.
+func textResult(out string, err error) (session.ToolResult, error) {
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	return session.TextResult(out), nil
+}
+
+// stringListArg returns a list of strings argument, also accepting a single string.
+func stringListArg(args map[string]interface{}, name string) ([]string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("invalid '%s' argument: must be a list of strings", name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, errors.New("invalid '%s' argument: must be a list of strings", name)
+	}
+}
+
+// GitStatusTool shows the working tree status.
+type GitStatusTool struct{ git *gitRunner }
+
+func (t *GitStatusTool) Name() string { return "git_status" }
+func (t *GitStatusTool) Description() string {
+	return "Shows the current branch and the changed, staged and untracked files in short format " +
+		"(XY path, where X is the staged and Y the unstaged status). Args: none."
+}
+
+func (t *GitStatusTool) Schema() *jsonschema.Schema {
+	return objectSchema(nil)
+}
+
+func (t *GitStatusTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	spec, err := t.git.pathspec(nil)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	out, err := t.git.run(ctx, append([]string{"status", "--short", "--branch", "--untracked-files=normal"}, spec...)...)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if strings.Count(strings.TrimSpace(out), "\n") == 0 {
+		out = strings.TrimSpace(out) + "\nWorking tree clean."
+	}
+	return session.TextResult(out), nil
+}
+
+// GitDiffTool shows changes in the working tree, the index or between revisions.
+type GitDiffTool struct{ git *gitRunner }
+
+func (t *GitDiffTool) Name() string { return "git_diff" }
+func (t *GitDiffTool) Description() string {
+	return "Shows a unified diff of unstaged changes, or of staged changes with staged set to true. " +
+		"With ref, compares the working tree (or the index when staged) against that revision. " +
+		"Args: [staged (boolean)], [ref (string)], [paths (list of strings)], [stat (boolean, only list changed files)]."
+}
+
+func (t *GitDiffTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"staged": argSchema("boolean", "Diff staged changes"),
+		"ref":    argSchema("string", "Revision to compare against"),
+		"paths":  stringListSchema("Paths to limit the diff to"),
+		"stat":   argSchema("boolean", "Only list changed files"),
+	})
+}
+
+func (t *GitDiffTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	paths, err := stringListArg(args, "paths")
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	gitArgs := []string{"diff"}
+	if staged, _ := args["staged"].(bool); staged {
+		gitArgs = append(gitArgs, "--cached")
+	}
+	if stat, _ := args["stat"].(bool); stat {
+		gitArgs = append(gitArgs, "--stat")
+	}
+	if ref, _ := args["ref"].(string); ref != "" {
+		if err := t.git.checkRev(ref); err != nil {
+			return session.ToolResult{}, err
+		}
+		gitArgs = append(gitArgs, ref)
+	}
+	spec, err := t.git.pathspec(paths)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	out, err := t.git.run(ctx, append(gitArgs, spec...)...)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if out == "" {
+		return session.TextResult("No changes."), nil
+	}
+	return session.TextResult(out), nil
+}
+
+// GitLogTool lists commits.
+type GitLogTool struct{ git *gitRunner }
+
+func (t *GitLogTool) Name() string { return "git_log" }
+func (t *GitLogTool) Description() string {
+	return "Lists commits, newest first, one per line: short hash, date, author, refs and subject. " +
+		fmt.Sprintf("Args: [max_count (number, default %d)], [ref (string)], [path (string)], [stat (boolean, also list changed files)].", defaultGitLogCount)
+}
+
+func (t *GitLogTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"max_count": argSchema("integer", fmt.Sprintf("Number of commits, default %d", defaultGitLogCount)),
+		"ref":       argSchema("string", "Revision to start from"),
+		"path":      argSchema("string", "Only list commits changing this path"),
+		"stat":      argSchema("boolean", "Also list changed files"),
+	})
+}
+
+func (t *GitLogTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	count := defaultGitLogCount
+	if n, ok := args["max_count"].(float64); ok && n > 0 {
+		count = min(int(n), maxGitLogCount)
+	}
+	gitArgs := []string{"log", "-n", strconv.Itoa(count), "--date=short", "--format=%h %ad %an%d %s"}
+	if stat, _ := args["stat"].(bool); stat {
+		gitArgs = append(gitArgs, "--stat")
+	}
+	if ref, _ := args["ref"].(string); ref != "" {
+		if err := t.git.checkRev(ref); err != nil {
+			return session.ToolResult{}, err
+		}
+		gitArgs = append(gitArgs, ref)
+	}
+	paths, err := stringListArg(args, "path")
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	spec, err := t.git.pathspec(paths)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	out, err := t.git.run(ctx, append(gitArgs, spec...)...)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if out == "" {
+		return session.TextResult("No commits."), nil
+	}
+	return session.TextResult(out), nil
+}
+
+// GitShowTool shows a commit or the content of a file at a revision.
+type GitShowTool struct{ git *gitRunner }
+
+func (t *GitShowTool) Name() string { return "git_show" }
+func (t *GitShowTool) Description() string {
+	return "Shows a commit's message and diff, or a file's content at a revision with rev set to <rev>:<path>. " +
+		"Args: [rev (string, default HEAD)], [stat (boolean, list changed files instead of the diff)]."
+}
+
+func (t *GitShowTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"rev":  argSchema("string", "Revision, or <rev>:<path> for a file, default HEAD"),
+		"stat": argSchema("boolean", "List changed files instead of the diff"),
+	})
+}
+
+func (t *GitShowTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	rev, _ := args["rev"].(string)
+	if rev == "" {
+		rev = "HEAD"
+	}
+	if err := t.git.checkRev(rev); err != nil {
+		return session.ToolResult{}, err
+	}
+	if strings.Contains(rev, ":") {
+		return textResult(t.git.run(ctx, "show", rev))
+	}
+
+	gitArgs := []string{"show", "--date=short", "--format=commit %H%nAuthor: %an <%ae>%nDate: %ad%n%n%B"}
+	if stat, _ := args["stat"].(bool); stat {
+		gitArgs = append(gitArgs, "--stat")
+	}
+	spec, err := t.git.pathspec(nil)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	return textResult(t.git.run(ctx, append(append(gitArgs, rev), spec...)...))
+}
+
+// GitBlameTool shows who last changed each line of a file.
+type GitBlameTool struct{ git *gitRunner }
+
+func (t *GitBlameTool) Name() string { return "git_blame" }
+func (t *GitBlameTool) Description() string {
+	return "Shows the commit, author and date that last changed each line of a file. " +
+		"Args: path (string), [start_line (number)], [end_line (number)], [rev (string)]."
+}
+
+func (t *GitBlameTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"path":       argSchema("string", "Path relative to the project root"),
+		"start_line": argSchema("integer", "First line"),
+		"end_line":   argSchema("integer", "Last line"),
+		"rev":        argSchema("string", "Revision"),
+	}, "path")
+}
+
+func (t *GitBlameTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	path, ok := args["path"].(string)
+	if !ok {
+		return session.ToolResult{}, errors.New("missing or invalid 'path' argument")
+	}
+	if err := t.git.checkPath(path); err != nil {
+		return session.ToolResult{}, err
+	}
+	gitArgs := []string{"blame", "--date=short"}
+	start, hasStart := args["start_line"].(float64)
+	end, hasEnd := args["end_line"].(float64)
+	switch {
+	case hasStart && hasEnd:
+		gitArgs = append(gitArgs, "-L", fmt.Sprintf("%d,%d", int(start), int(end)))
+	case hasStart:
+		gitArgs = append(gitArgs, "-L", fmt.Sprintf("%d,", int(start)))
+	case hasEnd:
+		gitArgs = append(gitArgs, "-L", fmt.Sprintf("1,%d", int(end)))
+	}
+	if rev, _ := args["rev"].(string); rev != "" {
+		if err := t.git.checkRev(rev); err != nil {
+			return session.ToolResult{}, err
+		}
+		gitArgs = append(gitArgs, rev)
+	}
+	return textResult(t.git.run(ctx, append(gitArgs, "--", path)...))
+}
+
+// GitCommitTool records a commit. It asks for approval by default.
+type GitCommitTool struct{ git *gitRunner }
+
+func (t *GitCommitTool) Name() string { return "git_commit" }
+func (t *GitCommitTool) Description() string {
+	return "Commits the staged changes. With paths, stages those paths first; with all set to true, stages every change " +
+		"(except hidden paths) first. Args: message (string), [paths (list of strings)], [all (boolean)]."
+}
+
+func (t *GitCommitTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"message": argSchema("string", "Commit message"),
+		"paths":   stringListSchema("Paths to stage first"),
+		"all":     argSchema("boolean", "Stage every change first"),
+	}, "message")
+}
+
+func (t *GitCommitTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	message, ok := args["message"].(string)
+	if !ok || strings.TrimSpace(message) == "" {
+		return session.ToolResult{}, errors.New("missing or invalid 'message' argument")
+	}
+	paths, err := stringListArg(args, "paths")
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	all, _ := args["all"].(bool)
+	if len(paths) > 0 || all {
+		spec, err := t.git.pathspec(paths)
+		if err != nil {
+			return session.ToolResult{}, err
+		}
+		if _, err := t.git.run(ctx, append([]string{"add", "--all"}, spec...)...); err != nil {
+			return session.ToolResult{}, err
+		}
+	}
+	if _, err := t.git.run(ctx, "commit", "--quiet", "-m", message); err != nil {
+		return session.ToolResult{}, err
+	}
+	return textResult(t.git.run(ctx, "show", "--stat", "--format=Committed %h: %s", "HEAD"))
+}
+
+// Preview shows the commit message and what would be committed.
+func (t *GitCommitTool) Preview(args map[string]interface{}) (string, error) {
+	message, _ := args["message"].(string)
+	paths, err := stringListArg(args, "paths")
+	if err != nil {
+		return "", err
+	}
+	all, _ := args["all"].(bool)
+
+	var changes string
+	switch {
+	case all:
+		changes = "all changes (except hidden paths)"
+	case len(paths) > 0:
+		changes = strings.Join(paths, ", ") + " and the staged changes"
+	default:
+		changes, err = t.git.run(context.Background(), "diff", "--cached", "--stat")
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Commit message:\n%s\n\nCommitting: %s", message, strings.TrimSpace(changes)), nil
+}
+
+func (t *GitCommitTool) PreviewedArgs() []string { return []string{"message", "paths", "all"} }
+
+// GitBranchTool lists, creates and switches branches. It asks for approval
+// by default.
+type GitBranchTool struct{ git *gitRunner }
+
+func (t *GitBranchTool) Name() string { return "git_branch" }
+func (t *GitBranchTool) Description() string {
+	return "Lists branches when no name is given. Otherwise creates the branch, from start_point if given, and switches " +
+		"to it with checkout set to true (switching to an existing branch if it already exists). Switching branches " +
+		"changes files, which cannot be undone with /undo. " +
+		"Args: [name (string)], [start_point (string)], [checkout (boolean)]."
+}
+
+func (t *GitBranchTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"name":        argSchema("string", "Branch to create; lists branches if omitted"),
+		"start_point": argSchema("string", "Revision to create the branch from"),
+		"checkout":    argSchema("boolean", "Switch to the branch"),
+	})
+}
+
+func (t *GitBranchTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	name, _ := args["name"].(string)
+	if name == "" {
+		return textResult(t.git.run(ctx, "branch", "--list", "-v"))
+	}
+	if _, err := t.git.run(ctx, "check-ref-format", "--branch", name); err != nil || strings.HasPrefix(name, "-") {
+		return session.ToolResult{}, errors.New("invalid branch name '%s'", name)
+	}
+	startPoint, _ := args["start_point"].(string)
+	if startPoint != "" {
+		if err := t.git.checkRev(startPoint); err != nil {
+			return session.ToolResult{}, err
+		}
+	}
+	checkout, _ := args["checkout"].(bool)
+
+	_, err := t.git.run(ctx, "rev-parse", "--verify", "--quiet", "refs/heads/"+name)
+	exists := err == nil
+	switch {
+	case exists && !checkout:
+		return session.ToolResult{}, errors.New("branch '%s' already exists", name)
+	case exists:
+		if _, err := t.git.run(ctx, "switch", "--quiet", name); err != nil {
+			return session.ToolResult{}, err
+		}
+		return session.TextResult(fmt.Sprintf("Switched to existing branch '%s'.", name)), nil
+	case checkout:
+		gitArgs := []string{"switch", "--quiet", "-c", name}
+		if startPoint != "" {
+			gitArgs = append(gitArgs, startPoint)
+		}
+		if _, err := t.git.run(ctx, gitArgs...); err != nil {
+			return session.ToolResult{}, err
+		}
+		return session.TextResult(fmt.Sprintf("Created and switched to branch '%s'.", name)), nil
+	default:
+		gitArgs := []string{"branch", name}
+		if startPoint != "" {
+			gitArgs = append(gitArgs, startPoint)
+		}
+		if _, err := t.git.run(ctx, gitArgs...); err != nil {
+			return session.ToolResult{}, err
+		}
+		return session.TextResult(fmt.Sprintf("Created branch '%s'.", name)), nil
+	}
+}
@@ -0,0 +1,118 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+)
+
+// newGitRepo creates a repository with one commit in a temporary directory
+// and makes it the working directory.
+func newGitRepo(t *testing.T) *gitRunner {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	chdir(t, t.TempDir())
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	for _, args := range [][]string{{"init", "--quiet", "-b", "main"}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	os.WriteFile("main.go", []byte("package main\n"), 0644)
+	os.WriteFile(".env", []byte("SECRET=1\n"), 0644)
+	git := &gitRunner{fsAccess: &config.FilesystemAccess{Hidden: []string{".env"}}}
+	if _, err := (&GitCommitTool{git: git}).Execute(context.Background(), map[string]interface{}{"message": "Initial commit", "all": true}); err != nil {
+		t.Fatal(err)
+	}
+	return git
+}
+
+func TestGitTools(t *testing.T) {
+	git := newGitRepo(t)
+	ctx := context.Background()
+
+	os.WriteFile("main.go", []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.WriteFile(".env", []byte("SECRET=2\n"), 0644)
+	os.WriteFile("new.go", []byte("package main\n"), 0644)
+
+	out, err := text((&GitStatusTool{git: git}).Execute(ctx, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "## main") || !strings.Contains(out, " M main.go") || !strings.Contains(out, "?? new.go") || strings.Contains(out, ".env") {
+		t.Errorf("unexpected status:\n%s", out)
+	}
+
+	out, err = text((&GitDiffTool{git: git}).Execute(ctx, map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "+func main() {}") || strings.Contains(out, "SECRET") {
+		t.Errorf("unexpected diff:\n%s", out)
+	}
+	if out, _ := text((&GitDiffTool{git: git}).Execute(ctx, map[string]interface{}{"staged": true})); out != "No changes." {
+		t.Errorf("unexpected staged diff:\n%s", out)
+	}
+	if _, err := (&GitDiffTool{git: git}).Execute(ctx, map[string]interface{}{"paths": []interface{}{".env"}}); err == nil {
+		t.Error("expected diff of a hidden path to be denied")
+	}
+
+	out, err = text((&GitCommitTool{git: git}).Execute(ctx, map[string]interface{}{"message": "Add main", "paths": []interface{}{"main.go"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Committed") || !strings.Contains(out, "main.go") {
+		t.Errorf("unexpected commit output:\n%s", out)
+	}
+
+	out, err = text((&GitLogTool{git: git}).Execute(ctx, map[string]interface{}{"max_count": 5.0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || !strings.Contains(lines[0], "Add main") {
+		t.Errorf("unexpected log:\n%s", out)
+	}
+
+	out, err = text((&GitShowTool{git: git}).Execute(ctx, map[string]interface{}{"rev": "HEAD~1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "Initial commit") || strings.Contains(out, "SECRET") {
+		t.Errorf("unexpected show output:\n%s", out)
+	}
+	if _, err := (&GitShowTool{git: git}).Execute(ctx, map[string]interface{}{"rev": "HEAD:.env"}); err == nil {
+		t.Error("expected showing a hidden file to be denied")
+	}
+	if _, err := (&GitShowTool{git: git}).Execute(ctx, map[string]interface{}{"rev": "--output=x"}); err == nil {
+		t.Error("expected an option-like revision to be rejected")
+	}
+
+	out, err = text((&GitBlameTool{git: git}).Execute(ctx, map[string]interface{}{"path": "main.go", "start_line": 3.0, "end_line": 3.0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "func main() {}") || strings.Count(strings.TrimSpace(out), "\n") != 0 {
+		t.Errorf("unexpected blame:\n%s", out)
+	}
+
+	branch := &GitBranchTool{git: git}
+	if _, err := branch.Execute(ctx, map[string]interface{}{"name": "feature", "checkout": true}); err != nil {
+		t.Fatal(err)
+	}
+	out, _ = text(branch.Execute(ctx, map[string]interface{}{}))
+	if !strings.Contains(out, "* feature") || !strings.Contains(out, "main") {
+		t.Errorf("unexpected branch list:\n%s", out)
+	}
+	if _, err := branch.Execute(ctx, map[string]interface{}{"name": "bad..name"}); err == nil {
+		t.Error("expected an invalid branch name to be rejected")
+	}
+}
@@ -0,0 +1,375 @@
+package tools
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// skippedElements hold no readable content, or only page chrome.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Canvas: true, atom.Iframe: true, atom.Nav: true, atom.Footer: true,
+	atom.Button: true, atom.Select: true, atom.Input: true, atom.Textarea: true,
+}
+
+// blockElements start and end a paragraph.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Header: true, atom.Aside: true, atom.Figure: true, atom.Figcaption: true, atom.Details: true,
+	atom.Summary: true, atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Address: true, atom.Form: true,
+}
+
+var (
+	spaceRun     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLineRun = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToMarkdown extracts the readable content of an HTML document as
+// markdown, resolving links against base. It also returns the page title.
+func htmlToMarkdown(r io.Reader, base *url.URL) (title, markdown string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to parse HTML")
+	}
+	if t := findElement(doc, atom.Title); t != nil {
+		title = strings.TrimSpace(spaceRun.ReplaceAllString(textContent(t), " "))
+	}
+	root := findElement(doc, atom.Main)
+	if root == nil {
+		root = findElement(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	c := &mdConverter{base: base}
+	c.children(root)
+	return title, tidyMarkdown(string(c.out)), nil
+}
+
+// mdConverter renders an HTML tree as markdown.
+type mdConverter struct {
+	out    []byte
+	base   *url.URL
+	pre    int    // Depth of <pre> elements, whose text is kept verbatim
+	lists  []int  // Stack of open lists: -1 for unordered, else the next item number
+	indent string // Prefix of list item continuation lines
+}
+
+func (c *mdConverter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.node(child)
+	}
+}
+
+func (c *mdConverter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		c.children(n)
+		return
+	}
+	if skippedElements[n.DataAtom] || attr(n, "hidden") != "" || attr(n, "aria-hidden") == "true" {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.block()
+		c.out = append(c.out, strings.Repeat("#", int(n.Data[1]-'0'))+" "...)
+		c.out = append(c.out, c.inline(n)...)
+		c.block()
+	case atom.Br:
+		c.newline()
+	case atom.Hr:
+		c.block()
+		c.out = append(c.out, "---"...)
+		c.block()
+	case atom.Pre:
+		c.block()
+		c.out = append(c.out, "```"+codeLanguage(n)+"\n"...)
+		c.pre++
+		c.children(n)
+		c.pre--
+		if !c.endsWith("\n") {
+			c.out = append(c.out, "\n"...)
+		}
+		c.out = append(c.out, "```"...)
+		c.block()
+	case atom.Code, atom.Kbd, atom.Samp:
+		if c.pre > 0 {
+			c.children(n)
+			return
+		}
+		if code := strings.TrimSpace(textContent(n)); code != "" {
+			c.write("`" + code + "`")
+		}
+	case atom.Strong, atom.B:
+		c.wrap(n, "**")
+	case atom.Em, atom.I:
+		c.wrap(n, "_")
+	case atom.A:
+		text := c.inline(n)
+		href := c.resolve(attr(n, "href"))
+		if href == "" || text == "" || strings.HasPrefix(href, "javascript:") {
+			c.write(text)
+			return
+		}
+		c.write("[" + text + "](" + href + ")")
+	case atom.Img:
+		if src := c.resolve(attr(n, "src")); src != "" && !strings.HasPrefix(src, "data:") {
+			c.write("![" + attr(n, "alt") + "](" + src + ")")
+		}
+	case atom.Ul, atom.Ol:
+		next := -1
+		if n.DataAtom == atom.Ol {
+			next = 1
+			if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+				next = start
+			}
+		}
+		if len(c.lists) == 0 {
+			c.block()
+		}
+		c.lists = append(c.lists, next)
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		if len(c.lists) == 0 {
+			c.block()
+		}
+	case atom.Li:
+		c.newline()
+		depth := len(c.lists)
+		marker := "- "
+		if depth > 0 && c.lists[depth-1] >= 0 {
+			marker = strconv.Itoa(c.lists[depth-1]) + ". "
+			c.lists[depth-1]++
+		}
+		prefix := strings.Repeat("  ", max(depth-1, 0))
+		c.out = append(c.out, prefix+marker...)
+		saved := c.indent
+		c.indent = prefix + strings.Repeat(" ", len(marker))
+		c.children(n)
+		c.indent = saved
+	case atom.Blockquote:
+		c.block()
+		inner := &mdConverter{base: c.base}
+		inner.children(n)
+		for i, line := range strings.Split(tidyMarkdown(string(inner.out)), "\n") {
+			if i > 0 {
+				c.out = append(c.out, "\n"...)
+			}
+			c.out = append(c.out, strings.TrimRight("> "+line, " ")...)
+		}
+		c.block()
+	case atom.Table:
+		c.block()
+		c.table(n)
+		c.block()
+	default:
+		if blockElements[n.DataAtom] {
+			if len(c.lists) > 0 {
+				c.newline()
+			} else {
+				c.block()
+			}
+			c.children(n)
+			if len(c.lists) == 0 {
+				c.block()
+			}
+			return
+		}
+		c.children(n)
+	}
+}
+
+// text writes a text node, collapsing whitespace outside <pre>.
+func (c *mdConverter) text(s string) {
+	if c.pre > 0 {
+		c.out = append(c.out, s...)
+		return
+	}
+	s = spaceRun.ReplaceAllString(s, " ")
+	if s == " " || s == "" {
+		if len(c.out) > 0 && !c.endsWith(" ") && !c.endsWith("\n") {
+			c.out = append(c.out, " "...)
+		}
+		return
+	}
+	c.write(s)
+}
+
+// write appends inline content, dropping spaces at the start of a line.
+func (c *mdConverter) write(s string) {
+	if len(c.out) == 0 || c.endsWith("\n") || c.endsWith(" ") {
+		s = strings.TrimLeft(s, " ")
+	}
+	if s == "" {
+		return
+	}
+	if c.endsWith("\n") && !c.endsWith("\n\n") && c.indent != "" {
+		c.out = append(c.out, c.indent...)
+	}
+	c.out = append(c.out, s...)
+}
+
+// wrap writes the inline content of n between markers, e.g. "**bold**".
+func (c *mdConverter) wrap(n *html.Node, marker string) {
+	text := c.inline(n)
+	if text == "" {
+		return
+	}
+	if len(c.out) > 0 && !c.endsWith(" ") && !c.endsWith("\n") && startsWithSpace(n) {
+		c.out = append(c.out, " "...)
+	}
+	c.write(marker + text + marker)
+}
+
+// inline renders the children of n as a single line.
+func (c *mdConverter) inline(n *html.Node) string {
+	inner := &mdConverter{base: c.base}
+	inner.children(n)
+	return strings.TrimSpace(spaceRun.ReplaceAllString(string(inner.out), " "))
+}
+
+func (c *mdConverter) table(n *html.Node) {
+	first := true
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			if child.DataAtom != atom.Tr {
+				walk(child)
+				continue
+			}
+			var cells []string
+			for cell := child.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+					cells = append(cells, strings.ReplaceAll(c.inline(cell), "|", `\|`))
+				}
+			}
+			if len(cells) == 0 {
+				continue
+			}
+			c.out = append(c.out, "| "+strings.Join(cells, " | ")+" |\n"...)
+			if first {
+				c.out = append(c.out, strings.Repeat("| --- ", len(cells))+"|\n"...)
+				first = false
+			}
+		}
+	}
+	walk(n)
+}
+
+// block ends the current paragraph.
+func (c *mdConverter) block() {
+	c.out = bytes.TrimRight(c.out, " ")
+	if len(c.out) == 0 {
+		return
+	}
+	c.out = append(bytes.TrimRight(c.out, "\n"), "\n\n"...)
+}
+
+// newline ends the current line.
+func (c *mdConverter) newline() {
+	c.out = bytes.TrimRight(c.out, " ")
+	if len(c.out) > 0 && !c.endsWith("\n") {
+		c.out = append(c.out, '\n')
+	}
+}
+
+func (c *mdConverter) endsWith(s string) bool {
+	return bytes.HasSuffix(c.out, []byte(s))
+}
+
+// resolve makes a link absolute. Fragment-only links are dropped.
+func (c *mdConverter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if c.base != nil {
+		u = c.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+// tidyMarkdown trims trailing spaces and collapses runs of blank lines.
+func tidyMarkdown(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(blankLineRun.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// codeLanguage returns the language of a code block from a "language-x"
+// class on the <pre> element or its <code> child.
+func codeLanguage(pre *html.Node) string {
+	nodes := []*html.Node{pre}
+	if pre.FirstChild != nil && pre.FirstChild.DataAtom == atom.Code {
+		nodes = append(nodes, pre.FirstChild)
+	}
+	for _, n := range nodes {
+		for _, class := range strings.Fields(attr(n, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+func startsWithSpace(n *html.Node) bool {
+	return n.FirstChild != nil && n.FirstChild.Type == html.TextNode && strings.TrimLeft(n.FirstChild.Data, " \t\n") != n.FirstChild.Data
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		b.WriteString(textContent(child))
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+package mcp
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// Sampler answers a sampling request of an MCP server, which asks the
+// client's model to generate a message. It returns an error if the request is
+// declined or fails.
+type Sampler func(ctx context.Context, server string, params *mcpsdk.CreateMessageParams) (*mcpsdk.CreateMessageResult, error)
+
+// SetSampler lets the server ask for messages from the client's model through
+// sampler. It takes effect when the server is next started, since the client
+// announces sampling support when it connects.
+func (c *MCPClient) SetSampler(sampler Sampler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sampler = sampler
+}
+
+// createMessage answers a sampling request. Requests are only accepted while
+// one of the server's tools is called: at other times the user is at the
+// prompt and cannot be asked for approval.
+func (c *MCPClient) createMessage(ctx context.Context, _ *mcpsdk.ClientSession, params *mcpsdk.CreateMessageParams) (*mcpsdk.CreateMessageResult, error) {
+	c.mu.Lock()
+	sampler, inCall := c.sampler, len(c.calls) > 0
+	c.mu.Unlock()
+	if !inCall {
+		return nil, errors.New("sampling is only available while a tool of MCP server '%s' is called", c.Name)
+	}
+	return sampler(ctx, c.Name, params)
+}
+
+// fileURI returns the file:// URI of a directory.
+func fileURI(dir string) string {
+	path := filepath.ToSlash(dir)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths such as C:/src become file:///C:/src.
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
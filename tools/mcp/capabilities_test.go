@@ -0,0 +1,61 @@
+package mcp
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRootsAndSampling(t *testing.T) {
+	server := mcpsdk.NewServer(&mcpsdk.Implementation{Name: "summarizer", Version: "v0.0.1"}, nil)
+	var serverSession *mcpsdk.ServerSession
+	// The tool asks for the roots and has the client's model summarize them.
+	// Servers of go-sdk v0.2.0 cannot decode the content of the answer, so
+	// the test checks the request the sampler receives.
+	server.AddTool(&mcpsdk.Tool{Name: "summarize", InputSchema: &jsonschema.Schema{Type: "object"}},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+			roots, err := ss.ListRoots(ctx, nil)
+			if err != nil {
+				return nil, err
+			}
+			ss.CreateMessage(ctx, &mcpsdk.CreateMessageParams{
+				MaxTokens: 100,
+				Messages:  []*mcpsdk.SamplingMessage{{Role: "user", Content: &mcpsdk.TextContent{Text: roots.Roots[0].URI}}},
+			})
+			return &mcpsdk.CallToolResult{Content: []mcpsdk.Content{&mcpsdk.TextContent{Text: "done"}}}, nil
+		})
+	client := newClient("summarizer", Options{Roots: []string{"/src/project"}}, func() (mcpsdk.Transport, *exec.Cmd, error) {
+		clientTransport, serverTransport := mcpsdk.NewInMemoryTransports()
+		ss, err := server.Connect(context.Background(), serverTransport)
+		serverSession = ss
+		return clientTransport, nil, err
+	})
+	defer client.Stop()
+	var sampled []string
+	client.SetSampler(func(ctx context.Context, server string, params *mcpsdk.CreateMessageParams) (*mcpsdk.CreateMessageResult, error) {
+		sampled = append(sampled, server+": "+params.Messages[0].Content.(*mcpsdk.TextContent).Text)
+		return &mcpsdk.CreateMessageResult{Content: &mcpsdk.TextContent{Text: "summary"}, Role: "assistant"}, nil
+	})
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	tool, _ := client.GetTool("summarize")
+	if _, err := tool.Execute(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"summarizer: file:///src/project"}; !reflect.DeepEqual(sampled, want) {
+		t.Errorf("sampled %q, want %q", sampled, want)
+	}
+
+	// Outside of tool calls, sampling requests are declined.
+	if _, err := serverSession.CreateMessage(context.Background(), &mcpsdk.CreateMessageParams{
+		Messages: []*mcpsdk.SamplingMessage{{Role: "user", Content: &mcpsdk.TextContent{Text: "hi"}}},
+	}); err == nil || len(sampled) != 1 {
+		t.Errorf("expected a sampling request outside a tool call to fail, got %v", err)
+	}
+}
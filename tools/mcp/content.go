@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/m4xw311/compell/session"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// convertResult converts the result of an MCP tool call. Images are kept for
+// models that can see them, audio is kept as data, and resources are inlined
+// when they are text and summarized otherwise. Structured content is added as
+// JSON unless a text part already holds it.
+func convertResult(res *mcpsdk.CallToolResult) session.ToolResult {
+	result := session.ToolResult{IsError: res.IsError}
+	for _, c := range res.Content {
+		result.Content = append(result.Content, convertContent(c))
+	}
+	if res.StructuredContent != nil && !hasJSONText(result.Content, res.StructuredContent) {
+		data, err := json.MarshalIndent(res.StructuredContent, "", "  ")
+		if err != nil {
+			data = []byte(fmt.Sprintf("(structured content could not be encoded: %v)", err))
+		}
+		result.Content = append(result.Content, session.ContentPart{Type: session.PartText, Text: "Structured content:\n" + string(data)})
+	}
+	if len(result.Content) == 0 {
+		result.Content = []session.ContentPart{{Type: session.PartText, Text: "(The tool returned no content.)"}}
+	}
+	return result
+}
+
+func convertContent(c mcpsdk.Content) session.ContentPart {
+	switch c := c.(type) {
+	case *mcpsdk.TextContent:
+		return session.ContentPart{Type: session.PartText, Text: c.Text}
+	case *mcpsdk.ImageContent:
+		return session.ContentPart{Type: session.PartImage, MIMEType: c.MIMEType, Data: c.Data}
+	case *mcpsdk.AudioContent:
+		return session.ContentPart{Type: session.PartAudio, MIMEType: c.MIMEType, Data: c.Data}
+	case *mcpsdk.ResourceLink:
+		return session.ContentPart{Type: session.PartText, Text: describeResourceLink(c)}
+	case *mcpsdk.EmbeddedResource:
+		return convertResource(c.Resource)
+	default:
+		return session.ContentPart{Type: session.PartText, Text: fmt.Sprintf("[Unsupported content of type %T]", c)}
+	}
+}
+
+// convertResource inlines a text resource and summarizes a binary one, except
+// for images, which are passed on as such.
+func convertResource(r *mcpsdk.ResourceContents) session.ContentPart {
+	if r == nil {
+		return session.ContentPart{Type: session.PartText, Text: "[Empty embedded resource]"}
+	}
+	switch {
+	case r.Blob == nil:
+		return session.ContentPart{Type: session.PartText, Text: fmt.Sprintf("Resource %s:\n%s", r.URI, r.Text)}
+	case strings.HasPrefix(r.MIMEType, "image/"):
+		return session.ContentPart{Type: session.PartImage, MIMEType: r.MIMEType, Data: r.Blob}
+	default:
+		mimeType := r.MIMEType
+		if mimeType == "" {
+			mimeType = "unknown type"
+		}
+		return session.ContentPart{Type: session.PartText, Text: fmt.Sprintf("[Resource %s: %s, %d bytes]", r.URI, mimeType, len(r.Blob))}
+	}
+}
+
+func describeResourceLink(l *mcpsdk.ResourceLink) string {
+	var b strings.Builder
+	name := l.Title
+	if name == "" {
+		name = l.Name
+	}
+	fmt.Fprintf(&b, "Resource link: %s", l.URI)
+	if name != "" {
+		fmt.Fprintf(&b, " (%s)", name)
+	}
+	if l.MIMEType != "" {
+		fmt.Fprintf(&b, ", %s", l.MIMEType)
+	}
+	if l.Size != nil {
+		fmt.Fprintf(&b, ", %d bytes", *l.Size)
+	}
+	if l.Description != "" {
+		fmt.Fprintf(&b, "\n%s", l.Description)
+	}
+	return b.String()
+}
+
+// hasJSONText reports whether one of the text parts is the JSON encoding of
+// value, which servers are asked to include for clients that do not read
+// structured content.
+func hasJSONText(parts []session.ContentPart, value any) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	var want any
+	if err := json.Unmarshal(data, &want); err != nil {
+		return false
+	}
+	for _, part := range parts {
+		var got any
+		if part.Type == session.PartText && json.Unmarshal([]byte(part.Text), &got) == nil && reflect.DeepEqual(got, want) {
+			return true
+		}
+	}
+	return false
+}
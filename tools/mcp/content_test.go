@@ -0,0 +1,61 @@
+package mcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/session"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestConvertResult(t *testing.T) {
+	size := int64(2048)
+	result := convertResult(&mcpsdk.CallToolResult{
+		Content: []mcpsdk.Content{
+			&mcpsdk.TextContent{Text: "Found 2 matches."},
+			&mcpsdk.ImageContent{MIMEType: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}},
+			&mcpsdk.AudioContent{MIMEType: "audio/wav", Data: []byte{1, 2}},
+			&mcpsdk.ResourceLink{URI: "file:///docs/a.md", Name: "a.md", MIMEType: "text/markdown", Size: &size},
+			&mcpsdk.EmbeddedResource{Resource: &mcpsdk.ResourceContents{URI: "file:///b.txt", Text: "hello"}},
+			&mcpsdk.EmbeddedResource{Resource: &mcpsdk.ResourceContents{URI: "file:///c.bin", MIMEType: "application/zip", Blob: []byte{1, 2, 3}}},
+		},
+		StructuredContent: map[string]any{"matches": 2},
+		IsError:           true,
+	})
+
+	if !result.IsError {
+		t.Error("expected IsError to be carried over")
+	}
+	types := []string{session.PartText, session.PartImage, session.PartAudio, session.PartText, session.PartText, session.PartText, session.PartText}
+	if len(result.Content) != len(types) {
+		t.Fatalf("got %d parts, want %d: %+v", len(result.Content), len(types), result.Content)
+	}
+	for i, want := range types {
+		if result.Content[i].Type != want {
+			t.Errorf("part %d has type %s, want %s", i, result.Content[i].Type, want)
+		}
+	}
+	text := result.Text()
+	for _, want := range []string{
+		"Found 2 matches.",
+		"[image/png image, 4 bytes]",
+		"[audio/wav audio, 2 bytes]",
+		"Resource link: file:///docs/a.md (a.md), text/markdown, 2048 bytes",
+		"Resource file:///b.txt:\nhello",
+		"[Resource file:///c.bin: application/zip, 3 bytes]",
+		"Structured content:\n{\n  \"matches\": 2\n}",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("result text lacks %q:\n%s", want, text)
+		}
+	}
+
+	// Structured content already given as text is not repeated.
+	result = convertResult(&mcpsdk.CallToolResult{
+		Content:           []mcpsdk.Content{&mcpsdk.TextContent{Text: `{"matches": 2}`}},
+		StructuredContent: map[string]any{"matches": 2},
+	})
+	if len(result.Content) != 1 || result.IsError {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
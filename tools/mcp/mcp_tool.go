@@ -2,128 +2,290 @@ package mcp
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/sysproc"
 	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
-// MCPClient manages the connection to a single MCP server subprocess.
+// MCPClient manages a single MCP server, either a subprocess or a remote
+// server. The server is started on first use and restarted after it crashed;
+// see supervisor.go.
 type MCPClient struct {
-	Name  string
-	cmd   *exec.Cmd
-	conn  *mcpsdk.ClientSession
-	tools map[string]*MCPTool // Map of tool name (e.g., "file_reader") to the tool instance.
+	Name string
+	// connect creates a transport to a new instance of the server, and the
+	// server's process for stdio servers.
+	connect func() (mcpsdk.Transport, *exec.Cmd, error)
+	opts    Options
+
+	mu       sync.Mutex
+	conn     *mcpsdk.ClientSession // Nil while the server is not running
+	cmd      *exec.Cmd             // Nil for remote servers
+	state    State
+	lastErr  error
+	started  time.Time // When the running instance was started
+	failures int       // Consecutive failed starts and crashes, for backoff
+	restarts int       // Instances started after the first one
+	retryAt  time.Time // Earliest time of the next start after a failure
+
+	changed   bool              // The server announced that its catalog changed
+	calls     map[string]string // Tool calls in flight, by progress token
+	nextToken int
+	sampler   Sampler
+
+	// The catalog of the server: its tools, resources, resource templates
+	// and prompts, from the running server or the cache.
+	loaded    bool
+	tools     map[string]*MCPTool // Map of tool name (e.g., "file_reader") to the tool instance.
+	resources []*mcpsdk.Resource
+	templates []*mcpsdk.ResourceTemplate
+	prompts   []*mcpsdk.Prompt
 }
 
-// GetAllTools returns all tools provided by this MCP server.
+// Options configure how an MCP server is supervised.
+type Options struct {
+	// CallTimeout bounds every request to the server, such as a tool call.
+	// Defaults to DefaultCallTimeout.
+	CallTimeout time.Duration
+	// PingInterval is the interval of the health checks of a running server.
+	// Defaults to DefaultPingInterval.
+	PingInterval time.Duration
+	// CacheFile stores the catalog of the server, so that its tools can be
+	// offered without starting it. Empty disables the cache.
+	CacheFile string
+	// CacheKey identifies the server configuration the cached catalog was
+	// read from; a cache with another key is ignored.
+	CacheKey string
+	// Roots are the directories the server may work in, usually the
+	// workspace. They are offered to servers that ask for roots.
+	Roots []string
+}
+
+// GetAllTools returns all tools provided by this MCP server, sorted by name.
+// It is empty until the catalog was loaded, see Load.
 func (c *MCPClient) GetAllTools() []*MCPTool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	var allTools []*MCPTool
 	for _, tool := range c.tools {
 		allTools = append(allTools, tool)
 	}
+	sort.Slice(allTools, func(i, j int) bool { return allTools[i].toolName < allTools[j].toolName })
 	return allTools
 }
 
-// NewMCPClient starts the MCP server subprocess and initializes the client.
-// It is responsible for discovering the tools provided by the server.
-func NewMCPClient(name, command string, args []string) (*MCPClient, error) {
-	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	mcpClient := mcpsdk.NewClient(&mcpsdk.Implementation{Name: "mcp-client", Version: "v1.0.0"}, nil)
-	ctx := context.Background()
-	conn, err := mcpClient.Connect(ctx, mcpsdk.NewCommandTransport(cmd))
+// NewMCPClient starts the MCP server subprocess with the given environment
+// and initializes the client. It is responsible for discovering the tools
+// provided by the server.
+func NewMCPClient(name, command string, args []string, env []string) (*MCPClient, error) {
+	client := NewStdioClient(name, command, args, env, "", Options{})
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewRemoteMCPClient connects to the MCP server at url over transport, which
+// is config.MCPTransportHTTP for Streamable HTTP or config.MCPTransportSSE for
+// the older HTTP with SSE transport. headers are sent with every request.
+func NewRemoteMCPClient(name, transport, url string, headers map[string]string) (*MCPClient, error) {
+	client, err := NewHTTPClient(name, transport, url, headers, Options{})
 	if err != nil {
-		cmd.Process.Kill()
-		return nil, errors.Wrapf(err, "failed to connect to MCP server '%s'", name)
+		return nil, err
 	}
-	client := &MCPClient{
-		Name:  name,
-		cmd:   cmd,
-		conn:  conn,
-		tools: make(map[string]*MCPTool),
+	if err := client.Start(); err != nil {
+		return nil, err
 	}
-	toolListParams := &mcpsdk.ListToolsParams{}
-	for {
-		toolList, err := conn.ListTools(ctx, toolListParams)
-		if err != nil {
-			// Attempt to stop the process we just started.
-			cmd.Process.Kill()
-			return nil, errors.Wrapf(err, "failed to list tools from MCP server '%s'", name)
-		}
+	return client, nil
+}
 
-		for _, t := range toolList.Tools {
-			client.tools[t.Name] = &MCPTool{
-				serverName:  name,
-				toolName:    t.Name,
-				description: t.Description,
-				client:      client,
-			}
-		}
+// NewStdioClient returns a client that runs the MCP server as a subprocess
+// with the given environment in dir, or the current directory if dir is
+// empty, once it is needed.
+func NewStdioClient(name, command string, args []string, env []string, dir string, opts Options) *MCPClient {
+	return newClient(name, opts, func() (mcpsdk.Transport, *exec.Cmd, error) {
+		cmd := exec.Command(command, args...)
+		cmd.Env = env
+		cmd.Dir = dir
+		cmd.Stderr = os.Stderr
+		// Keep terminal interrupts meant for compell from killing the server.
+		sysproc.SetProcessGroup(cmd)
+		return mcpsdk.NewCommandTransport(cmd), cmd, nil
+	})
+}
 
-		if toolList.NextCursor == "" {
-			break
+// NewHTTPClient returns a client that connects to the remote MCP server at
+// url once it is needed. See NewRemoteMCPClient.
+func NewHTTPClient(name, transport, url string, headers map[string]string, opts Options) (*MCPClient, error) {
+	httpClient := &http.Client{Transport: &headerTransport{headers: headers, base: http.DefaultTransport}}
+	var newTransport func() mcpsdk.Transport
+	switch transport {
+	case config.MCPTransportHTTP:
+		newTransport = func() mcpsdk.Transport {
+			return mcpsdk.NewStreamableClientTransport(url, &mcpsdk.StreamableClientTransportOptions{HTTPClient: httpClient})
 		}
-		toolListParams.Cursor = toolList.NextCursor
+	case config.MCPTransportSSE:
+		newTransport = func() mcpsdk.Transport {
+			return mcpsdk.NewSSEClientTransport(url, &mcpsdk.SSEClientTransportOptions{HTTPClient: httpClient})
+		}
+	default:
+		return nil, errors.New("unsupported transport '%s' for remote MCP server '%s'", transport, name)
 	}
+	return newClient(name, opts, func() (mcpsdk.Transport, *exec.Cmd, error) {
+		return newTransport(), nil, nil
+	}), nil
+}
 
-	fmt.Printf("INFO: Initialized MCP client for '%s'.\n", name)
-	return client, nil
+// NewFailedClient returns a client for a server that cannot be started, e.g.
+// because its configuration refers to an unset environment variable. It
+// reports err whenever the server is used.
+func NewFailedClient(name string, err error) *MCPClient {
+	return newClient(name, Options{}, func() (mcpsdk.Transport, *exec.Cmd, error) {
+		return nil, nil, err
+	})
+}
+
+func newClient(name string, opts Options, connect func() (mcpsdk.Transport, *exec.Cmd, error)) *MCPClient {
+	if opts.CallTimeout <= 0 {
+		opts.CallTimeout = DefaultCallTimeout
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultPingInterval
+	}
+	return &MCPClient{
+		Name:    name,
+		connect: connect,
+		opts:    opts,
+		state:   StateStopped,
+		tools:   make(map[string]*MCPTool),
+		calls:   make(map[string]string),
+	}
+}
+
+// headerTransport adds fixed headers, such as an Authorization token, to the
+// requests sent to a remote MCP server.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for name, value := range t.headers {
+			req.Header.Set(name, value)
+		}
+	}
+	return t.base.RoundTrip(req)
 }
 
 // GetTool returns a specific tool provided by this MCP server by its short name.
 func (c *MCPClient) GetTool(toolName string) (*MCPTool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	tool, ok := c.tools[toolName]
 	return tool, ok
 }
 
-// Stop terminates the MCP server subprocess.
-func (c *MCPClient) Stop() error {
-	if c.conn != nil {
-		c.conn.Close()
-	}
-	if c.cmd != nil && c.cmd.Process != nil {
-		fmt.Printf("INFO: Terminating MCP server '%s'\n", c.Name)
-		return c.cmd.Process.Kill()
+// maxToolNameLength is the longest tool name accepted by all LLM providers.
+const maxToolNameLength = 64
+
+// unsafeToolNameChars match the characters some LLM providers reject in tool
+// names. Gemini, for one, answers "server.tool" or "server:tool" with a 400.
+var unsafeToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// QualifiedName returns the name under which a tool of an MCP server is offered
+// to the model: "<server>__<tool>", with characters providers reject replaced
+// by "_" and shortened to 64 characters. Distinct tools can end up with the
+// same name, so the registry keeps the mapping back to server and tool.
+func QualifiedName(server, tool string) string {
+	name := unsafeToolNameChars.ReplaceAllString(server+"__"+tool, "_")
+	if len(name) > maxToolNameLength {
+		sum := sha256.Sum256([]byte(server + "\x00" + tool))
+		name = name[:maxToolNameLength-9] + "_" + hex.EncodeToString(sum[:4])
 	}
-	return nil
+	return name
+}
+
+// QualifiedPrefix returns the prefix of the qualified names of all tools of
+// the server, so that a name can be traced to the servers that may offer it
+// without listing their tools.
+func QualifiedPrefix(server string) string {
+	prefix := unsafeToolNameChars.ReplaceAllString(server+"__", "_")
+	return prefix[:min(len(prefix), maxToolNameLength-9)]
 }
 
 // MCPTool represents a tool available from an external MCP server.
 // It is designed to satisfy the `tools.Tool` interface from the parent package.
 type MCPTool struct {
+	name        string // Name offered to the model
 	serverName  string
 	toolName    string
 	description string
 	client      *MCPClient // Reference back to the client managing the connection.
 }
 
-// Name returns the fully qualified name of the tool in the format "<server>:<tool>".
+// Name returns the name offered to the model, "<server>__<tool>" unless the
+// tool was given an alias.
 func (t *MCPTool) Name() string {
-	// Using %s:%s was causing 400 error from Gemini so using %s.%s
-	//return fmt.Sprintf("%s.%s", t.serverName, t.toolName)
+	return t.name
+}
+
+// ServerName returns the name of the MCP server providing the tool.
+func (t *MCPTool) ServerName() string {
+	return t.serverName
+}
+
+// ToolName returns the name of the tool on its MCP server.
+func (t *MCPTool) ToolName() string {
 	return t.toolName
 }
 
+// WithName returns a copy of the tool offered to the model under an alias.
+func (t *MCPTool) WithName(name string) *MCPTool {
+	alias := *t
+	alias.name = name
+	return &alias
+}
+
 // Description returns the tool's description, provided by the MCP server.
 func (t *MCPTool) Description() string {
 	return t.description
 }
 
-// Execute sends the command and arguments to the MCP server and returns the result.
-func (t *MCPTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
-	result, err := t.client.conn.CallTool(ctx, &mcpsdk.CallToolParams{
+// Execute sends the command and arguments to the MCP server and returns the
+// result, starting the server first if needed. Progress and log messages the
+// server sends meanwhile are shown to the user.
+func (t *MCPTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	conn, err := t.client.session()
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.client.opts.CallTimeout)
+	defer cancel()
+	params := &mcpsdk.CallToolParams{
+		// SetProgressToken only adds to existing metadata.
+		Meta:      mcpsdk.Meta{},
 		Name:      t.toolName,
 		Arguments: args,
-	})
-	if err != nil {
-		return "", errors.Wrapf(err, "failed to call tool '%s'", t.Name())
 	}
-	op := ""
-	for _, c := range result.Content {
-		op += c.(*mcpsdk.TextContent).Text
+	token := t.client.beginCall(t.Name())
+	defer t.client.endCall(token)
+	params.SetProgressToken(token)
+	result, err := conn.CallTool(ctx, params)
+	if err != nil {
+		return session.ToolResult{}, t.client.callError(ctx, err, "failed to call tool '%s'", t.Name())
 	}
-	return op, nil
+	return convertResult(result), nil
 }
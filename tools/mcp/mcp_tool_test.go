@@ -1,8 +1,80 @@
 package mcp
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
 
 func TestMcpTool(t *testing.T) {
 	// TODO: Add actual test logic here.
 	// t.Log("McpTool test not yet implemented.")
 }
+
+type addParams struct {
+	X, Y int
+}
+
+// newTestServer returns an MCP server with an "add" tool.
+func newTestServer() *mcpsdk.Server {
+	server := mcpsdk.NewServer(&mcpsdk.Implementation{Name: "adder", Version: "v0.0.1"}, nil)
+	mcpsdk.AddTool(server, &mcpsdk.Tool{Name: "add", Description: "Adds two numbers."},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[addParams]) (*mcpsdk.CallToolResultFor[any], error) {
+			return &mcpsdk.CallToolResultFor[any]{
+				Content: []mcpsdk.Content{&mcpsdk.TextContent{Text: fmt.Sprint(params.Arguments.X + params.Arguments.Y)}},
+			}, nil
+		})
+	return server
+}
+
+// requireToken rejects requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestRemoteMCPClient(t *testing.T) {
+	server := newTestServer()
+	getServer := func(*http.Request) *mcpsdk.Server { return server }
+	handlers := map[string]http.Handler{
+		config.MCPTransportHTTP: mcpsdk.NewStreamableHTTPHandler(getServer, nil),
+		config.MCPTransportSSE:  mcpsdk.NewSSEHandler(getServer),
+	}
+	for transport, handler := range handlers {
+		t.Run(transport, func(t *testing.T) {
+			srv := httptest.NewServer(requireToken("s3cret", handler))
+			defer srv.Close()
+
+			if _, err := NewRemoteMCPClient("adder", transport, srv.URL, nil); err == nil {
+				t.Fatal("expected connecting without the token to fail")
+			}
+
+			client, err := NewRemoteMCPClient("adder", transport, srv.URL, map[string]string{"Authorization": "Bearer s3cret"})
+			if err != nil {
+				t.Fatalf("NewRemoteMCPClient: %v", err)
+			}
+			defer client.Stop()
+			tool, ok := client.GetTool("add")
+			if !ok {
+				t.Fatalf("tool 'add' not discovered, got %v", client.GetAllTools())
+			}
+			result, err := tool.Execute(context.Background(), map[string]interface{}{"x": 1, "y": 2})
+			if err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got := result.Text(); got != "3" {
+				t.Errorf("add returned %q, want \"3\"", got)
+			}
+		})
+	}
+}
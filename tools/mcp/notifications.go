@@ -0,0 +1,146 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// clientOptions returns the options of the connection to a new instance of
+// the server: pings, and handlers for the notifications and requests the
+// server sends.
+func (c *MCPClient) clientOptions() *mcpsdk.ClientOptions {
+	changed := func() {
+		c.mu.Lock()
+		c.changed = true
+		c.mu.Unlock()
+	}
+	opts := &mcpsdk.ClientOptions{
+		KeepAlive: c.opts.PingInterval,
+		ToolListChangedHandler: func(context.Context, *mcpsdk.ClientSession, *mcpsdk.ToolListChangedParams) {
+			changed()
+		},
+		PromptListChangedHandler: func(context.Context, *mcpsdk.ClientSession, *mcpsdk.PromptListChangedParams) {
+			changed()
+		},
+		ResourceListChangedHandler: func(context.Context, *mcpsdk.ClientSession, *mcpsdk.ResourceListChangedParams) {
+			changed()
+		},
+		ProgressNotificationHandler: c.progress,
+		LoggingMessageHandler:       c.logMessage,
+	}
+	// Sampling is only announced if it can be answered.
+	if c.sampler != nil {
+		opts.CreateMessageHandler = c.createMessage
+	}
+	return opts
+}
+
+// Refresh lists the tools, resources and prompts of the server again if it
+// announced that they changed. It reports whether the tools or their
+// descriptions changed; tools that are still offered keep their MCPTool. Refresh is meant to be called
+// between turns, so that the tools do not change while the model uses them.
+func (c *MCPClient) Refresh() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed || c.conn == nil {
+		return false, nil
+	}
+	c.changed = false
+	before := describeTools(c.tools)
+	if err := c.discover(c.conn); err != nil {
+		return false, err
+	}
+	c.saveCacheLocked()
+	return describeTools(c.tools) != before, nil
+}
+
+// describeTools returns the names and descriptions of tools, to tell whether
+// they changed.
+func describeTools(tools map[string]*MCPTool) string {
+	var entries []string
+	for _, t := range tools {
+		entries = append(entries, t.toolName+"\x00"+t.description)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\x00")
+}
+
+// beginCall registers a tool call in flight and returns the progress token
+// that associates the server's progress notifications with it.
+func (c *MCPClient) beginCall(tool string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextToken++
+	token := fmt.Sprintf("%s-%d", c.Name, c.nextToken)
+	c.calls[token] = tool
+	return token
+}
+
+func (c *MCPClient) endCall(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, token)
+}
+
+// progress shows the progress of a tool call in flight to the user.
+func (c *MCPClient) progress(_ context.Context, _ *mcpsdk.ClientSession, params *mcpsdk.ProgressNotificationParams) {
+	token, _ := params.ProgressToken.(string)
+	c.mu.Lock()
+	tool, ok := c.calls[token]
+	c.mu.Unlock()
+	if !ok {
+		// The call has already returned.
+		return
+	}
+	fmt.Printf("Tool `%s` progress: %s\n", tool, describeProgress(params))
+}
+
+// describeProgress formats a progress notification, e.g. "3/10 files indexed".
+func describeProgress(params *mcpsdk.ProgressNotificationParams) string {
+	var progress string
+	if params.Total > 0 {
+		progress = fmt.Sprintf("%g/%g", params.Progress, params.Total)
+	} else {
+		progress = fmt.Sprintf("%g", params.Progress)
+	}
+	if params.Message != "" {
+		progress += " " + params.Message
+	}
+	return progress
+}
+
+// logMessage shows a log message of the server to the user while one of its
+// tools is called. Messages sent at other times would interrupt the prompt,
+// so they are dropped.
+func (c *MCPClient) logMessage(_ context.Context, _ *mcpsdk.ClientSession, params *mcpsdk.LoggingMessageParams) {
+	c.mu.Lock()
+	inCall := len(c.calls) > 0
+	c.mu.Unlock()
+	if !inCall {
+		return
+	}
+	fmt.Println(describeLogMessage(c.Name, params))
+}
+
+// describeLogMessage formats a log message of a server, e.g.
+// "[docs] warning: index is stale".
+func describeLogMessage(server string, params *mcpsdk.LoggingMessageParams) string {
+	source := server
+	if params.Logger != "" {
+		source += "/" + params.Logger
+	}
+	data, ok := params.Data.(string)
+	if !ok {
+		raw, err := json.Marshal(params.Data)
+		if err != nil {
+			raw = []byte(fmt.Sprint(params.Data))
+		}
+		data = string(raw)
+	}
+	return fmt.Sprintf("[%s] %s: %s", source, params.Level, data)
+}
@@ -0,0 +1,82 @@
+package mcp
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestRefreshOnListChanged(t *testing.T) {
+	server := newTestServer()
+	client := newClient("adder", Options{}, func() (mcpsdk.Transport, *exec.Cmd, error) {
+		clientTransport, serverTransport := mcpsdk.NewInMemoryTransports()
+		if _, err := server.Connect(context.Background(), serverTransport); err != nil {
+			return nil, nil, err
+		}
+		return clientTransport, nil, nil
+	})
+	defer client.Stop()
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	add, _ := client.GetTool("add")
+	if changed, err := client.Refresh(); changed || err != nil {
+		t.Errorf("Refresh without a change = %v, %v", changed, err)
+	}
+
+	// A tool added later reports progress, and is picked up once the server
+	// announced it.
+	server.AddTool(&mcpsdk.Tool{Name: "index", InputSchema: &jsonschema.Schema{Type: "object"}},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+			token, _ := params.GetProgressToken().(string)
+			ss.NotifyProgress(ctx, &mcpsdk.ProgressNotificationParams{ProgressToken: token, Progress: 1, Total: 2, Message: "files indexed"})
+			return &mcpsdk.CallToolResult{Content: []mcpsdk.Content{&mcpsdk.TextContent{Text: token}}}, nil
+		})
+	var changed bool
+	for deadline := time.Now().Add(5 * time.Second); !changed && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		if changed, err = client.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !changed {
+		t.Fatal("the new tool was not picked up")
+	}
+	index, ok := client.GetTool("index")
+	if still, _ := client.GetTool("add"); !ok || still != add {
+		t.Fatalf("tools after refresh: %v", client.GetAllTools())
+	}
+	result, err := index.Execute(context.Background(), nil)
+	if err != nil || result.Text() != "adder-1" {
+		t.Errorf("index returned %q, %v; want the progress token", result.Text(), err)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("calls still in flight: %v", client.calls)
+	}
+}
+
+func TestDescribeNotifications(t *testing.T) {
+	for _, tc := range []struct {
+		params *mcpsdk.ProgressNotificationParams
+		want   string
+	}{
+		{&mcpsdk.ProgressNotificationParams{Progress: 3, Total: 10, Message: "files indexed"}, "3/10 files indexed"},
+		{&mcpsdk.ProgressNotificationParams{Progress: 0.5}, "0.5"},
+	} {
+		if got := describeProgress(tc.params); got != tc.want {
+			t.Errorf("describeProgress = %q, want %q", got, tc.want)
+		}
+	}
+
+	if got, want := describeLogMessage("docs", &mcpsdk.LoggingMessageParams{Level: "warning", Data: "index is stale"}), "[docs] warning: index is stale"; got != want {
+		t.Errorf("describeLogMessage = %q, want %q", got, want)
+	}
+	got := describeLogMessage("docs", &mcpsdk.LoggingMessageParams{Level: "info", Logger: "indexer", Data: map[string]any{"files": 3}})
+	if want := `[docs/indexer] info: {"files":3}`; got != want {
+		t.Errorf("describeLogMessage = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func sortPrompts(prompts []*mcpsdk.Prompt) {
+	sort.Slice(prompts, func(i, j int) bool { return prompts[i].Name < prompts[j].Name })
+}
+
+// Resources returns the resources offered by the server.
+func (c *MCPClient) Resources() []*mcpsdk.Resource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resources
+}
+
+// ResourceTemplates returns the URI templates of the resources the server can
+// read, such as "file:///{path}".
+func (c *MCPClient) ResourceTemplates() []*mcpsdk.ResourceTemplate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.templates
+}
+
+// Prompts returns the prompts offered by the server, sorted by name.
+func (c *MCPClient) Prompts() []*mcpsdk.Prompt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prompts
+}
+
+// Prompt returns the prompt with the given name.
+func (c *MCPClient) Prompt(name string) (*mcpsdk.Prompt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.prompts {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// ReadResource reads the resource at uri. Text contents are returned as text,
+// images as images, and other binary contents are summarized.
+func (c *MCPClient) ReadResource(ctx context.Context, uri string) (session.ToolResult, error) {
+	conn, err := c.session()
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.CallTimeout)
+	defer cancel()
+	res, err := conn.ReadResource(ctx, &mcpsdk.ReadResourceParams{URI: uri})
+	if err != nil {
+		return session.ToolResult{}, c.callError(ctx, err, "failed to read resource '%s' from MCP server '%s'", uri, c.Name)
+	}
+	var result session.ToolResult
+	for _, contents := range res.Contents {
+		result.Content = append(result.Content, convertResource(contents))
+	}
+	if len(result.Content) == 0 {
+		result.Content = []session.ContentPart{{Type: session.PartText, Text: fmt.Sprintf("Resource %s is empty.", uri)}}
+	}
+	return result, nil
+}
+
+// GetPrompt expands the named prompt with args. It returns the text of the
+// prompt's messages; when the prompt holds messages of both roles, each one is
+// labeled with its role.
+func (c *MCPClient) GetPrompt(ctx context.Context, name string, args map[string]string) (string, error) {
+	conn, err := c.session()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.CallTimeout)
+	defer cancel()
+	res, err := conn.GetPrompt(ctx, &mcpsdk.GetPromptParams{Name: name, Arguments: args})
+	if err != nil {
+		return "", c.callError(ctx, err, "failed to get prompt '%s' from MCP server '%s'", name, c.Name)
+	}
+	mixed := false
+	for _, m := range res.Messages {
+		mixed = mixed || m.Role != "user"
+	}
+	var parts []string
+	for _, m := range res.Messages {
+		text := session.ToolResult{Content: []session.ContentPart{convertContent(m.Content)}}.Text()
+		if mixed {
+			text = fmt.Sprintf("[%s]\n%s", m.Role, text)
+		}
+		parts = append(parts, text)
+	}
+	if len(parts) == 0 {
+		return "", errors.New("prompt '%s' of MCP server '%s' has no messages", name, c.Name)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
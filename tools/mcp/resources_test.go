@@ -0,0 +1,61 @@
+package mcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+func TestResourcesAndPrompts(t *testing.T) {
+	server := mcpsdk.NewServer(&mcpsdk.Implementation{Name: "docs", Version: "v0.0.1"}, nil)
+	server.AddResource(&mcpsdk.Resource{URI: "docs://guide", Name: "guide", MIMEType: "text/markdown"},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.ReadResourceParams) (*mcpsdk.ReadResourceResult, error) {
+			return &mcpsdk.ReadResourceResult{Contents: []*mcpsdk.ResourceContents{{URI: params.URI, Text: "# Guide"}}}, nil
+		})
+	server.AddResourceTemplate(&mcpsdk.ResourceTemplate{URITemplate: "docs://pages/{name}", Name: "page"},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.ReadResourceParams) (*mcpsdk.ReadResourceResult, error) {
+			return &mcpsdk.ReadResourceResult{Contents: []*mcpsdk.ResourceContents{{URI: params.URI, Text: "page " + params.URI}}}, nil
+		})
+	server.AddPrompt(&mcpsdk.Prompt{Name: "review", Arguments: []*mcpsdk.PromptArgument{{Name: "file", Required: true}}},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.GetPromptParams) (*mcpsdk.GetPromptResult, error) {
+			return &mcpsdk.GetPromptResult{Messages: []*mcpsdk.PromptMessage{
+				{Role: "user", Content: &mcpsdk.TextContent{Text: "Review " + params.Arguments["file"] + "."}},
+			}}, nil
+		})
+	srv := httptest.NewServer(mcpsdk.NewStreamableHTTPHandler(func(*http.Request) *mcpsdk.Server { return server }, nil))
+	defer srv.Close()
+
+	client, err := NewRemoteMCPClient("docs", config.MCPTransportHTTP, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	if r := client.Resources(); len(r) != 1 || r[0].URI != "docs://guide" {
+		t.Errorf("unexpected resources: %v", r)
+	}
+	if tmpl := client.ResourceTemplates(); len(tmpl) != 1 || tmpl[0].URITemplate != "docs://pages/{name}" {
+		t.Errorf("unexpected resource templates: %v", tmpl)
+	}
+	result, err := client.ReadResource(context.Background(), "docs://guide")
+	if err != nil || !strings.Contains(result.Text(), "# Guide") {
+		t.Errorf("ReadResource = %q, %v", result.Text(), err)
+	}
+	result, err = client.ReadResource(context.Background(), "docs://pages/intro")
+	if err != nil || !strings.Contains(result.Text(), "page docs://pages/intro") {
+		t.Errorf("ReadResource of a template = %q, %v", result.Text(), err)
+	}
+
+	if _, ok := client.Prompt("review"); !ok {
+		t.Fatalf("prompt 'review' not discovered, got %v", client.Prompts())
+	}
+	text, err := client.GetPrompt(context.Background(), "review", map[string]string{"file": "main.go"})
+	if err != nil || text != "Review main.go." {
+		t.Errorf("GetPrompt = %q, %v", text, err)
+	}
+}
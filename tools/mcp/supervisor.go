@@ -0,0 +1,333 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/sysproc"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+const (
+	// DefaultCallTimeout bounds requests to MCP servers that configure no
+	// timeout.
+	DefaultCallTimeout = 2 * time.Minute
+	// DefaultPingInterval is the interval of the health checks of running
+	// servers. A server that does not answer a ping within half the interval
+	// is considered to have crashed.
+	DefaultPingInterval = 30 * time.Second
+	// listTimeout bounds the requests that discover the catalog of a server.
+	listTimeout = 30 * time.Second
+	// maxBackoff bounds the wait before a crashed server is started again.
+	maxBackoff = time.Minute
+	// stableAfter is how long a server has to run before an earlier failure
+	// no longer lengthens the backoff of the next one.
+	stableAfter = time.Minute
+)
+
+// State is the state of a supervised MCP server.
+type State string
+
+const (
+	StateStopped State = "stopped" // Not started yet, or stopped on exit
+	StateRunning State = "running"
+	StateFailed  State = "failed" // Failed to start or crashed; restarted on next use after a backoff
+)
+
+// Status describes a supervised MCP server, for the /mcp command.
+type Status struct {
+	Name      string
+	State     State
+	Tools     int
+	Since     time.Time // When the running instance was started
+	Restarts  int
+	LastError error
+	RetryAt   time.Time // Earliest restart of a failed server
+}
+
+// Start starts the server unless it is running. Failed servers are started
+// again only once their backoff has passed.
+func (c *MCPClient) Start() error {
+	_, err := c.session()
+	return err
+}
+
+// Restart stops the server if it is running and starts it again right away,
+// ignoring the backoff of a failed server.
+func (c *MCPClient) Restart() error {
+	c.mu.Lock()
+	c.stopLocked()
+	c.failures, c.retryAt = 0, time.Time{}
+	c.mu.Unlock()
+	return c.Start()
+}
+
+// Load makes the catalog of the server available, from the cache if there is
+// one for the current configuration, or else by starting the server.
+func (c *MCPClient) Load() error {
+	c.mu.Lock()
+	loaded := c.loaded || c.loadCacheLocked()
+	c.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	return c.Start()
+}
+
+// LoadCached makes the catalog of the server available if it already is or
+// is cached for the current configuration, without starting the server. It
+// reports whether the catalog is available.
+func (c *MCPClient) LoadCached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loaded || c.loadCacheLocked()
+}
+
+// Status returns the current status of the server.
+func (c *MCPClient) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Status{Name: c.Name, State: c.state, Tools: len(c.tools), Restarts: c.restarts, LastError: c.lastErr}
+	if c.state == StateRunning {
+		s.Since = c.started
+	}
+	if c.state == StateFailed {
+		s.RetryAt = c.retryAt
+	}
+	return s
+}
+
+// Stop terminates the MCP server. It is not restarted afterwards unless it is
+// used again.
+func (c *MCPClient) Stop() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.cmd != nil {
+		fmt.Printf("INFO: Terminating MCP server '%s'\n", c.Name)
+	}
+	return c.stopLocked()
+}
+
+func (c *MCPClient) stopLocked() error {
+	conn, cmd := c.conn, c.cmd
+	c.conn, c.cmd = nil, nil
+	if c.state == StateRunning {
+		c.state = StateStopped
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	if cmd != nil && cmd.Process != nil {
+		return sysproc.KillProcessGroup(cmd)
+	}
+	return nil
+}
+
+// session returns the connection to the running server, starting the server
+// if it is not running.
+func (c *MCPClient) session() (*mcpsdk.ClientSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if c.state == StateFailed && time.Now().Before(c.retryAt) {
+		return nil, errors.New("MCP server '%s' is unavailable, retrying in %s: %v",
+			c.Name, time.Until(c.retryAt).Round(time.Second), c.lastErr)
+	}
+	if err := c.startLocked(); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return c.conn, nil
+}
+
+// startLocked starts a new instance of the server and loads its catalog.
+func (c *MCPClient) startLocked() error {
+	transport, cmd, err := c.connect()
+	if err != nil {
+		return errors.Wrapf(err, "failed to start MCP server '%s'", c.Name)
+	}
+	client := mcpsdk.NewClient(&mcpsdk.Implementation{Name: "mcp-client", Version: "v1.0.0"}, c.clientOptions())
+	for _, dir := range c.opts.Roots {
+		client.AddRoots(&mcpsdk.Root{Name: filepath.Base(dir), URI: fileURI(dir)})
+	}
+	// The connection outlives this call, so its context is not bounded.
+	conn, err := client.Connect(context.Background(), transport)
+	if err != nil {
+		if cmd != nil && cmd.Process != nil {
+			// Attempt to stop the process we just started.
+			sysproc.KillProcessGroup(cmd)
+		}
+		return errors.Wrapf(err, "failed to connect to MCP server '%s'", c.Name)
+	}
+	if err := c.discover(conn); err != nil {
+		conn.Close()
+		if cmd != nil && cmd.Process != nil {
+			sysproc.KillProcessGroup(cmd)
+		}
+		return err
+	}
+	// Ask for log messages, which are shown during tool calls. Servers
+	// without logging reject this.
+	levelCtx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	conn.SetLevel(levelCtx, &mcpsdk.SetLevelParams{Level: "info"})
+	cancel()
+	c.changed = false
+
+	if c.started != (time.Time{}) {
+		c.restarts++
+	}
+	c.conn, c.cmd, c.state, c.started, c.lastErr = conn, cmd, StateRunning, time.Now(), nil
+	c.saveCacheLocked()
+	go c.watch(conn)
+
+	fmt.Printf("INFO: Initialized MCP client for '%s'.\n", c.Name)
+	return nil
+}
+
+// watch waits for the connection to end. A connection that ends while the
+// server is in use means the server crashed or stopped answering pings.
+func (c *MCPClient) watch(conn *mcpsdk.ClientSession) {
+	conn.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != conn {
+		// Stopped on purpose.
+		return
+	}
+	c.stopLocked()
+	if time.Since(c.started) > stableAfter {
+		c.failures = 0
+	}
+	c.fail(errors.New("MCP server '%s' exited or stopped responding", c.Name))
+	fmt.Printf("Warning: MCP server '%s' exited or stopped responding; it is restarted when next used.\n", c.Name)
+}
+
+// fail records a failed start or a crash and schedules the next start.
+func (c *MCPClient) fail(err error) {
+	c.failures++
+	backoff := time.Second << min(c.failures-1, 6)
+	c.state, c.lastErr, c.retryAt = StateFailed, err, time.Now().Add(min(backoff, maxBackoff))
+}
+
+// callError describes a failed request, telling timeouts apart.
+func (c *MCPClient) callError(ctx context.Context, err error, format string, args ...interface{}) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("%s: MCP server '%s' did not answer within %s", fmt.Sprintf(format, args...), c.Name, c.opts.CallTimeout)
+	}
+	return errors.Wrapf(err, format, args...)
+}
+
+// discover lists the tools, resources, resource templates and prompts of the
+// server. Servers that do not offer resources or prompts answer with an
+// error, so those errors only leave the lists empty.
+func (c *MCPClient) discover(conn *mcpsdk.ClientSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+	var tools []*mcpsdk.Tool
+	for t, err := range conn.Tools(ctx, nil) {
+		if err != nil {
+			return errors.Wrapf(err, "failed to list tools from MCP server '%s'", c.Name)
+		}
+		tools = append(tools, t)
+	}
+	var cat catalog
+	cat.Tools = tools
+	for r, err := range conn.Resources(ctx, nil) {
+		if err != nil {
+			break
+		}
+		cat.Resources = append(cat.Resources, r)
+	}
+	for t, err := range conn.ResourceTemplates(ctx, nil) {
+		if err != nil {
+			break
+		}
+		cat.Templates = append(cat.Templates, t)
+	}
+	for p, err := range conn.Prompts(ctx, nil) {
+		if err != nil {
+			break
+		}
+		cat.Prompts = append(cat.Prompts, p)
+	}
+	c.setCatalogLocked(cat)
+	return nil
+}
+
+// catalog is what a server offers, as stored in the cache.
+type catalog struct {
+	Key       string                     `json:"key"`
+	Tools     []*mcpsdk.Tool             `json:"tools"`
+	Resources []*mcpsdk.Resource         `json:"resources,omitempty"`
+	Templates []*mcpsdk.ResourceTemplate `json:"resource_templates,omitempty"`
+	Prompts   []*mcpsdk.Prompt           `json:"prompts,omitempty"`
+}
+
+// setCatalogLocked replaces the catalog. Tools that are still offered keep
+// their MCPTool, so that the active toolset stays valid across restarts.
+func (c *MCPClient) setCatalogLocked(cat catalog) {
+	tools := make(map[string]*MCPTool, len(cat.Tools))
+	for _, t := range cat.Tools {
+		tool, ok := c.tools[t.Name]
+		if !ok {
+			tool = &MCPTool{
+				name:       QualifiedName(c.Name, t.Name),
+				serverName: c.Name,
+				toolName:   t.Name,
+				client:     c,
+			}
+		}
+		tool.description = t.Description
+		tools[t.Name] = tool
+	}
+	c.tools, c.resources, c.templates, c.prompts = tools, cat.Resources, cat.Templates, cat.Prompts
+	sortPrompts(c.prompts)
+	c.loaded = true
+}
+
+// loadCacheLocked loads the catalog from the cache. It reports whether there
+// was a cached catalog for the current configuration.
+func (c *MCPClient) loadCacheLocked() bool {
+	if c.opts.CacheFile == "" {
+		return false
+	}
+	data, err := os.ReadFile(c.opts.CacheFile)
+	if err != nil {
+		return false
+	}
+	var cat catalog
+	if err := json.Unmarshal(data, &cat); err != nil || cat.Key != c.opts.CacheKey {
+		return false
+	}
+	c.setCatalogLocked(cat)
+	return true
+}
+
+// saveCacheLocked stores the catalog. Failures only cost a start of the
+// server next time, so they are ignored.
+func (c *MCPClient) saveCacheLocked() {
+	if c.opts.CacheFile == "" {
+		return
+	}
+	cat := catalog{Key: c.opts.CacheKey, Resources: c.resources, Templates: c.templates, Prompts: c.prompts}
+	for _, t := range c.tools {
+		cat.Tools = append(cat.Tools, &mcpsdk.Tool{Name: t.toolName, Description: t.description})
+	}
+	sort.Slice(cat.Tools, func(i, j int) bool { return cat.Tools[i].Name < cat.Tools[j].Name })
+	data, err := json.Marshal(cat)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(c.opts.CacheFile), 0755); err != nil {
+		return
+	}
+	os.WriteFile(c.opts.CacheFile, data, 0644)
+}
@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// testServer serves newTestServer in memory, plus a "hang" tool that answers
+// only when the call is cancelled, and counts the instances started.
+type testServer struct {
+	starts   int
+	sessions []*mcpsdk.ServerSession
+}
+
+func (s *testServer) connect() (mcpsdk.Transport, *exec.Cmd, error) {
+	server := newTestServer()
+	server.AddTool(&mcpsdk.Tool{Name: "hang", InputSchema: &jsonschema.Schema{Type: "object"}},
+		func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	clientTransport, serverTransport := mcpsdk.NewInMemoryTransports()
+	ss, err := server.Connect(context.Background(), serverTransport)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.starts++
+	s.sessions = append(s.sessions, ss)
+	return clientTransport, nil, nil
+}
+
+func waitForState(t *testing.T, client *MCPClient, state State) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if client.Status().State == state {
+			return
+		}
+	}
+	t.Fatalf("server state = %s, want %s", client.Status().State, state)
+}
+
+func TestSupervisor(t *testing.T) {
+	ts := &testServer{}
+	cache := filepath.Join(t.TempDir(), "adder.json")
+	client := newClient("adder", Options{CallTimeout: 100 * time.Millisecond, CacheFile: cache, CacheKey: "v1"}, ts.connect)
+	defer client.Stop()
+
+	// The server is started on first use, not when the client is created.
+	if err := client.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.starts != 1 || len(client.GetAllTools()) != 2 {
+		t.Fatalf("after Load: %d starts, tools %v", ts.starts, client.GetAllTools())
+	}
+	add, _ := client.GetTool("add")
+
+	// A timed out call is reported as such.
+	hang, _ := client.GetTool("hang")
+	if _, err := hang.Execute(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "did not answer within 100ms") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+
+	// A crashed server fails fast during its backoff and is restarted when
+	// used afterwards; tools stay valid.
+	ts.sessions[0].Close()
+	waitForState(t, client, StateFailed)
+	if _, err := add.Execute(context.Background(), map[string]interface{}{"x": 1, "y": 2}); err == nil || !strings.Contains(err.Error(), "retrying in") {
+		t.Errorf("expected the call to fail during the backoff, got %v", err)
+	}
+	client.mu.Lock()
+	client.retryAt = time.Now()
+	client.mu.Unlock()
+	if result, err := add.Execute(context.Background(), map[string]interface{}{"x": 1, "y": 2}); err != nil || result.Text() != "3" {
+		t.Errorf("call after restart = %q, %v", result.Text(), err)
+	}
+	if s := client.Status(); s.State != StateRunning || s.Restarts != 1 || ts.starts != 2 {
+		t.Errorf("status after restart = %+v, %d starts", s, ts.starts)
+	}
+
+	// Stopping on purpose is not a crash.
+	client.Stop()
+	if s := client.Status(); s.State != StateStopped {
+		t.Errorf("state after Stop = %s", s.State)
+	}
+
+	// A new client offers the tools from the cache without starting the
+	// server, unless the configuration changed.
+	cached := newClient("adder", Options{CacheFile: cache, CacheKey: "v1"}, ts.connect)
+	if err := cached.Load(); err != nil || ts.starts != 2 || len(cached.GetAllTools()) != 2 {
+		t.Errorf("cached Load: %v, %d starts, tools %v", err, ts.starts, cached.GetAllTools())
+	}
+	changed := newClient("adder", Options{CacheFile: cache, CacheKey: "v2"}, ts.connect)
+	defer changed.Stop()
+	// LoadCached never starts the server.
+	if !newClient("adder", Options{CacheFile: cache, CacheKey: "v1"}, ts.connect).LoadCached() || changed.LoadCached() || ts.starts != 2 {
+		t.Errorf("LoadCached started the server or ignored the cache: %d starts", ts.starts)
+	}
+	if err := changed.Load(); err != nil || ts.starts != 3 {
+		t.Errorf("Load with another configuration: %v, %d starts", err, ts.starts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := NewFailedClient("broken", context.DeadlineExceeded)
+	if err := client.Start(); err == nil {
+		t.Fatal("expected the start to fail")
+	}
+	var waits []time.Duration
+	for i := 0; i < 8; i++ {
+		client.fail(context.DeadlineExceeded)
+		waits = append(waits, time.Until(client.retryAt).Round(time.Second))
+	}
+	if waits[0] != 2*time.Second || waits[4] != 32*time.Second || waits[7] != maxBackoff {
+		t.Errorf("backoff = %v", waits)
+	}
+}
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"fmt"
+	"sync"
+)
+
+// headTailBuffer is an io.Writer that keeps the first and last bytes of what
+// is written to it, up to a total limit, dropping the middle. Build and test
+// output usually has the interesting parts at the start and the end. It is
+// safe for concurrent use, so stdout and stderr can share it.
+type headTailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	head  []byte
+	tail  []byte // Ring buffer holding the most recent bytes once head is full
+	start int    // Index of the oldest byte in tail
+	total int64  // Total number of bytes written
+}
+
+func newHeadTailBuffer(limit int) *headTailBuffer {
+	return &headTailBuffer{limit: limit}
+}
+
+func (b *headTailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	b.total += int64(n)
+
+	headLimit := b.limit / 2
+	if len(b.head) < headLimit {
+		take := min(headLimit-len(b.head), len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+
+	tailLimit := b.limit - headLimit
+	for len(p) > 0 && tailLimit > 0 {
+		if len(b.tail) < tailLimit {
+			take := min(tailLimit-len(b.tail), len(p))
+			b.tail = append(b.tail, p[:take]...)
+			p = p[take:]
+			continue
+		}
+		// The ring is full: overwrite the oldest bytes.
+		if len(p) >= tailLimit {
+			p = p[len(p)-tailLimit:]
+		}
+		for _, c := range p {
+			b.tail[b.start] = c
+			b.start = (b.start + 1) % tailLimit
+		}
+		p = nil
+	}
+	return n, nil
+}
+
+// Truncated reports whether any output was dropped.
+func (b *headTailBuffer) Truncated() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total > int64(len(b.head)+len(b.tail))
+}
+
+// String returns the retained output, with a marker where bytes were dropped.
+func (b *headTailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tail := append(append([]byte(nil), b.tail[b.start:]...), b.tail[:b.start]...)
+	omitted := b.total - int64(len(b.head)+len(tail))
+	if omitted <= 0 {
+		return string(b.head) + string(tail)
+	}
+	return fmt.Sprintf("%s\n... [%d bytes omitted] ...\n%s", b.head, omitted, tail)
+}
+
+// ringBuffer is an io.Writer that keeps the most recent bytes written to it.
+// Readers keep an offset into the stream and can tell how much they missed
+// when the buffer wrapped. It is safe for concurrent use.
+type ringBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	start int   // Index of the oldest byte in buf once it is full
+	total int64 // Total number of bytes written
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, 0, size)}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := len(p)
+	b.total += int64(n)
+
+	size := cap(b.buf)
+	if len(p) >= size {
+		b.buf = append(b.buf[:0], p[len(p)-size:]...)
+		b.start = 0
+		return n, nil
+	}
+	if room := size - len(b.buf); room > 0 {
+		take := min(room, len(p))
+		b.buf = append(b.buf, p[:take]...)
+		p = p[take:]
+	}
+	for _, c := range p {
+		b.buf[b.start] = c
+		b.start = (b.start + 1) % size
+	}
+	return n, nil
+}
+
+// ReadFrom returns the bytes written since offset, the offset to pass on the
+// next call and the number of bytes since offset that were already dropped.
+func (b *ringBuffer) ReadFrom(offset int64) (data []byte, next int64, dropped int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	oldest := b.total - int64(len(b.buf))
+	if offset < oldest {
+		dropped = oldest - offset
+		offset = oldest
+	}
+	ordered := append(append([]byte(nil), b.buf[b.start:]...), b.buf[:b.start]...)
+	return ordered[offset-oldest:], b.total, dropped
+}
@@ -0,0 +1,45 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeadTailBuffer(t *testing.T) {
+	b := newHeadTailBuffer(10)
+	b.Write([]byte("abc"))
+	if b.Truncated() || b.String() != "abc" {
+		t.Fatalf("short output altered: %q", b.String())
+	}
+
+	b.Write([]byte("defghijklmnop"))
+	b.Write([]byte("qrstuvwxyz"))
+	got := b.String()
+	if !b.Truncated() || !strings.HasPrefix(got, "abcde\n") || !strings.HasSuffix(got, "\nvwxyz") || !strings.Contains(got, "[16 bytes omitted]") {
+		t.Errorf("unexpected truncated output %q", got)
+	}
+}
+
+func TestRingBuffer(t *testing.T) {
+	b := newRingBuffer(8)
+	b.Write([]byte("hello"))
+	data, next, dropped := b.ReadFrom(0)
+	if string(data) != "hello" || next != 5 || dropped != 0 {
+		t.Fatalf("ReadFrom(0) = %q, %d, %d", data, next, dropped)
+	}
+
+	b.Write([]byte(" world"))
+	data, next, dropped = b.ReadFrom(next)
+	if string(data) != " world" || next != 11 || dropped != 0 {
+		t.Fatalf("ReadFrom(5) = %q, %d, %d", data, next, dropped)
+	}
+
+	b.Write([]byte("0123456789"))
+	data, next, dropped = b.ReadFrom(next)
+	if string(data) != "23456789" || next != 21 || dropped != 2 {
+		t.Fatalf("ReadFrom(11) = %q, %d, %d", data, next, dropped)
+	}
+	if data, _, _ := b.ReadFrom(next); len(data) != 0 {
+		t.Fatalf("expected no new data, got %q", data)
+	}
+}
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"path/filepath"
+
+	"github.com/m4xw311/compell/config"
+)
+
+// commandLineTools take a command line in their "command" argument.
+var commandLineTools = map[string]bool{
+	"execute_command": true,
+	"start_process":   true,
+}
+
+// pathArgs are the arguments holding paths. They are cleaned before they are
+// matched against approval rules, so that "src/../.env" does not match
+// "src/.*".
+var pathArgs = []string{"path", "working_dir"}
+
+// SimpleCommands splits a command line, as run by execute_command, into its
+// simple commands, e.g. "go vet ./... && go test ./... | tee log" into
+// "go vet ./...", "go test ./..." and "tee log".
+func SimpleCommands(line string) ([]string, error) {
+	chain, err := parseCommandLine(line)
+	if err != nil {
+		return nil, err
+	}
+	var commands []string
+	for _, item := range chain {
+		for _, c := range item.pipeline.commands {
+			commands = append(commands, c.String())
+		}
+	}
+	return commands, nil
+}
+
+// EvaluatePolicy applies approval rules to a tool call the way the tool will
+// run it. Path arguments are cleaned first. The command line of a command
+// tool is evaluated per simple command, so that a rule denying "rm .*" also
+// denies "echo hi && rm -rf src": if any command is denied the call is
+// denied, otherwise if any needs approval the call does. The call is only
+// allowed if every command is; if a command matches no rule, neither does the
+// call. The boolean result is false when no rule matched.
+func EvaluatePolicy(rules []config.PolicyRule, toolName string, args map[string]interface{}) (config.PolicyAction, bool, error) {
+	args = cleanPathArgs(args)
+	line, ok := args["command"].(string)
+	if !commandLineTools[toolName] || !ok {
+		return config.EvaluatePolicy(rules, toolName, args)
+	}
+	commands, err := SimpleCommands(line)
+	if err != nil {
+		// The tool refuses to run the command line anyway.
+		return config.EvaluatePolicy(rules, toolName, args)
+	}
+
+	denied, ask, unmatched := false, false, false
+	for _, command := range commands {
+		commandArgs := make(map[string]interface{}, len(args))
+		for name, value := range args {
+			commandArgs[name] = value
+		}
+		commandArgs["command"] = command
+		action, matched, err := config.EvaluatePolicy(rules, toolName, commandArgs)
+		if err != nil {
+			return "", false, err
+		}
+		switch {
+		case !matched:
+			unmatched = true
+		case action == config.PolicyDeny:
+			denied = true
+		case action == config.PolicyAsk:
+			ask = true
+		}
+	}
+	switch {
+	case denied:
+		return config.PolicyDeny, true, nil
+	case ask:
+		return config.PolicyAsk, true, nil
+	case unmatched:
+		return "", false, nil
+	}
+	return config.PolicyAllow, true, nil
+}
+
+// cleanPathArgs returns a copy of args with the path arguments cleaned.
+func cleanPathArgs(args map[string]interface{}) map[string]interface{} {
+	cleaned := make(map[string]interface{}, len(args))
+	for name, value := range args {
+		cleaned[name] = value
+	}
+	for _, name := range pathArgs {
+		if path, ok := args[name].(string); ok && path != "" {
+			cleaned[name] = filepath.Clean(path)
+		}
+	}
+	return cleaned
+}
@@ -0,0 +1,52 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/m4xw311/compell/config"
+)
+
+func TestEvaluatePolicy(t *testing.T) {
+	rules := []config.PolicyRule{
+		{Tool: "execute_command", Args: map[string]string{"command": "rm .*"}, Action: config.PolicyDeny},
+		{Tool: "execute_command", Args: map[string]string{"command": "git push.*"}, Action: config.PolicyAsk},
+		{Tool: "execute_command", Args: map[string]string{"command": ".* >>? ?src/.*"}, Action: config.PolicyDeny},
+		{Tool: "execute_command", Args: map[string]string{"command": "(ls|echo|grep|go test) ?.*"}, Action: config.PolicyAllow},
+		{Tool: "read_file", Args: map[string]string{"path": "src/.*"}, Action: config.PolicyAllow},
+		{Tool: "read_file", Args: map[string]string{"path": "\\.env"}, Action: config.PolicyDeny},
+	}
+	for _, tt := range []struct {
+		tool    string
+		args    map[string]interface{}
+		want    config.PolicyAction
+		matched bool
+	}{
+		{"execute_command", map[string]interface{}{"command": "ls -la"}, config.PolicyAllow, true},
+		{"execute_command", map[string]interface{}{"command": "rm -rf src"}, config.PolicyDeny, true},
+		// A denied command anywhere in a chain or pipeline denies the call.
+		{"execute_command", map[string]interface{}{"command": "echo hi && rm -rf src"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "ls; rm -rf /"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "ls | rm -rf src"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "go test ./... || rm -rf src"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "git push && rm -rf src"}, config.PolicyDeny, true},
+		// Approval wins over allow, and commands no rule matches leave the
+		// call unmatched.
+		{"execute_command", map[string]interface{}{"command": "go test ./... && git push"}, config.PolicyAsk, true},
+		{"execute_command", map[string]interface{}{"command": "ls | grep go"}, config.PolicyAllow, true},
+		{"execute_command", map[string]interface{}{"command": "ls && make"}, "", false},
+		// Redirections are part of the matched command.
+		{"execute_command", map[string]interface{}{"command": "echo x > src/main.go"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "ls >>src/files.txt"}, config.PolicyDeny, true},
+		{"execute_command", map[string]interface{}{"command": "echo x > notes.txt 2>&1"}, config.PolicyAllow, true},
+		{"start_process", map[string]interface{}{"command": "rm -rf src"}, "", false},
+		// Paths are cleaned before they are matched.
+		{"read_file", map[string]interface{}{"path": "src/main.go"}, config.PolicyAllow, true},
+		{"read_file", map[string]interface{}{"path": "src/../.env"}, config.PolicyDeny, true},
+		{"read_file", map[string]interface{}{"path": "./src/main.go"}, config.PolicyAllow, true},
+	} {
+		got, matched, err := EvaluatePolicy(rules, tt.tool, tt.args)
+		if err != nil || got != tt.want || matched != tt.matched {
+			t.Errorf("EvaluatePolicy(%s, %v) = %q, %t, %v; want %q, %t", tt.tool, tt.args, got, matched, err, tt.want, tt.matched)
+		}
+	}
+}
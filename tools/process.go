@@ -0,0 +1,490 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/sandbox"
+	"github.com/m4xw311/compell/secrets"
+	"github.com/m4xw311/compell/session"
+	"github.com/m4xw311/compell/sysproc"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+const (
+	// maxRunningProcesses bounds the number of background processes that may
+	// run at the same time.
+	maxRunningProcesses = 8
+	// processStopGrace is how long a process may take to exit after SIGTERM
+	// before it is killed.
+	processStopGrace = 5 * time.Second
+	// maxProcessOutputWait bounds the wait_seconds argument of read_process_output.
+	maxProcessOutputWait = 30 * time.Second
+	// processPollInterval is how often read_process_output checks for new output.
+	processPollInterval = 100 * time.Millisecond
+)
+
+// ProcessManager tracks the background processes started by the agent, such
+// as dev servers or test watchers, and keeps their recent output.
+type ProcessManager struct {
+	mu         sync.Mutex
+	nextID     int
+	processes  map[string]*managedProcess
+	bufferSize int
+	sandbox    *sandbox.Sandbox
+}
+
+// managedProcess is a process started by start_process.
+type managedProcess struct {
+	id      string
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	inputMu sync.Mutex // Serializes writes to stdin
+	output  *ringBuffer
+	done    chan struct{} // Closed once the process has exited
+
+	mu     sync.Mutex
+	offset int64 // Position in output up to which the agent has read
+	err    error // Result of Wait, set before done is closed
+}
+
+// NewProcessManager creates a process manager keeping up to bufferSize bytes
+// of the most recent output of each process. Processes run in sb, which may
+// be nil.
+func NewProcessManager(bufferSize int, sb *sandbox.Sandbox) *ProcessManager {
+	if bufferSize <= 0 {
+		bufferSize = defaultMaxCommandOutput
+	}
+	return &ProcessManager{
+		nextID:     1,
+		processes:  make(map[string]*managedProcess),
+		bufferSize: bufferSize,
+		sandbox:    sb,
+	}
+}
+
+// Start launches a command in the background with the environment selected
+// by envFilter. The command runs in its own process group so that it can be
+// stopped together with its children.
+func (m *ProcessManager) Start(command string, sc *simpleCommand, dir string, envFilter config.EnvFilter) (*managedProcess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running := 0
+	for _, p := range m.processes {
+		if !p.exited() {
+			running++
+		}
+	}
+	if running >= maxRunningProcesses {
+		return nil, errors.New("too many running processes (%d); stop one with stop_process first", running)
+	}
+
+	argv, err := expandWords(sc.args, dir)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(secrets.FilterEnv(m.sandbox.Environ(), envFilter), sc.env...)
+	sysproc.SetProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	if err := m.sandbox.Apply(cmd); err != nil {
+		return nil, err
+	}
+
+	p := &managedProcess{
+		id:      "p" + strconv.Itoa(m.nextID),
+		command: command,
+		cmd:     cmd,
+		output:  newRingBuffer(m.bufferSize),
+		done:    make(chan struct{}),
+	}
+	cmd.Stdout = p.output
+	cmd.Stderr = p.output
+	p.stdin, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create stdin pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "failed to start '%s'", command)
+	}
+	go func() {
+		err := cmd.Wait()
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+		close(p.done)
+	}()
+
+	m.nextID++
+	m.processes[p.id] = p
+	return p, nil
+}
+
+// Get returns the process with the given id.
+func (m *ProcessManager) Get(id string) (*managedProcess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.processes[id]
+	if !ok {
+		return nil, errors.New("no process with id '%s'", id)
+	}
+	return p, nil
+}
+
+// Stop stops the process with the given id and forgets it.
+func (m *ProcessManager) Stop(id string) (*managedProcess, error) {
+	p, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	p.stop()
+	m.mu.Lock()
+	delete(m.processes, id)
+	m.mu.Unlock()
+	return p, nil
+}
+
+// StopAll stops every process still running. It is called when the session ends.
+func (m *ProcessManager) StopAll() {
+	m.mu.Lock()
+	processes := make([]*managedProcess, 0, len(m.processes))
+	for _, p := range m.processes {
+		processes = append(processes, p)
+	}
+	m.processes = make(map[string]*managedProcess)
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range processes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// List returns the tracked processes ordered by id.
+func (m *ProcessManager) List() []*managedProcess {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	processes := make([]*managedProcess, 0, len(m.processes))
+	for _, p := range m.processes {
+		processes = append(processes, p)
+	}
+	sort.Slice(processes, func(i, j int) bool {
+		a, _ := strconv.Atoi(processes[i].id[1:])
+		b, _ := strconv.Atoi(processes[j].id[1:])
+		return a < b
+	})
+	return processes
+}
+
+func (p *managedProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop asks the process group to terminate and kills it if it does not exit
+// within processStopGrace.
+func (p *managedProcess) stop() {
+	if p.exited() {
+		return
+	}
+	p.stdin.Close()
+	sysproc.TerminateProcessGroup(p.cmd)
+	select {
+	case <-p.done:
+	case <-time.After(processStopGrace):
+		sysproc.KillProcessGroup(p.cmd)
+		<-p.done
+	}
+}
+
+// status describes the process and whether it is still running.
+func (p *managedProcess) status() string {
+	desc := fmt.Sprintf("Process %s (PID %d, `%s`)", p.id, p.cmd.Process.Pid, p.command)
+	if !p.exited() {
+		return desc + " is running."
+	}
+	p.mu.Lock()
+	err := p.err
+	p.mu.Unlock()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return desc + " exited with code 0."
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return fmt.Sprintf("%s exited with code %d.", desc, exitErr.ExitCode())
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("%s was terminated (%s).", desc, exitErr.ProcessState)
+	default:
+		return fmt.Sprintf("%s exited: %v.", desc, err)
+	}
+}
+
+// readNew returns the output produced since the last call, noting any output
+// that was dropped because the buffer wrapped in between.
+func (p *managedProcess) readNew() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	data, next, dropped := p.output.ReadFrom(p.offset)
+	p.offset = next
+	if dropped > 0 {
+		return fmt.Sprintf("... [%d bytes omitted] ...\n%s", dropped, data)
+	}
+	return string(data)
+}
+
+// hasNew reports whether there is output the agent has not read yet.
+func (p *managedProcess) hasNew() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, next, _ := p.output.ReadFrom(p.offset)
+	return next > p.offset
+}
+
+// formatProcessOutput renders the status of a process followed by its new output.
+func formatProcessOutput(p *managedProcess) string {
+	output := p.readNew()
+	if output == "" {
+		return p.status() + "\nNo new output."
+	}
+	return fmt.Sprintf("%s\nNew output:\n%s", p.status(), output)
+}
+
+// StartProcessTool starts a command in the background.
+type StartProcessTool struct {
+	manager         *ProcessManager
+	allowedCommands []string
+	fsAccess        *config.FilesystemAccess
+	envFilter       config.EnvFilter
+}
+
+func (t *StartProcessTool) Name() string { return "start_process" }
+func (t *StartProcessTool) Description() string {
+	return "Starts a long-running command, such as a dev server or a watcher, in the background and returns its process id. " +
+		"Use read_process_output to see its output, send_process_input to write to its standard input and stop_process to stop it. " +
+		"The command must be a single allowed command (see execute_command); pipes, chains and redirections are not supported. " +
+		"Processes are stopped when the session ends. " +
+		"Args: command (string), [working_dir (string, relative to the project root)]."
+}
+
+func (t *StartProcessTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"command":     argSchema("string", "Command to start"),
+		"working_dir": argSchema("string", "Working directory, relative to the project root"),
+	}, "command")
+}
+
+func (t *StartProcessTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	command, ok := args["command"].(string)
+	if !ok {
+		return session.ToolResult{}, errors.New("missing or invalid 'command' argument")
+	}
+
+	chain, err := parseCommandLine(command)
+	if err != nil {
+		return session.ToolResult{}, errors.Wrapf(err, "could not parse command '%s'", command)
+	}
+	if len(chain) != 1 || len(chain[0].pipeline.commands) != 1 {
+		return session.ToolResult{}, errors.New("start_process runs a single command; use execute_command for pipelines and chains")
+	}
+	sc := chain[0].pipeline.commands[0]
+	if len(sc.redirects) > 0 {
+		return session.ToolResult{}, errors.New("redirections are not supported by start_process; use read_process_output to get its output")
+	}
+	allowed, err := isCommandAllowed(sc.String(), t.allowedCommands)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if !allowed {
+		return session.ToolResult{}, errors.New("command '%s' is not in the list of allowed commands", sc.String())
+	}
+
+	dir, err := resolveWorkingDir(args, t.fsAccess)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+
+	p, err := t.manager.Start(command, sc, dir, t.envFilter)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	return session.TextResult(fmt.Sprintf("Started process %s (PID %d): %s", p.id, p.cmd.Process.Pid, command)), nil
+}
+
+// Preview returns the exact command line that would be started.
+func (t *StartProcessTool) Preview(args map[string]interface{}) (string, error) {
+	return previewCommand(args)
+}
+
+func (t *StartProcessTool) PreviewedArgs() []string { return []string{"command", "working_dir"} }
+
+// ReadProcessOutputTool returns the new output of a background process.
+type ReadProcessOutputTool struct {
+	manager *ProcessManager
+}
+
+func (t *ReadProcessOutputTool) Name() string { return "read_process_output" }
+func (t *ReadProcessOutputTool) Description() string {
+	return "Returns the status of a background process started with start_process and the output it produced since the last read. " +
+		"Only the most recent output is kept. Without an id, lists all processes. " +
+		"Args: [id (string)], [wait_seconds (number, wait up to this long for new output or exit, max 30)]."
+}
+
+func (t *ReadProcessOutputTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"id":           argSchema("string", "Process id; lists all processes if omitted"),
+		"wait_seconds": argSchema("number", "Wait up to this long for new output or exit, max 30"),
+	})
+}
+
+func (t *ReadProcessOutputTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	id, _ := args["id"].(string)
+	if id == "" {
+		processes := t.manager.List()
+		if len(processes) == 0 {
+			return session.TextResult("No background processes."), nil
+		}
+		var statuses string
+		for _, p := range processes {
+			statuses += p.status() + "\n"
+		}
+		return session.TextResult(statuses), nil
+	}
+
+	p, err := t.manager.Get(id)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+
+	if seconds, ok := args["wait_seconds"].(float64); ok && seconds > 0 {
+		wait := min(time.Duration(seconds*float64(time.Second)), maxProcessOutputWait)
+		deadline := time.After(wait)
+		ticker := time.NewTicker(processPollInterval)
+		defer ticker.Stop()
+	wait:
+		for !p.hasNew() {
+			select {
+			case <-p.done:
+				break wait
+			case <-deadline:
+				break wait
+			case <-ctx.Done():
+				return session.ToolResult{}, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}
+	return session.TextResult(formatProcessOutput(p)), nil
+}
+
+// SendProcessInputTool writes to the standard input of a background process.
+type SendProcessInputTool struct {
+	manager *ProcessManager
+}
+
+func (t *SendProcessInputTool) Name() string { return "send_process_input" }
+func (t *SendProcessInputTool) Description() string {
+	return "Writes text to the standard input of a background process started with start_process. " +
+		"No newline is added. Set close to true to close its standard input afterwards. " +
+		"Args: id (string), input (string), [close (boolean)]."
+}
+
+func (t *SendProcessInputTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"id":    argSchema("string", "Process id"),
+		"input": argSchema("string", "Text to write; no newline is added"),
+		"close": argSchema("boolean", "Close standard input afterwards"),
+	}, "id", "input")
+}
+
+func (t *SendProcessInputTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	id, ok := args["id"].(string)
+	if !ok {
+		return session.ToolResult{}, errors.New("missing or invalid 'id' argument")
+	}
+	input, _ := args["input"].(string)
+	closeInput, _ := args["close"].(bool)
+
+	p, err := t.manager.Get(id)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	if p.exited() {
+		return session.ToolResult{}, errors.New("%s", p.status())
+	}
+	if input != "" {
+		// A process that does not read its input blocks the write once the
+		// pipe is full, so the write runs apart and gives up with ctx.
+		written := make(chan error, 1)
+		go func() {
+			p.inputMu.Lock()
+			defer p.inputMu.Unlock()
+			_, err := io.WriteString(p.stdin, input)
+			written <- err
+		}()
+		select {
+		case err := <-written:
+			if err != nil {
+				return session.ToolResult{}, errors.Wrapf(err, "failed to write to process %s", id)
+			}
+		case <-ctx.Done():
+			return session.ToolResult{}, errors.New("writing to process %s was cancelled because it does not read its input; "+
+				"the rest of the input is written if it does later", id)
+		}
+	}
+	if closeInput {
+		if err := p.stdin.Close(); err != nil {
+			return session.ToolResult{}, errors.Wrapf(err, "failed to close the input of process %s", id)
+		}
+		return session.TextResult(fmt.Sprintf("Sent %d bytes to process %s and closed its input.", len(input), id)), nil
+	}
+	return session.TextResult(fmt.Sprintf("Sent %d bytes to process %s.", len(input), id)), nil
+}
+
+// StopProcessTool stops a background process.
+type StopProcessTool struct {
+	manager *ProcessManager
+}
+
+func (t *StopProcessTool) Name() string { return "stop_process" }
+func (t *StopProcessTool) Description() string {
+	return "Stops a background process started with start_process, together with any processes it started, " +
+		"and returns its remaining output. Args: id (string)."
+}
+
+func (t *StopProcessTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"id": argSchema("string", "Process id"),
+	}, "id")
+}
+
+func (t *StopProcessTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	id, ok := args["id"].(string)
+	if !ok {
+		return session.ToolResult{}, errors.New("missing or invalid 'id' argument")
+	}
+	p, err := t.manager.Stop(id)
+	if err != nil {
+		return session.ToolResult{}, err
+	}
+	return session.TextResult(formatProcessOutput(p)), nil
+}
@@ -0,0 +1,127 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProcessTools(allowed ...string) (*ProcessManager, *StartProcessTool, *ReadProcessOutputTool, *SendProcessInputTool, *StopProcessTool) {
+	m := NewProcessManager(1024, nil)
+	return m, &StartProcessTool{manager: m, allowedCommands: allowed},
+		&ReadProcessOutputTool{manager: m}, &SendProcessInputTool{manager: m}, &StopProcessTool{manager: m}
+}
+
+func TestProcessInputAndOutput(t *testing.T) {
+	m, start, read, send, _ := newProcessTools("cat")
+	defer m.StopAll()
+	ctx := context.Background()
+
+	out, err := text(start.Execute(ctx, map[string]interface{}{"command": "cat"}))
+	if err != nil {
+		t.Fatalf("start_process: %v", err)
+	}
+	if !strings.HasPrefix(out, "Started process p1 (PID ") {
+		t.Fatalf("unexpected start output %q", out)
+	}
+
+	if _, err := send.Execute(ctx, map[string]interface{}{"id": "p1", "input": "ping\n"}); err != nil {
+		t.Fatalf("send_process_input: %v", err)
+	}
+	out, err = text(read.Execute(ctx, map[string]interface{}{"id": "p1", "wait_seconds": 5.0}))
+	if err != nil {
+		t.Fatalf("read_process_output: %v", err)
+	}
+	if !strings.Contains(out, "is running") || !strings.Contains(out, "ping") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	// Output is only returned once.
+	out, _ = text(read.Execute(ctx, map[string]interface{}{"id": "p1"}))
+	if !strings.Contains(out, "No new output") {
+		t.Errorf("expected no new output, got %q", out)
+	}
+
+	// Closing stdin lets cat exit.
+	if _, err := send.Execute(ctx, map[string]interface{}{"id": "p1", "close": true}); err != nil {
+		t.Fatalf("send_process_input: %v", err)
+	}
+	out, _ = text(read.Execute(ctx, map[string]interface{}{"id": "p1", "wait_seconds": 5.0}))
+	if !strings.Contains(out, "exited with code 0") {
+		t.Errorf("expected process to exit, got %q", out)
+	}
+}
+
+func TestStopProcess(t *testing.T) {
+	m, start, read, _, stop := newProcessTools("sleep .*")
+	defer m.StopAll()
+	ctx := context.Background()
+
+	if _, err := start.Execute(ctx, map[string]interface{}{"command": "sleep 30"}); err != nil {
+		t.Fatalf("start_process: %v", err)
+	}
+	begin := time.Now()
+	out, err := text(stop.Execute(ctx, map[string]interface{}{"id": "p1"}))
+	if err != nil {
+		t.Fatalf("stop_process: %v", err)
+	}
+	if !strings.Contains(out, "terminated") || time.Since(begin) > processStopGrace {
+		t.Errorf("process not terminated promptly: %q", out)
+	}
+	if _, err := read.Execute(ctx, map[string]interface{}{"id": "p1"}); err == nil {
+		t.Error("expected stopped process to be forgotten")
+	}
+}
+
+func TestStartProcessEnforcesAllowlist(t *testing.T) {
+	m, start, _, _, _ := newProcessTools("sleep .*")
+	defer m.StopAll()
+	for _, command := range []string{"rm -rf x", "sleep 1; rm -rf x", "sleep 1 | cat", "sleep 1 > out.txt"} {
+		if _, err := start.Execute(context.Background(), map[string]interface{}{"command": command}); err == nil {
+			t.Errorf("start_process(%q) succeeded, want error", command)
+		}
+	}
+	if len(m.List()) != 0 {
+		t.Error("rejected commands must not start processes")
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	m, start, _, _, _ := newProcessTools("sleep .*")
+	for i := 0; i < 3; i++ {
+		if _, err := start.Execute(context.Background(), map[string]interface{}{"command": "sleep 30"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processes := m.List()
+	m.StopAll()
+	for _, p := range processes {
+		if !p.exited() {
+			t.Errorf("process %s still running after StopAll", p.id)
+		}
+	}
+	if len(m.List()) != 0 {
+		t.Error("StopAll must forget all processes")
+	}
+}
+
+func TestSendProcessInputCancelled(t *testing.T) {
+	m, start, _, send, _ := newProcessTools("sleep .*")
+	defer m.StopAll()
+
+	if _, err := start.Execute(context.Background(), map[string]interface{}{"command": "sleep 30"}); err != nil {
+		t.Fatalf("start_process: %v", err)
+	}
+	// sleep never reads, so the input fills the pipe.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := send.Execute(ctx, map[string]interface{}{"id": "p1", "input": strings.Repeat("x", 1<<20)})
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("send_process_input = %v, want a cancellation", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("send_process_input returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+// maxListedResources bounds the resources listed in the description of
+// read_resource, which is sent with every request to the model.
+const maxListedResources = 50
+
+// ReadResourceTool reads a resource from an MCP server.
+type ReadResourceTool struct {
+	registry *ToolRegistry
+}
+
+func (t *ReadResourceTool) Name() string { return "read_resource" }
+
+// Description lists the resources and resource templates of the MCP servers,
+// so that the model knows what it can read. Only catalogs that are loaded or
+// cached are listed: servers are started when they are used, not to describe
+// them.
+func (t *ReadResourceTool) Description() string {
+	var entries, unknown []string
+	for _, client := range t.registry.MCPClients() {
+		if !client.LoadCached() {
+			unknown = append(unknown, client.Name)
+			continue
+		}
+		for _, r := range client.Resources() {
+			entry := fmt.Sprintf("- %s: %s", client.Name, r.URI)
+			if r.Description != "" {
+				entry += " - " + r.Description
+			} else if r.Name != "" {
+				entry += " - " + r.Name
+			}
+			entries = append(entries, entry)
+		}
+		for _, tmpl := range client.ResourceTemplates() {
+			entry := fmt.Sprintf("- %s: %s (template, fill in the {placeholders})", client.Name, tmpl.URITemplate)
+			if tmpl.Description != "" {
+				entry += " - " + tmpl.Description
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	description := "Reads a resource, such as a document or a database schema, from an MCP server. " +
+		"Args: server (string), uri (string)."
+	if len(entries) > maxListedResources {
+		entries = append(entries[:maxListedResources], "(More resources are available.)")
+	}
+	if len(entries) > 0 {
+		description += "\nAvailable resources (server: uri):\n" + strings.Join(entries, "\n")
+	}
+	if len(unknown) > 0 {
+		description += "\nThe resources of these servers are listed once they have started, e.g. when one of their tools is used: " +
+			strings.Join(unknown, ", ") + "."
+	}
+	return description
+}
+
+func (t *ReadResourceTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"server": argSchema("string", "MCP server"),
+		"uri":    argSchema("string", "Resource URI"),
+	}, "server", "uri")
+}
+
+func (t *ReadResourceTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	server, ok := args["server"].(string)
+	if !ok || server == "" {
+		return session.ToolResult{}, errors.New("missing or invalid 'server' argument")
+	}
+	uri, ok := args["uri"].(string)
+	if !ok || uri == "" {
+		return session.ToolResult{}, errors.New("missing or invalid 'uri' argument")
+	}
+	client, ok := t.registry.MCPClient(server)
+	if !ok {
+		return session.ToolResult{}, errors.New("MCP server '%s' not registered", server)
+	}
+	return client.ReadResource(ctx, uri)
+}
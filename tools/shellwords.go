@@ -0,0 +1,384 @@
+package tools
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+)
+
+// This file implements the small subset of POSIX shell syntax supported by
+// execute_command: quoting, escapes, environment assignments, pipes, the
+// `&&`, `||` and `;` operators, file redirections and filename globs.
+// Commands are executed directly rather than through a shell, so anything
+// else a shell would interpret (variable expansion, command substitution,
+// subshells, background jobs) is rejected instead of being silently mangled.
+
+// shellWord is a single word of a command line after quote removal.
+type shellWord struct {
+	text    string // The word with quotes removed
+	pattern string // The word as a glob pattern, with quoted metacharacters escaped
+	glob    bool   // Whether the word contains unquoted glob metacharacters
+}
+
+// redirect is a file redirection such as `> out.txt`, `2>> err.log`, `< in.txt` or `2>&1`.
+type redirect struct {
+	fd     int    // File descriptor being redirected: 0, 1 or 2
+	op     string // One of "<", ">", ">>", ">&" (duplicate) or "&>" (stdout and stderr)
+	target string // File path, or the descriptor number for ">&"
+}
+
+// simpleCommand is one command of a pipeline.
+type simpleCommand struct {
+	env       []string // NAME=value assignments preceding the command
+	args      []shellWord
+	redirects []redirect
+}
+
+// String returns the command as matched against the allowlist and the
+// approval rules: environment assignments, the arguments and the
+// redirections, separated by single spaces, e.g. "go test ./... > log 2>&1".
+func (c *simpleCommand) String() string {
+	parts := append([]string(nil), c.env...)
+	for _, arg := range c.args {
+		parts = append(parts, arg.text)
+	}
+	for _, r := range c.redirects {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// String returns the redirection as written in a command line, e.g. "> out.txt"
+// or "2>&1".
+func (r redirect) String() string {
+	op, fd := r.op, 1
+	if r.op == "<" {
+		fd = 0
+	}
+	if r.fd != fd && r.op != "&>" {
+		op = strconv.Itoa(r.fd) + op
+	}
+	if r.op == ">&" {
+		return op + r.target
+	}
+	return op + " " + r.target
+}
+
+// pipeline is a sequence of commands connected with `|`.
+type pipeline struct {
+	commands []*simpleCommand
+}
+
+// chainItem is a pipeline together with the operator that precedes it in the
+// command line ("" for the first one, otherwise "&&", "||" or ";").
+type chainItem struct {
+	op       string
+	pipeline *pipeline
+}
+
+var assignmentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenOperator
+	tokenRedirect
+)
+
+type shellToken struct {
+	kind tokenKind
+	word shellWord
+	op   string
+	fd   int
+}
+
+// parseCommandLine parses a command line into a chain of pipelines.
+func parseCommandLine(line string) ([]chainItem, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []chainItem
+	var current *pipeline
+	var cmd *simpleCommand
+	nextOp := ""
+
+	finishCommand := func() error {
+		if cmd == nil || len(cmd.args) == 0 {
+			return errors.New("syntax error: empty command in '%s'", line)
+		}
+		if current == nil {
+			current = &pipeline{}
+		}
+		current.commands = append(current.commands, cmd)
+		cmd = nil
+		return nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.kind {
+		case tokenWord:
+			if cmd == nil {
+				cmd = &simpleCommand{}
+			}
+			if len(cmd.args) == 0 && assignmentRe.MatchString(tok.word.text) {
+				cmd.env = append(cmd.env, tok.word.text)
+			} else {
+				cmd.args = append(cmd.args, tok.word)
+			}
+		case tokenRedirect:
+			if i+1 >= len(tokens) || tokens[i+1].kind != tokenWord {
+				return nil, errors.New("syntax error: missing target for redirection '%s'", tok.op)
+			}
+			i++
+			target := tokens[i].word.text
+			if tok.op == ">&" && target != "1" && target != "2" {
+				return nil, errors.New("unsupported redirection '%d>&%s'", tok.fd, target)
+			}
+			if cmd == nil {
+				cmd = &simpleCommand{}
+			}
+			cmd.redirects = append(cmd.redirects, redirect{fd: tok.fd, op: tok.op, target: target})
+		case tokenOperator:
+			if tok.op == ";" && cmd == nil && current == nil {
+				// Ignore empty statements such as leading or trailing newlines.
+				continue
+			}
+			if err := finishCommand(); err != nil {
+				return nil, err
+			}
+			if tok.op == "|" {
+				continue
+			}
+			chain = append(chain, chainItem{op: nextOp, pipeline: current})
+			current, nextOp = nil, tok.op
+		}
+	}
+
+	switch {
+	case cmd != nil:
+		if err := finishCommand(); err != nil {
+			return nil, err
+		}
+	case current != nil:
+		// A pipeline cannot end with `|`.
+		return nil, errors.New("syntax error: command line ends with '|'")
+	case nextOp == "&&" || nextOp == "||":
+		return nil, errors.New("syntax error: command line ends with '%s'", nextOp)
+	}
+	if current != nil {
+		chain = append(chain, chainItem{op: nextOp, pipeline: current})
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return chain, nil
+}
+
+// tokenize splits a command line into words, operators and redirections.
+func tokenize(line string) ([]shellToken, error) {
+	var tokens []shellToken
+	var text, pattern strings.Builder
+	inWord, glob, quoted := false, false, false
+
+	endWord := func() {
+		if inWord {
+			tokens = append(tokens, shellToken{kind: tokenWord, word: shellWord{text: text.String(), pattern: pattern.String(), glob: glob}})
+		}
+		text.Reset()
+		pattern.Reset()
+		inWord, glob, quoted = false, false, false
+	}
+	addLiteral := func(r rune) {
+		inWord = true
+		text.WriteRune(r)
+		if strings.ContainsRune(`*?[]\`, r) {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	addOperator := func(op string) {
+		endWord()
+		tokens = append(tokens, shellToken{kind: tokenOperator, op: op})
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case r == ' ' || r == '\t':
+			endWord()
+		case r == '\n':
+			addOperator(";")
+		case r == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			i--
+		case r == '\'':
+			inWord, quoted = true, true
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				addLiteral(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("syntax error: unterminated single quote")
+			}
+		case r == '"':
+			inWord, quoted = true, true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				c := runes[i]
+				switch {
+				case c == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]):
+					i++
+					if runes[i] != '\n' {
+						addLiteral(runes[i])
+					}
+				case c == '$' || c == '`':
+					return nil, errors.New("variable expansion and command substitution are not supported; quote '%c' with single quotes or a backslash", c)
+				default:
+					addLiteral(c)
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("syntax error: unterminated double quote")
+			}
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				if runes[i] != '\n' {
+					addLiteral(runes[i])
+				}
+			}
+		case r == '$' || r == '`':
+			return nil, errors.New("variable expansion and command substitution are not supported; quote '%c' with single quotes or a backslash", r)
+		case r == '(' || r == ')':
+			return nil, errors.New("subshells are not supported")
+		case r == '|':
+			if next == '|' {
+				i++
+				addOperator("||")
+			} else {
+				addOperator("|")
+			}
+		case r == '&':
+			switch next {
+			case '&':
+				i++
+				addOperator("&&")
+			case '>':
+				i++
+				endWord()
+				tokens = append(tokens, shellToken{kind: tokenRedirect, op: "&>", fd: 1})
+			default:
+				return nil, errors.New("background execution with '&' is not supported")
+			}
+		case r == ';':
+			if next == ';' {
+				return nil, errors.New("syntax error: unexpected ';;'")
+			}
+			addOperator(";")
+		case r == '>' || r == '<':
+			fd := 1
+			if r == '<' {
+				fd = 0
+			}
+			// A word made only of digits right before the operator is the
+			// descriptor being redirected, as in `2>err.log`.
+			if inWord && !quoted && !glob {
+				n, err := strconv.Atoi(text.String())
+				if err != nil {
+					endWord()
+				} else {
+					fd = n
+					text.Reset()
+					pattern.Reset()
+					inWord = false
+				}
+			}
+			endWord()
+			if fd > 2 {
+				return nil, errors.New("redirection of file descriptor %d is not supported", fd)
+			}
+			op := string(r)
+			if r == '>' && (next == '>' || next == '&') {
+				op += string(next)
+				i++
+			}
+			tokens = append(tokens, shellToken{kind: tokenRedirect, op: op, fd: fd})
+		default:
+			inWord = true
+			text.WriteRune(r)
+			pattern.WriteRune(r)
+			if strings.ContainsRune("*?[", r) {
+				glob = true
+			}
+		}
+	}
+	endWord()
+	return tokens, nil
+}
+
+// expandWords returns the arguments of a command, expanding unquoted globs
+// relative to dir. Like the shell, a glob without matches is kept as is, and
+// names starting with a dot are only matched by patterns that start with one.
+func expandWords(words []shellWord, dir string) ([]string, error) {
+	var args []string
+	for _, w := range words {
+		if !w.glob {
+			args = append(args, w.text)
+			continue
+		}
+		pattern := w.pattern
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid glob pattern '%s'", w.text)
+		}
+		matches = slices.DeleteFunc(matches, func(m string) bool { return matchesDotfile(pattern, m) })
+		if len(matches) == 0 {
+			args = append(args, w.text)
+			continue
+		}
+		for _, m := range matches {
+			if !filepath.IsAbs(w.pattern) {
+				if rel, err := filepath.Rel(dir, m); err == nil {
+					m = rel
+				}
+			}
+			args = append(args, m)
+		}
+	}
+	return args, nil
+}
+
+// matchesDotfile reports whether match, a result of filepath.Glob(pattern),
+// has a name starting with a dot where the pattern has none, as in ".env" for
+// "*", which the shell would not have matched.
+func matchesDotfile(pattern, match string) bool {
+	patternParts := strings.Split(pattern, string(filepath.Separator))
+	matchParts := strings.Split(match, string(filepath.Separator))
+	if len(patternParts) != len(matchParts) {
+		return false
+	}
+	for i, part := range matchParts {
+		if strings.HasPrefix(part, ".") && !strings.HasPrefix(patternParts[i], ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	chain, err := parseCommandLine(`FOO=bar go test -run 'Test A' ./... 2>&1 | grep "ok \"x\"" && echo done; ls > out.txt`)
+	if err != nil {
+		t.Fatalf("parseCommandLine: %v", err)
+	}
+
+	var got [][]string
+	var ops []string
+	for _, item := range chain {
+		ops = append(ops, item.op)
+		var cmds []string
+		for _, c := range item.pipeline.commands {
+			cmds = append(cmds, c.String())
+		}
+		got = append(got, cmds)
+	}
+
+	want := [][]string{
+		{"FOO=bar go test -run Test A ./... 2>&1", `grep ok "x"`},
+		{"echo done"},
+		{"ls > out.txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if wantOps := []string{"", "&&", ";"}; !reflect.DeepEqual(ops, wantOps) {
+		t.Errorf("operators = %q, want %q", ops, wantOps)
+	}
+
+	first := chain[0].pipeline.commands[0]
+	if len(first.args) != 5 || first.args[3].text != "Test A" {
+		t.Errorf("quoted argument not kept as one word: %+v", first.args)
+	}
+	if wantRedirects := []redirect{{fd: 2, op: ">&", target: "1"}}; !reflect.DeepEqual(first.redirects, wantRedirects) {
+		t.Errorf("redirects = %+v, want %+v", first.redirects, wantRedirects)
+	}
+	if last := chain[2].pipeline.commands[0]; !reflect.DeepEqual(last.redirects, []redirect{{fd: 1, op: ">", target: "out.txt"}}) {
+		t.Errorf("redirects = %+v", last.redirects)
+	}
+}
+
+func TestParseCommandLineRejectsUnsupported(t *testing.T) {
+	for _, line := range []string{
+		"echo $HOME",
+		"echo \"$(rm -rf /)\"",
+		"echo `id`",
+		"(cd /tmp && ls)",
+		"sleep 10 &",
+		"ls |",
+		"ls &&",
+		"echo 'unterminated",
+		"",
+	} {
+		if _, err := parseCommandLine(line); err == nil {
+			t.Errorf("parseCommandLine(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestExpandWordsSkipsDotfiles(t *testing.T) {
+	chdir(t, t.TempDir())
+	for _, name := range []string{".env", "main.go", "sub/.hidden", "sub/visible"} {
+		os.MkdirAll(filepath.Dir(name), 0755)
+		os.WriteFile(name, nil, 0644)
+	}
+	for pattern, want := range map[string][]string{
+		"*":       {"main.go", "sub"},
+		"sub/*":   {"sub/visible"},
+		".*":      {".env"},
+		"sub/.h*": {"sub/.hidden"},
+	} {
+		chain, err := parseCommandLine("ls " + pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := expandWords(chain[0].pipeline.commands[0].args, ".")
+		if err != nil || !reflect.DeepEqual(got[1:], want) {
+			t.Errorf("expandWords(%q) = %q, %v; want %q", pattern, got[1:], err, want)
+		}
+	}
+}
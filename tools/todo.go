@@ -0,0 +1,98 @@
+package tools
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/session"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+)
+
+// TodoWriteTool replaces the plan kept in the session with a new todo list.
+type TodoWriteTool struct{}
+
+func (t *TodoWriteTool) Name() string { return "todo_write" }
+func (t *TodoWriteTool) Description() string {
+	return "Writes the todo list used to plan and track a multi-step task, replacing the previous list. " +
+		"Use it for tasks with three or more steps: create the list up front, mark an item in_progress " +
+		"before starting it (only one at a time) and completed as soon as it is done. " +
+		"Args: todos (list of objects with id (string), content (string), " +
+		"status (pending, in_progress, completed or cancelled), [priority (high, medium or low, default medium)])."
+}
+
+func (t *TodoWriteTool) Schema() *jsonschema.Schema {
+	return objectSchema(map[string]*jsonschema.Schema{
+		"todos": {
+			Type: "array",
+			Items: objectSchema(map[string]*jsonschema.Schema{
+				"id":       {Types: []string{"string", "number"}},
+				"content":  argSchema("string", ""),
+				"status":   {Type: "string", Enum: []any{"pending", "in_progress", "completed", "cancelled"}},
+				"priority": {Type: "string", Enum: []any{"high", "medium", "low"}},
+			}, "id", "content", "status"),
+		},
+	}, "todos")
+}
+
+func (t *TodoWriteTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	sess := session.FromContext(ctx)
+	if sess == nil {
+		return session.ToolResult{}, errors.New("todo_write is only available in a session")
+	}
+	raw, ok := args["todos"].([]interface{})
+	if !ok {
+		return session.ToolResult{}, errors.New("missing or invalid 'todos' argument: must be a list of objects")
+	}
+
+	todos := make([]session.TodoItem, 0, len(raw))
+	for i, entry := range raw {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			return session.ToolResult{}, errors.New("invalid todo %d: must be an object", i+1)
+		}
+		item := session.TodoItem{Priority: session.PriorityMedium}
+		for name, dst := range map[string]*string{"id": &item.ID, "content": &item.Content, "status": &item.Status, "priority": &item.Priority} {
+			switch v := fields[name].(type) {
+			case nil:
+			case string:
+				if v != "" {
+					*dst = strings.TrimSpace(v)
+				}
+			case float64:
+				// Models often number their todos.
+				*dst = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return session.ToolResult{}, errors.New("invalid '%s' of todo %d: must be a string", name, i+1)
+			}
+		}
+		todos = append(todos, item)
+	}
+	if err := session.ValidateTodos(todos); err != nil {
+		return session.ToolResult{}, err
+	}
+
+	sess.Todos = todos
+	return session.TextResult("Todo list updated:\n" + session.RenderTodos(todos)), nil
+}
+
+// TodoReadTool returns the plan kept in the session.
+type TodoReadTool struct{}
+
+func (t *TodoReadTool) Name() string { return "todo_read" }
+func (t *TodoReadTool) Description() string {
+	return "Reads the current todo list. Args: none."
+}
+
+func (t *TodoReadTool) Schema() *jsonschema.Schema {
+	return objectSchema(nil)
+}
+
+func (t *TodoReadTool) Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error) {
+	sess := session.FromContext(ctx)
+	if sess == nil {
+		return session.ToolResult{}, errors.New("todo_read is only available in a session")
+	}
+	return session.TextResult(session.RenderTodos(sess.Todos)), nil
+}
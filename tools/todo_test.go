@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/m4xw311/compell/session"
+)
+
+func TestTodoTools(t *testing.T) {
+	sess := &session.Session{}
+	ctx := session.WithSession(context.Background(), sess)
+
+	out, err := text((&TodoWriteTool{}).Execute(ctx, map[string]interface{}{
+		"todos": []interface{}{
+			map[string]interface{}{"id": 1.0, "content": "Write the parser", "status": "completed"},
+			map[string]interface{}{"id": "2", "content": "Add tests", "status": "in_progress", "priority": "high"},
+			map[string]interface{}{"id": "3", "content": "Update docs", "status": "pending", "priority": "low"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[x] 1. Write the parser\n[~] 2. Add tests (high)\n[ ] 3. Update docs (low)"
+	if !strings.HasSuffix(out, want) {
+		t.Errorf("todo_write output:\n%s\nwant suffix:\n%s", out, want)
+	}
+	if len(sess.Todos) != 3 || sess.Todos[0].ID != "1" || sess.Todos[0].Priority != session.PriorityMedium {
+		t.Errorf("unexpected todos in session: %+v", sess.Todos)
+	}
+
+	if out, err := text((&TodoReadTool{}).Execute(ctx, nil)); err != nil || out != want {
+		t.Errorf("todo_read = %q, %v; want %q", out, err, want)
+	}
+
+	for _, todos := range [][]interface{}{
+		{map[string]interface{}{"id": "1", "content": "x", "status": "done"}},
+		{map[string]interface{}{"id": "1", "content": "x", "status": "pending"}, map[string]interface{}{"id": "1", "content": "y", "status": "pending"}},
+		{map[string]interface{}{"id": "1", "content": "x", "status": "in_progress"}, map[string]interface{}{"id": "2", "content": "y", "status": "in_progress"}},
+		{"not an object"},
+	} {
+		if _, err := (&TodoWriteTool{}).Execute(ctx, map[string]interface{}{"todos": todos}); err == nil {
+			t.Errorf("expected an error for %v", todos)
+		}
+	}
+	if len(sess.Todos) != 3 {
+		t.Errorf("invalid update changed the todo list: %+v", sess.Todos)
+	}
+
+	if _, err := (&TodoReadTool{}).Execute(context.Background(), nil); err == nil {
+		t.Error("expected an error without a session")
+	}
+}
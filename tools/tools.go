@@ -2,33 +2,88 @@ package tools
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/m4xw311/compell/config"
 	"github.com/m4xw311/compell/errors"
+	"github.com/m4xw311/compell/sandbox"
+	"github.com/m4xw311/compell/secrets"
+	"github.com/m4xw311/compell/session"
 	"github.com/m4xw311/compell/tools/mcp"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
 )
 
-// Tool defines the interface for any action the agent can take.
+// Tool defines the interface for any action the agent can take. Execute
+// returns an error when the call could not be carried out; the agent reports
+// it to the model as a failed result. Tools may also return results with
+// IsError set, e.g. for a command that exited with a non-zero status.
 type Tool interface {
 	Name() string
 	Description() string
-	Execute(ctx context.Context, args map[string]interface{}) (string, error)
+	Execute(ctx context.Context, args map[string]interface{}) (session.ToolResult, error)
+}
+
+// Previewer is implemented by tools that can describe the effect of a call
+// before it runs, such as the diff a write would make. The agent shows the
+// preview when asking the user to approve the call, together with the
+// arguments not among PreviewedArgs.
+type Previewer interface {
+	Preview(args map[string]interface{}) (string, error)
+	PreviewedArgs() []string
+}
+
+// Schemer is implemented by tools that describe their arguments with a JSON
+// schema, such as the built-in tools. The schema is served to MCP clients
+// (see the mcpserver package); tools without one take any object.
+type Schemer interface {
+	Schema() *jsonschema.Schema
+}
+
+// objectSchema returns the schema of an object of arguments with the given
+// properties, of which those named in required must be given.
+func objectSchema(properties map[string]*jsonschema.Schema, required ...string) *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "object", Properties: properties, Required: required}
+}
+
+// argSchema returns the schema of an argument of the JSON type typ.
+func argSchema(typ, description string) *jsonschema.Schema {
+	return &jsonschema.Schema{Type: typ, Description: description}
+}
+
+// stringListSchema returns the schema of an argument holding a list of
+// strings.
+func stringListSchema(description string) *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "array", Items: &jsonschema.Schema{Type: "string"}, Description: description}
 }
 
 // ToolRegistry holds all available tools.
 type ToolRegistry struct {
 	tools      map[string]Tool
 	mcpClients map[string]*mcp.MCPClient
+	processes  *ProcessManager
 }
 
 func NewToolRegistry(cfg *config.Config) *ToolRegistry {
+	sb, err := sandbox.New(&cfg.Sandbox, &cfg.FilesystemAccess)
+	if err != nil {
+		// Never fall back to running commands unsandboxed.
+		fmt.Printf("ERROR: Failed to set up the command sandbox, commands will not run: %v\n", err)
+		sb = sandbox.Unavailable(err)
+	}
+
 	r := &ToolRegistry{
 		tools:      make(map[string]Tool),
 		mcpClients: make(map[string]*mcp.MCPClient),
+		processes:  NewProcessManager(cfg.CommandExecution.MaxOutputBytes, sb),
 	}
 
 	// Register default tools
@@ -38,22 +93,179 @@ func NewToolRegistry(cfg *config.Config) *ToolRegistry {
 	r.Register(&CreateDirTool{fsAccess: &cfg.FilesystemAccess})
 	r.Register(&DeleteFileTool{fsAccess: &cfg.FilesystemAccess})
 	r.Register(&DeleteDirTool{fsAccess: &cfg.FilesystemAccess})
-	r.Register(&ExecuteCommandTool{allowedCommands: cfg.AllowedCommands})
+	r.Register(NewExecuteCommandTool(cfg, sb))
+	r.Register(&StartProcessTool{
+		manager:         r.processes,
+		allowedCommands: cfg.AllowedCommands,
+		fsAccess:        &cfg.FilesystemAccess,
+		envFilter:       cfg.ToolEnvFilters["start_process"],
+	})
+	r.Register(&ReadProcessOutputTool{manager: r.processes})
+	r.Register(&SendProcessInputTool{manager: r.processes})
+	r.Register(&StopProcessTool{manager: r.processes})
+	git := &gitRunner{fsAccess: &cfg.FilesystemAccess, maxOutputBytes: cfg.CommandExecution.MaxOutputBytes}
+	r.Register(&GitStatusTool{git: git})
+	r.Register(&GitDiffTool{git: git})
+	r.Register(&GitLogTool{git: git})
+	r.Register(&GitShowTool{git: git})
+	r.Register(&GitBlameTool{git: git})
+	r.Register(&GitCommitTool{git: git})
+	r.Register(&GitBranchTool{git: git})
+	r.Register(NewFetchURLTool(cfg))
+	r.Register(&TodoWriteTool{})
+	r.Register(&TodoReadTool{})
+	r.Register(&ReadResourceTool{registry: r})
 	// Add other tools like ReadRepo here...
 
-	// Initialize MCP clients and register their tools
+	// MCP servers are started when their tools are first needed.
 	for _, mcpServer := range cfg.AdditionalMCPServers {
-		client, err := mcp.NewMCPClient(mcpServer.Name, mcpServer.Command, mcpServer.Args)
+		r.mcpClients[mcpServer.Name] = newMCPClient(mcpServer)
+	}
+
+	return r
+}
+
+// newMCPClient returns the client of an MCP server according to its
+// transport. The server is not started or connected to yet. Its catalog is
+// cached under .compell/cache/mcp, so that its tools can be offered without
+// starting it.
+func newMCPClient(server config.MCPServer) *mcp.MCPClient {
+	key := sha256.Sum256([]byte(strings.Join(append([]string{server.Transport, server.Command, server.Cwd, server.URL}, server.Args...), "\x00")))
+	opts := mcp.Options{
+		CallTimeout: server.Timeout,
+		CacheFile:   filepath.Join(".compell", "cache", "mcp", url.PathEscape(server.Name)+".json"),
+		CacheKey:    hex.EncodeToString(key[:]),
+	}
+	// Servers that ask for roots are pointed at the workspace.
+	if workspace, err := os.Getwd(); err == nil {
+		opts.Roots = []string{workspace}
+	}
+	switch server.Transport {
+	case "", config.MCPTransportStdio:
+		args, env, dir, err := mcpServerProcess(server, os.Environ(), os.LookupEnv)
 		if err != nil {
-			// In a real application, you might want to handle this more gracefully
-			// than just printing, but for now, this is fine.
-			fmt.Printf("ERROR: Failed to initialize MCP client for '%s': %v\n", mcpServer.Name, err)
-			continue
+			return mcp.NewFailedClient(server.Name, err)
+		}
+		return mcp.NewStdioClient(server.Name, server.Command, args, env, dir, opts)
+	}
+	// Unset variables are reported when the server is used, like other
+	// failures to connect.
+	serverURL, err := secrets.ExpandEnv(server.URL, os.LookupEnv)
+	if err != nil {
+		return mcp.NewFailedClient(server.Name, errors.Wrapf(err, "invalid url of MCP server '%s'", server.Name))
+	}
+	headers := make(map[string]string, len(server.Headers))
+	for name, value := range server.Headers {
+		if headers[name], err = secrets.ExpandEnv(value, os.LookupEnv); err != nil {
+			return mcp.NewFailedClient(server.Name, errors.Wrapf(err, "invalid header '%s' of MCP server '%s'", name, server.Name))
+		}
+	}
+	client, err := mcp.NewHTTPClient(server.Name, server.Transport, serverURL, headers, opts)
+	if err != nil {
+		return mcp.NewFailedClient(server.Name, err)
+	}
+	return client
+}
+
+// mcpServerProcess returns the arguments, environment and working directory
+// of a local MCP server, with ${VAR} references replaced. The server inherits
+// only the variables most programs need, those its env_filter allows and those
+// named in env_from; env adds further ones. Errors name variables but never
+// include values, so that secrets do not end up in the output.
+func mcpServerProcess(server config.MCPServer, environ []string, lookup func(string) (string, bool)) (args, env []string, dir string, err error) {
+	for i, arg := range server.Args {
+		expanded, err := secrets.ExpandEnv(arg, lookup)
+		if err != nil {
+			return nil, nil, "", errors.Wrapf(err, "invalid argument %d of MCP server '%s'", i+1, server.Name)
 		}
-		r.mcpClients[mcpServer.Name] = client
+		args = append(args, expanded)
 	}
 
-	return r
+	env = secrets.ScrubEnv(environ, server.EnvFilter)
+	for _, name := range server.EnvFrom {
+		// Variables that are not set are left out, like inherited ones.
+		if value, ok := lookup(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	names := make([]string, 0, len(server.Env))
+	for name := range server.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := secrets.ExpandEnv(server.Env[name], lookup)
+		if err != nil {
+			return nil, nil, "", errors.Wrapf(err, "invalid env '%s' of MCP server '%s'", name, server.Name)
+		}
+		// Later entries take precedence over inherited ones.
+		env = append(env, name+"="+value)
+	}
+
+	if dir, err = secrets.ExpandEnv(server.Cwd, lookup); err != nil {
+		return nil, nil, "", errors.Wrapf(err, "invalid cwd of MCP server '%s'", server.Name)
+	}
+	return args, env, dir, nil
+}
+
+// MCPServerSecrets returns the credentials cfg hands to MCP servers, so that
+// they can be redacted: the values of the env variables that look like
+// credentials, of the variables passed with env_from, which are picked for
+// the server whatever their names, and of the headers that carry credentials,
+// such as Authorization.
+func MCPServerSecrets(cfg *config.Config) []string {
+	var values []string
+	for _, server := range cfg.AdditionalMCPServers {
+		for name, value := range server.Env {
+			if !secrets.IsSecretEnv(name) {
+				continue
+			}
+			if expanded, err := secrets.ExpandEnv(value, os.LookupEnv); err == nil {
+				values = append(values, expanded)
+			}
+		}
+		for _, name := range server.EnvFrom {
+			if value, ok := os.LookupEnv(name); ok {
+				values = append(values, value)
+			}
+		}
+		for name, value := range server.Headers {
+			if !isSecretHeader(name) {
+				continue
+			}
+			expanded, err := secrets.ExpandEnv(value, os.LookupEnv)
+			if err != nil {
+				continue
+			}
+			values = append(values, expanded)
+			// The credential also shows up without its scheme, as in
+			// "Bearer <token>".
+			if fields := strings.Fields(expanded); len(fields) > 1 {
+				values = append(values, fields[len(fields)-1])
+			}
+		}
+	}
+	return values
+}
+
+// isSecretHeader reports whether the named HTTP header carries credentials:
+// Authorization, Cookie and the like, or a name such as X-API-Key that would
+// look like a credential as an environment variable.
+func isSecretHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
+	}
+	return secrets.IsSecretEnv(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Close stops the background processes started through the registry's tools
+// and the MCP servers.
+func (r *ToolRegistry) Close() {
+	r.processes.StopAll()
+	for _, client := range r.MCPClients() {
+		client.Stop()
+	}
 }
 
 func (r *ToolRegistry) Register(t Tool) {
@@ -65,49 +277,229 @@ func (r *ToolRegistry) GetTool(name string) (Tool, bool) {
 	return t, ok
 }
 
-// GetActiveTools returns the tool instances for a given toolset.
-func (r *ToolRegistry) GetActiveTools(ts *config.Toolset) ([]Tool, error) {
-	var activeTools []Tool
-	for _, toolName := range ts.Tools {
-		// Handle MCP tools like <server>:<tool> or <server>.* for wildcards
-		if strings.Contains(toolName, ".") {
-			parts := strings.SplitN(toolName, ".", 2)
-			if len(parts) != 2 {
-				return nil, errors.New("invalid MCP tool format '%s' in toolset '%s'", toolName, ts.Name)
-			}
-			serverName, mcpToolName := parts[0], parts[1]
+// BuiltinTools returns the registered tools, without those of MCP servers,
+// sorted by name.
+func (r *ToolRegistry) BuiltinTools() []Tool {
+	builtin := make([]Tool, 0, len(r.tools))
+	for _, t := range r.tools {
+		builtin = append(builtin, t)
+	}
+	sort.Slice(builtin, func(i, j int) bool { return builtin[i].Name() < builtin[j].Name() })
+	return builtin
+}
 
-			client, ok := r.mcpClients[serverName]
-			if !ok {
-				return nil, errors.New("MCP server '%s' for tool '%s' not registered", serverName, toolName)
-			}
+// addMCPClient registers an MCP server.
+func (r *ToolRegistry) addMCPClient(client *mcp.MCPClient) {
+	r.mcpClients[client.Name] = client
+}
 
-			// Handle wildcard pattern
-			if mcpToolName == "*" {
-				// Add all tools from this MCP server
-				for _, t := range client.GetAllTools() {
-					activeTools = append(activeTools, t)
-				}
+// MCPClient returns the client of the named MCP server.
+func (r *ToolRegistry) MCPClient(name string) (*mcp.MCPClient, bool) {
+	client, ok := r.mcpClients[name]
+	return client, ok
+}
+
+// MCPClients returns the clients of all MCP servers, sorted by name.
+func (r *ToolRegistry) MCPClients() []*mcp.MCPClient {
+	clients := make([]*mcp.MCPClient, 0, len(r.mcpClients))
+	for _, client := range r.mcpClients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Name < clients[j].Name })
+	return clients
+}
+
+// SetMCPSampler lets the MCP servers ask for messages from the model through
+// sampler. It must be called before the servers are started.
+func (r *ToolRegistry) SetMCPSampler(sampler mcp.Sampler) {
+	for _, client := range r.MCPClients() {
+		client.SetSampler(sampler)
+	}
+}
+
+// RefreshMCPTools lists the tools of the MCP servers that announced changes
+// again. It reports whether any tools changed, in which case the active tools
+// of toolsets should be looked up again.
+func (r *ToolRegistry) RefreshMCPTools() bool {
+	refreshed := false
+	for _, client := range r.MCPClients() {
+		changed, err := client.Refresh()
+		if err != nil {
+			fmt.Printf("Warning: Failed to refresh the tools of MCP server '%s': %v\n", client.Name, err)
+		}
+		refreshed = refreshed || changed
+	}
+	return refreshed
+}
+
+// LookupMCPTool returns the server and tool name of the MCP tool with the
+// given qualified name.
+func (r *ToolRegistry) LookupMCPTool(name string) (server, tool string, ok bool) {
+	t, _, err := r.mcpTool(name)
+	if t == nil || err != nil {
+		return "", "", false
+	}
+	return t.ServerName(), t.ToolName(), true
+}
+
+// mcpTool returns the MCP tool with the given qualified name. Only the
+// servers whose tools can have the name are loaded. unavailable reports
+// whether one of them could not be loaded. Distinct tools with the same name
+// are an error, so that neither is picked by mistake.
+func (r *ToolRegistry) mcpTool(name string) (tool *mcp.MCPTool, unavailable bool, err error) {
+	for _, client := range r.MCPClients() {
+		if !strings.HasPrefix(name, mcp.QualifiedPrefix(client.Name)) {
+			continue
+		}
+		if !loadMCPClient(client) {
+			unavailable = true
+			continue
+		}
+		for _, t := range client.GetAllTools() {
+			if t.Name() != name {
 				continue
 			}
+			if tool != nil {
+				return nil, false, errors.New("MCP tools '%s.%s' and '%s.%s' are both named '%s'; refer to them as <server>.<tool> and alias one of them",
+					tool.ServerName(), tool.ToolName(), t.ServerName(), t.ToolName(), name)
+			}
+			tool = t
+		}
+	}
+	return tool, unavailable, nil
+}
+
+// loadMCPClient loads the catalog of an MCP server. A server that cannot be
+// started is reported and its tools are left out, rather than failing the
+// agent.
+func loadMCPClient(client *mcp.MCPClient) bool {
+	if err := client.Load(); err != nil {
+		fmt.Printf("Warning: Leaving out the tools of MCP server '%s': %v\n", client.Name, err)
+		return false
+	}
+	return true
+}
 
-			if t, ok := client.GetTool(mcpToolName); ok {
+// GetActiveTools returns the tool instances for a given toolset. It fails if
+// two of them would be offered to the model under the same name.
+func (r *ToolRegistry) GetActiveTools(ts *config.Toolset) ([]Tool, error) {
+	var activeTools []Tool
+	seen := make(map[Tool]bool)
+	for _, toolName := range ts.Tools {
+		found, err := r.lookup(toolName, ts)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range found {
+			// A tool may be listed both by name and through a wildcard.
+			if !seen[t] {
+				seen[t] = true
 				activeTools = append(activeTools, t)
-			} else {
-				return nil, errors.New("MCP tool '%s' not found on server '%s'", mcpToolName, serverName)
 			}
-			continue
 		}
+	}
+
+	aliases := make([]string, 0, len(ts.Aliases))
+	for alias := range ts.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if err := r.applyAlias(activeTools, alias, ts.Aliases[alias], ts); err != nil {
+			return nil, err
+		}
+	}
 
-		if t, ok := r.GetTool(toolName); ok {
-			activeTools = append(activeTools, t)
-		} else {
-			return nil, errors.New("tool '%s' from toolset '%s' is not registered", toolName, ts.Name)
+	byName := make(map[string]Tool)
+	for _, t := range activeTools {
+		if other, ok := byName[t.Name()]; ok {
+			return nil, errors.New("tool name '%s' in toolset '%s' is used by both %s and %s; give one of them an alias under 'aliases'",
+				t.Name(), ts.Name, describeTool(other), describeTool(t))
 		}
+		byName[t.Name()] = t
 	}
 	return activeTools, nil
 }
 
+// lookup resolves an entry of a toolset: a built-in tool, an MCP tool as
+// <server>.<tool> or by its qualified name, or all tools of a server as
+// <server>.*.
+func (r *ToolRegistry) lookup(toolName string, ts *config.Toolset) ([]Tool, error) {
+	if t, ok := r.GetTool(toolName); ok {
+		return []Tool{t}, nil
+	}
+	t, unavailable, err := r.mcpTool(toolName)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		return []Tool{t}, nil
+	}
+	if !strings.Contains(toolName, ".") {
+		if unavailable {
+			return nil, nil
+		}
+		return nil, errors.New("tool '%s' from toolset '%s' is not registered", toolName, ts.Name)
+	}
+
+	serverName, mcpToolName, _ := strings.Cut(toolName, ".")
+	client, ok := r.mcpClients[serverName]
+	if !ok {
+		return nil, errors.New("MCP server '%s' for tool '%s' not registered", serverName, toolName)
+	}
+	if !loadMCPClient(client) {
+		return nil, nil
+	}
+	// Handle wildcard pattern
+	if mcpToolName == "*" {
+		var found []Tool
+		for _, t := range client.GetAllTools() {
+			found = append(found, t)
+		}
+		return found, nil
+	}
+	if t, ok := client.GetTool(mcpToolName); ok {
+		return []Tool{t}, nil
+	}
+	return nil, errors.New("MCP tool '%s' not found on server '%s'", mcpToolName, serverName)
+}
+
+// applyAlias renames the active MCP tool that target refers to.
+func (r *ToolRegistry) applyAlias(activeTools []Tool, alias, target string, ts *config.Toolset) error {
+	if !validToolName.MatchString(alias) {
+		return errors.New("invalid alias '%s' in toolset '%s': use up to 64 letters, digits, '_' and '-'", alias, ts.Name)
+	}
+	found, err := r.lookup(target, ts)
+	if err != nil {
+		return errors.Wrapf(err, "invalid target of alias '%s'", alias)
+	}
+	var mcpTool *mcp.MCPTool
+	if len(found) == 1 {
+		mcpTool, _ = found[0].(*mcp.MCPTool)
+	}
+	if mcpTool == nil {
+		return errors.New("alias '%s' in toolset '%s' must refer to a single MCP tool, not '%s'", alias, ts.Name, target)
+	}
+	for i, t := range activeTools {
+		if t == Tool(mcpTool) {
+			activeTools[i] = mcpTool.WithName(alias)
+			return nil
+		}
+	}
+	return errors.New("alias '%s' in toolset '%s' refers to '%s', which is not in the toolset", alias, ts.Name, target)
+}
+
+// validToolName matches the tool names all LLM providers accept.
+var validToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// describeTool names a tool and where it comes from, for error messages.
+func describeTool(t Tool) string {
+	if mcpTool, ok := t.(*mcp.MCPTool); ok {
+		return fmt.Sprintf("MCP tool '%s.%s'", mcpTool.ServerName(), mcpTool.ToolName())
+	}
+	return fmt.Sprintf("built-in tool '%s'", t.Name())
+}
+
 // isPathRestricted checks if a path matches any of the glob patterns.
 func isPathRestricted(path string, patterns []string) (bool, error) {
 	for _, pattern := range patterns {
@@ -122,15 +514,16 @@ func isPathRestricted(path string, patterns []string) (bool, error) {
 	return false, nil
 }
 
-// isCommandAllowed checks if a command is in the allowlist (with regex support).
+// isCommandAllowed checks if a single command (without shell operators) is in
+// the allowlist. Patterns are regular expressions that must match the whole
+// command, so "git .*" does not allow "rm -rf / ; git status".
 func isCommandAllowed(command string, allowed []string) (bool, error) {
-	cmdParts := strings.Fields(command)
-	if len(cmdParts) == 0 {
+	if strings.TrimSpace(command) == "" {
 		return false, nil
 	}
 
 	for _, pattern := range allowed {
-		re, err := regexp.Compile(pattern)
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
 		if err != nil {
 			fmt.Printf("Warning: Invalid regex in allowed_commands '%s': %v\n", pattern, err)
 			// Fallback to simple string comparison if regex is invalid
@@ -1,10 +1,18 @@
 package tools
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/m4xw311/compell/config"
+	"github.com/m4xw311/compell/secrets"
 	"github.com/m4xw311/compell/tools/mcp"
+	"github.com/modelcontextprotocol/go-sdk/jsonschema"
+	mcpsdk "github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
 func TestTools(t *testing.T) {
@@ -13,6 +21,28 @@ func TestTools(t *testing.T) {
 }
 
 // TestWildcardMCPToolSupport tests the wildcard functionality for MCP tools
+func TestBuiltinSchemas(t *testing.T) {
+	registry := NewToolRegistry(&config.Config{})
+	defer registry.Close()
+	for _, tool := range registry.BuiltinTools() {
+		schemer, ok := tool.(Schemer)
+		if !ok {
+			t.Errorf("%s has no schema", tool.Name())
+			continue
+		}
+		schema := schemer.Schema()
+		if _, err := schema.Resolve(nil); err != nil || schema.Type != "object" {
+			t.Errorf("%s schema = %+v, %v", tool.Name(), schema, err)
+		}
+		// Every argument in the description is in the schema.
+		for name := range schema.Properties {
+			if !strings.Contains(tool.Description(), name) {
+				t.Errorf("%s schema has %s, which its description does not mention", tool.Name(), name)
+			}
+		}
+	}
+}
+
 func TestWildcardMCPToolSupport(t *testing.T) {
 	// Note: This is a basic test to verify that the wildcard functionality
 	// is implemented correctly in GetActiveTools. A full integration test
@@ -35,3 +65,164 @@ func TestWildcardMCPToolSupport(t *testing.T) {
 	_ = ts
 	t.Log("Wildcard MCP tool support test placeholder")
 }
+
+// newTestMCPClient connects to an MCP server that serves tools which echo
+// their name.
+func newTestMCPClient(t *testing.T, name string, toolNames ...string) *mcp.MCPClient {
+	t.Helper()
+	server := mcpsdk.NewServer(&mcpsdk.Implementation{Name: name, Version: "v0.0.1"}, nil)
+	for _, toolName := range toolNames {
+		server.AddTool(&mcpsdk.Tool{Name: toolName, InputSchema: &jsonschema.Schema{Type: "object"}},
+			func(ctx context.Context, ss *mcpsdk.ServerSession, params *mcpsdk.CallToolParamsFor[map[string]any]) (*mcpsdk.CallToolResult, error) {
+				return &mcpsdk.CallToolResult{Content: []mcpsdk.Content{&mcpsdk.TextContent{Text: name + "." + params.Name}}}, nil
+			})
+	}
+	srv := httptest.NewServer(mcpsdk.NewStreamableHTTPHandler(func(*http.Request) *mcpsdk.Server { return server }, nil))
+	t.Cleanup(srv.Close)
+	client, err := mcp.NewRemoteMCPClient(name, config.MCPTransportHTTP, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Stop() })
+	return client
+}
+
+func TestGetActiveToolsMCPNames(t *testing.T) {
+	registry := &ToolRegistry{
+		tools:      make(map[string]Tool),
+		mcpClients: make(map[string]*mcp.MCPClient),
+	}
+	registry.Register(&ReadFileTool{fsAccess: &config.FilesystemAccess{}})
+	registry.addMCPClient(newTestMCPClient(t, "docs", "search", "read_file"))
+	registry.addMCPClient(newTestMCPClient(t, "issues", "search"))
+
+	names := func(ts *config.Toolset) ([]string, error) {
+		active, err := registry.GetActiveTools(ts)
+		var names []string
+		for _, tool := range active {
+			names = append(names, tool.Name())
+		}
+		return names, err
+	}
+
+	// Tools of different servers with the same name do not collide.
+	got, err := names(&config.Toolset{Name: "all", Tools: []string{"read_file", "docs.*", "issues.search", "docs.search"}})
+	if want := []string{"read_file", "docs__read_file", "docs__search", "issues__search"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("active tools = %v, %v; want %v", got, err, want)
+	}
+	if server, tool, ok := registry.LookupMCPTool("issues__search"); !ok || server != "issues" || tool != "search" {
+		t.Errorf("LookupMCPTool = %s, %s, %v", server, tool, ok)
+	}
+
+	// Aliases rename MCP tools, and may refer to them by qualified name.
+	got, err = names(&config.Toolset{Name: "aliased", Tools: []string{"issues__search", "docs.search"},
+		Aliases: map[string]string{"search": "issues__search", "search_docs": "docs.search"}})
+	if want := []string{"search", "search_docs"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("aliased tools = %v, %v; want %v", got, err, want)
+	}
+
+	// An alias that shadows a built-in tool is reported.
+	_, err = names(&config.Toolset{Name: "clash", Tools: []string{"read_file", "docs.read_file"},
+		Aliases: map[string]string{"read_file": "docs.read_file"}})
+	if err == nil || !strings.Contains(err.Error(), "MCP tool 'docs.read_file'") || !strings.Contains(err.Error(), "built-in tool 'read_file'") {
+		t.Errorf("expected a collision error, got %v", err)
+	}
+	for _, aliases := range []map[string]string{
+		{"search": "docs.*"},
+		{"search": "docs.search"},
+		{"bad name": "issues.search"},
+	} {
+		if _, err := names(&config.Toolset{Name: "bad", Tools: []string{"issues.search"}, Aliases: aliases}); err == nil {
+			t.Errorf("expected aliases %v to be rejected", aliases)
+		}
+	}
+
+	// The alias forwards calls to the server's tool.
+	active, err := registry.GetActiveTools(&config.Toolset{Name: "call", Tools: []string{"issues.search"}, Aliases: map[string]string{"find_issues": "issues.search"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := active[0].Execute(context.Background(), nil); err != nil || result.Text() != "issues.search" {
+		t.Errorf("aliased call = %q, %v", result.Text(), err)
+	}
+}
+
+func TestQualifiedName(t *testing.T) {
+	if got := mcp.QualifiedName("my.server", "get:item"); got != "my_server__get_item" {
+		t.Errorf("QualifiedName = %q", got)
+	}
+	long := strings.Repeat("x", 70)
+	a, b := mcp.QualifiedName("s", long+"a"), mcp.QualifiedName("s", long+"b")
+	if len(a) != 64 || a == b {
+		t.Errorf("long names not shortened uniquely: %q, %q", a, b)
+	}
+}
+
+func TestMCPServerProcess(t *testing.T) {
+	host := map[string]string{"PATH": "/bin", "PSE_API_KEY": "k3y", "PSE_CX": "cx1", "HTTPS_PROXY": "http://proxy", "OTHER": "x"}
+	var environ []string
+	for _, name := range []string{"PATH", "PSE_API_KEY", "PSE_CX", "HTTPS_PROXY", "OTHER"} {
+		environ = append(environ, name+"="+host[name])
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := host[name]
+		return value, ok
+	}
+
+	server := config.MCPServer{
+		Name:    "pse",
+		Command: "npx",
+		Args:    []string{"-y", "google-pse-mcp", "${PSE_API_KEY}", "${PSE_CX}"},
+		EnvFrom: []string{"HTTPS_PROXY", "NO_PROXY"},
+		Env:     map[string]string{"API_KEY": "${PSE_API_KEY}", "MODE": "search", "PATH": "/opt/bin"},
+		Cwd:     "tools",
+	}
+	args, env, dir, err := mcpServerProcess(server, environ, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-y", "google-pse-mcp", "k3y", "cx1"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+	// Only PATH is inherited; env_from and env add to it, and env overrides.
+	if want := []string{"PATH=/bin", "HTTPS_PROXY=http://proxy", "API_KEY=k3y", "MODE=search", "PATH=/opt/bin"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+	if dir != "tools" {
+		t.Errorf("dir = %q", dir)
+	}
+
+	// Unset variables are named, and values never show up in errors.
+	server.Args = []string{"${MISSING_KEY}"}
+	if _, _, _, err := mcpServerProcess(server, environ, lookup); err == nil || !strings.Contains(err.Error(), "MISSING_KEY") {
+		t.Errorf("expected an error naming MISSING_KEY, got %v", err)
+	}
+	server.Args = nil
+	server.Env = map[string]string{"TOKEN": "${PSE_API_KEY}-${MISSING_KEY}"}
+	if _, _, _, err := mcpServerProcess(server, environ, lookup); err == nil || strings.Contains(err.Error(), "k3y") {
+		t.Errorf("expected an error without the key, got %v", err)
+	}
+}
+
+func TestMCPServerSecrets(t *testing.T) {
+	t.Setenv("TEAM_MCP_BEARER", "team-token-0123456789")
+	t.Setenv("FORWARDED_VALUE", "forwarded-credential")
+	cfg := &config.Config{AdditionalMCPServers: []config.MCPServer{
+		{Name: "local", Command: "server", EnvFrom: []string{"FORWARDED_VALUE"}, Env: map[string]string{"SERVICE_API_KEY": "configured-credential", "MODE": "verbose-debugging"}},
+		{Name: "remote", Transport: config.MCPTransportHTTP, URL: "https://mcp.example.com", Headers: map[string]string{
+			"Authorization": "Bearer ${TEAM_MCP_BEARER}",
+			"X-API-Key":     "header-api-key-value",
+			"Accept":        "application/json",
+		}},
+	}}
+	r, err := secrets.NewRedactor(&config.Redaction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddSecrets(MCPServerSecrets(cfg))
+
+	got := r.Redact("team-token-0123456789 forwarded-credential configured-credential header-api-key-value verbose-debugging application/json")
+	if want := "[REDACTED:env] [REDACTED:env] [REDACTED:env] [REDACTED:env] verbose-debugging application/json"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}